@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+)
+
+// runExplain prints every check deciding about a single namespace, nothing is deleted
+func runExplain(config gc.Config, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: %s %s [flags] <namespace>", os.Args[0], explainCommand)
+	}
+	name := args[0]
+
+	k8s, policyClient := newClients(config)
+	rules, _ := newRules(config, k8s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rules = withPolicies(ctx, policyClient, rules)
+
+	decision, err := gc.ExplainNamespace(ctx, k8s, rules, name)
+	if err != nil {
+		log.Fatalf("failed to explain namespace %s: %v", name, err)
+	}
+
+	err = printExplanation(os.Stdout, decision, time.Now())
+	if err != nil {
+		log.Fatalf("failed to print explanation: %v", err)
+	}
+}
+
+func printExplanation(out io.Writer, decision gc.Decision, now time.Time) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "namespace:\t%s\n", decision.Namespace)
+	for _, step := range decision.Steps {
+		fmt.Fprintf(w, "  %s:\t%s\n", step.Check, step.Result)
+	}
+
+	fmt.Fprintf(w, "verdict:\t%s\n", decision.Verdict())
+	fmt.Fprintf(w, "reason:\t%s\n", decision.Reason)
+	if decision.Rule != "" {
+		fmt.Fprintf(w, "rule:\t%s\n", decision.Rule)
+	}
+
+	switch {
+	case decision.Delete && decision.DryRun:
+		fmt.Fprintf(w, "deletable:\tnow, the policy %s only logs deletions\n", decision.Policy)
+	case decision.Delete:
+		fmt.Fprintf(w, "deletable:\tnow\n")
+	case decision.Expires:
+		deletableAt := now.Add(time.Duration(decision.ExpiresIn) * time.Second)
+		fmt.Fprintf(w, "deletable:\tat %s (in %s)\n", deletableAt.Format(time.RFC3339), time.Duration(decision.ExpiresIn)*time.Second)
+	default:
+		fmt.Fprintf(w, "deletable:\tnever, unless the reason changes\n")
+	}
+
+	return w.Flush()
+}
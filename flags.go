@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+)
+
+// override applies a flag to the field of the config, the field is named like in the config file for errors
+type override struct {
+	field string
+	apply func()
+}
+
+// parseConfig reads the config file if one is passed and applies all explicitly set flags on top of it, it returns the arguments following the flags
+func parseConfig(args []string) (gc.Config, []string) {
+	defaults := gc.DefaultConfig()
+
+	var configFile = flag.String("config", "", "(optional) path to a YAML or JSON config file, flags override its values")
+	var dryRun = flag.Bool("dry-run", defaults.DryRun, "execute in dry-run mode - no changes will be applied")
+	var kubeconfig = flag.String("kubeconfig", defaults.Kubeconfig, "(optional) absolute path to the kubeconfig file")
+	var gitlabURL = flag.String("gitlabURL", defaults.Gitlab.URL, "(optional) base URL of the gitlab instance to delete namespaces whose branch or merge request is gone, the token is read from GITLAB_TOKEN")
+	var gitlabTimeout = flag.Duration("gitlabTimeout", defaults.Gitlab.Timeout.Duration, "timeout of requests to the gitlab API")
+	var gitlabStopEnvironments = flag.Bool("gitlabStopEnvironments", defaults.Gitlab.StopEnvironments, "stop the gitlab environment named by the namespace annotation \""+defaults.Gitlab.EnvironmentAnnotation+"\" after the namespace was deleted, requires -gitlabURL and a token with the api scope")
+	var trafficPrometheusURL = flag.String("trafficPrometheusURL", defaults.Traffic.PrometheusURL, "(optional) base URL of a prometheus API to read the request rates of namespaces from, enables the age source \"traffic\"")
+	var trafficWindow = flag.Duration("trafficWindow", defaults.Traffic.Window.Duration, "time range to search for the last request to a namespace, namespaces without requests in it are idle for at least the window")
+	var gitlabRunnerNamespace = flag.String("gitlabRunnerNamespace", defaults.GitlabExecutors.RunnerNamespace, "namespace to remove gitlab executors from, ignored if gitlabExecutors.targets are configured")
+	var protectedBranches = flag.String("protectedBranches", strings.Join(defaults.Namespaces.ProtectedBranches, ","), "comma separated list of substrings to mark a namespace as protected from deletion")
+	var maxGitlabExecutorAge = flag.Int64("maxGitlabExecutorAge", defaults.GitlabExecutors.MaxAge.Seconds(), "max age for gitlab executor pods in seconds")
+	var maxGitlabExecutorCompletedAge = flag.Int64("maxGitlabExecutorCompletedAge", defaults.GitlabExecutors.MaxCompletedAge.Seconds(), "max seconds to keep Succeeded or Failed gitlab executor pods after their containers terminated, 0 disables the rule")
+	var maxGitlabExecutorStuckAge = flag.Int64("maxGitlabExecutorStuckAge", defaults.GitlabExecutors.MaxStuckAge.Seconds(), "max age in seconds for Pending gitlab executor pods failing to pull images or to create containers, 0 disables the rule")
+	var maxGitlabExecutorUnreachableAge = flag.Int64("maxGitlabExecutorUnreachableAge", defaults.GitlabExecutors.MaxUnreachableAge.Seconds(), "max seconds to keep gitlab executor pods on NotReady nodes, 0 disables the rule, nodes have to be listable")
+	var forceDeleteUnreachableExecutors = flag.Bool("forceDeleteUnreachableExecutors", defaults.GitlabExecutors.ForceDeleteUnreachable, "delete gitlab executor pods of the unreachable rule without grace period, the kubelet can't confirm the deletion, so their containers may keep running on a partitioned node")
+	var maxGitlabExecutorOrphanAge = flag.Int64("maxGitlabExecutorOrphanAge", defaults.GitlabExecutors.MaxOrphanAge.Seconds(), "max age in seconds for secrets, config maps, services and persistent volume claims of gone gitlab executor pods, 0 disables the clean up")
+	var maxReviewNamespaceAge = flag.Int64("maxReviewNamespaceAge", defaults.Namespaces.MaxReviewAge.Seconds(), "max age for review namespaces in seconds")
+	var maxBuildNamespaceAge = flag.Int64("maxBuildNamespaceAge", defaults.Namespaces.MaxBuildAge.Seconds(), "max age for e2e testing namespaces in seconds")
+	var optOutAnnotations = flag.String("optOutAnnotations", strings.Join(defaults.Namespaces.OptOutAnnotations, ","), "comma separated list of annotations to protect namespaces from deletion, annotations need to be set to the string 'true'")
+	var ttlAnnotation = flag.String("ttlAnnotation", defaults.Namespaces.TTLAnnotation, "name of the annotation (key) to define the time to life for for the namespace")
+	var keepUntilAnnotation = flag.String("keepUntilAnnotation", defaults.Namespaces.KeepUntilAnnotation, "name of the annotation (key) holding an RFC3339 time to keep the namespace until, capped by -maxNamespaceExtension")
+	var lastUsedAtAnnotation = flag.String("lastUsedAtAnnotation", defaults.Namespaces.LastUsedAtAnnotation, "name of the annotation (key) holding an RFC3339 heartbeat, the namespace expires its max age after the heartbeat, capped by -maxNamespaceExtension")
+	var maxNamespaceExtension = flag.Duration("maxNamespaceExtension", defaults.Namespaces.MaxExtension.Duration, "max duration the keep-until and last-used-at annotations extend the lifetime of a namespace beyond its max age, 0 ignores them")
+	var onlyUseAgesOf = flag.String("onlyUseAgesOf", strings.Join(defaults.Namespaces.OnlyUseAgesOf, ","), fmt.Sprintf("comma separated list of kubernetes resources to use for age evaluation: \"%s\" or any namespaced resource like \"jobs.batch\", the suffix \":activity\" uses the latest change instead of the creation", strings.Join(gc.AvailableAgeFuncNames(), ",")))
+	var annotateNamespaces = flag.Bool("annotateNamespaces", defaults.Namespaces.Annotate, "write the annotations expires-at, gc-class and last-evaluated onto kept ci namespaces")
+	var hibernateNamespacesAfter = flag.Duration("hibernateNamespacesAfter", defaults.Namespaces.HibernateAfter.Duration, "scale deployments and stateful sets of ci namespaces idle this long to zero and suspend their cron jobs, only namespaces with a larger max age are hibernated, 0 disables the hibernation")
+	var namespaceWarningPeriod = flag.Duration("namespaceWarningPeriod", defaults.Namespaces.WarningPeriod.Duration, "label deletable namespaces as scheduled for deletion and delete them once they stayed deletable this long, 0 deletes immediately")
+	var useNamespaceGCPolicies = flag.Bool("useNamespaceGCPolicies", defaults.Namespaces.UsePolicies, "evaluate namespaces by NamespaceGCPolicy resources, the custom resource definition has to be installed")
+	var mode = flag.String("mode", defaults.Mode, fmt.Sprintf("\"%s\" cleans up once and exits, \"%s\" keeps running and deletes resources as soon as they expire", gc.ModeOneshot, gc.ModeController))
+	var logFormat = flag.String("log-format", defaults.LogFormat, fmt.Sprintf("\"%s\" or \"%s\", namespace decisions are logged with their reason code, rule and the source of the max age and the age", gc.LogFormatText, gc.LogFormatJSON))
+	var resyncInterval = flag.Duration("resyncInterval", defaults.ResyncInterval.Duration, "interval to re-evaluate all resources in controller mode")
+	var events = flag.Bool("events", defaults.Events.Enabled, "record kubernetes events on deleted and skipped namespaces and pods")
+	var eventsDeletionNotice = flag.Duration("eventsDeletionNotice", defaults.Events.DeletionNotice.Duration, "announce the deletion of a namespace by an event this long before, 0 to disable")
+	var archive = flag.Bool("archive", defaults.Archive.Enabled, "export the objects of namespaces to a tar.gz in -archiveDirectory or -archiveS3Bucket before they are deleted")
+	var archiveDirectory = flag.String("archiveDirectory", defaults.Archive.Directory, "local directory to write the archives of deleted namespaces to")
+	var archiveS3Endpoint = flag.String("archiveS3Endpoint", defaults.Archive.S3.Endpoint, "URL of the S3 compatible object store, the keys are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	var archiveS3Bucket = flag.String("archiveS3Bucket", defaults.Archive.S3.Bucket, "bucket to write the archives of deleted namespaces to")
+	var archiveRetention = flag.Duration("archiveRetention", defaults.Archive.Retention.Duration, "delete archives older than this, 0 keeps them forever")
+	var archiveTimeout = flag.Duration("archiveTimeout", defaults.Archive.Timeout.Duration, "limit of the archive of each namespace, a namespace whose archive fails is kept")
+	var metricsAddress = flag.String("metricsAddress", defaults.Metrics.Address, "address to serve prometheus metrics on /metrics in controller mode, empty to disable")
+	var pushgatewayURL = flag.String("pushgatewayURL", defaults.Metrics.PushgatewayURL, "(optional) URL of a prometheus pushgateway to push the metrics to after a oneshot run")
+	var leaderElect = flag.Bool("leaderElect", defaults.LeaderElection.Enabled, "use a Lease to elect a single acting replica in controller mode")
+	var leaderElectionLeaseName = flag.String("leaderElectionLeaseName", defaults.LeaderElection.LeaseName, "name of the Lease used for leader election")
+	var leaderElectionLeaseNamespace = flag.String("leaderElectionLeaseNamespace", defaults.LeaderElection.LeaseNamespace, "namespace of the Lease used for leader election (defaults to the namespace of the service account or \"default\")")
+	var leaderElectionIdentity = flag.String("leaderElectionIdentity", defaults.LeaderElection.Identity, "identity of this replica in the Lease (defaults to the hostname)")
+	var leaderElectionLeaseDuration = flag.Duration("leaderElectionLeaseDuration", defaults.LeaderElection.LeaseDuration.Duration, "duration standbys wait before taking over a not renewed Lease")
+	var leaderElectionRenewDeadline = flag.Duration("leaderElectionRenewDeadline", defaults.LeaderElection.RenewDeadline.Duration, "duration the leader retries to renew the Lease before it stops acting")
+	var leaderElectionRetryPeriod = flag.Duration("leaderElectionRetryPeriod", defaults.LeaderElection.RetryPeriod.Duration, "duration between attempts to acquire or renew the Lease")
+
+	_ = flag.CommandLine.Parse(args)
+
+	config := defaults
+	source := gc.ConfigSource{}
+	if *configFile != "" {
+		var err error
+		config, source, err = gc.LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("failed to load config: %v", err)
+		}
+	}
+
+	overrides := map[string]override{
+		"dry-run":                         {"dryRun", func() { config.DryRun = *dryRun }},
+		"kubeconfig":                      {"kubeconfig", func() { config.Kubeconfig = *kubeconfig }},
+		"gitlabURL":                       {"gitlab.url", func() { config.Gitlab.URL = *gitlabURL }},
+		"gitlabTimeout":                   {"gitlab.timeout", func() { config.Gitlab.Timeout = gc.Duration{Duration: *gitlabTimeout} }},
+		"gitlabStopEnvironments":          {"gitlab.stopEnvironments", func() { config.Gitlab.StopEnvironments = *gitlabStopEnvironments }},
+		"trafficPrometheusURL":            {"traffic.prometheusURL", func() { config.Traffic.PrometheusURL = *trafficPrometheusURL }},
+		"trafficWindow":                   {"traffic.window", func() { config.Traffic.Window = gc.Duration{Duration: *trafficWindow} }},
+		"gitlabRunnerNamespace":           {"gitlabExecutors.runnerNamespace", func() { config.GitlabExecutors.RunnerNamespace = *gitlabRunnerNamespace }},
+		"protectedBranches":               {"namespaces.protectedBranches", func() { config.Namespaces.ProtectedBranches = strings.Split(*protectedBranches, ",") }},
+		"maxGitlabExecutorAge":            {"gitlabExecutors.maxAge", func() { config.GitlabExecutors.MaxAge = seconds(*maxGitlabExecutorAge) }},
+		"maxGitlabExecutorCompletedAge":   {"gitlabExecutors.maxCompletedAge", func() { config.GitlabExecutors.MaxCompletedAge = seconds(*maxGitlabExecutorCompletedAge) }},
+		"maxGitlabExecutorStuckAge":       {"gitlabExecutors.maxStuckAge", func() { config.GitlabExecutors.MaxStuckAge = seconds(*maxGitlabExecutorStuckAge) }},
+		"maxGitlabExecutorUnreachableAge": {"gitlabExecutors.maxUnreachableAge", func() { config.GitlabExecutors.MaxUnreachableAge = seconds(*maxGitlabExecutorUnreachableAge) }},
+		"forceDeleteUnreachableExecutors": {"gitlabExecutors.forceDeleteUnreachable", func() { config.GitlabExecutors.ForceDeleteUnreachable = *forceDeleteUnreachableExecutors }},
+		"maxGitlabExecutorOrphanAge":      {"gitlabExecutors.maxOrphanAge", func() { config.GitlabExecutors.MaxOrphanAge = seconds(*maxGitlabExecutorOrphanAge) }},
+		"maxReviewNamespaceAge":           {"namespaces.maxReviewAge", func() { config.Namespaces.MaxReviewAge = seconds(*maxReviewNamespaceAge) }},
+		"maxBuildNamespaceAge":            {"namespaces.maxBuildAge", func() { config.Namespaces.MaxBuildAge = seconds(*maxBuildNamespaceAge) }},
+		"optOutAnnotations":               {"namespaces.optOutAnnotations", func() { config.Namespaces.OptOutAnnotations = strings.Split(*optOutAnnotations, ",") }},
+		"ttlAnnotation":                   {"namespaces.ttlAnnotation", func() { config.Namespaces.TTLAnnotation = *ttlAnnotation }},
+		"keepUntilAnnotation":             {"namespaces.keepUntilAnnotation", func() { config.Namespaces.KeepUntilAnnotation = *keepUntilAnnotation }},
+		"lastUsedAtAnnotation":            {"namespaces.lastUsedAtAnnotation", func() { config.Namespaces.LastUsedAtAnnotation = *lastUsedAtAnnotation }},
+		"maxNamespaceExtension":           {"namespaces.maxExtension", func() { config.Namespaces.MaxExtension = gc.Duration{Duration: *maxNamespaceExtension} }},
+		"onlyUseAgesOf":                   {"namespaces.onlyUseAgesOf", func() { config.Namespaces.OnlyUseAgesOf = strings.Split(*onlyUseAgesOf, ",") }},
+		"useNamespaceGCPolicies":          {"namespaces.usePolicies", func() { config.Namespaces.UsePolicies = *useNamespaceGCPolicies }},
+		"annotateNamespaces":              {"namespaces.annotate", func() { config.Namespaces.Annotate = *annotateNamespaces }},
+		"hibernateNamespacesAfter":        {"namespaces.hibernateAfter", func() { config.Namespaces.HibernateAfter = gc.Duration{Duration: *hibernateNamespacesAfter} }},
+		"namespaceWarningPeriod":          {"namespaces.warningPeriod", func() { config.Namespaces.WarningPeriod = gc.Duration{Duration: *namespaceWarningPeriod} }},
+		"mode":                            {"mode", func() { config.Mode = *mode }},
+		"log-format":                      {"logFormat", func() { config.LogFormat = *logFormat }},
+		"resyncInterval":                  {"resyncInterval", func() { config.ResyncInterval = gc.Duration{Duration: *resyncInterval} }},
+		"events":                          {"events.enabled", func() { config.Events.Enabled = *events }},
+		"eventsDeletionNotice":            {"events.deletionNotice", func() { config.Events.DeletionNotice = gc.Duration{Duration: *eventsDeletionNotice} }},
+		"archive":                         {"archive.enabled", func() { config.Archive.Enabled = *archive }},
+		"archiveDirectory":                {"archive.directory", func() { config.Archive.Directory = *archiveDirectory }},
+		"archiveS3Endpoint":               {"archive.s3.endpoint", func() { config.Archive.S3.Endpoint = *archiveS3Endpoint }},
+		"archiveS3Bucket":                 {"archive.s3.bucket", func() { config.Archive.S3.Bucket = *archiveS3Bucket }},
+		"archiveRetention":                {"archive.retention", func() { config.Archive.Retention = gc.Duration{Duration: *archiveRetention} }},
+		"archiveTimeout":                  {"archive.timeout", func() { config.Archive.Timeout = gc.Duration{Duration: *archiveTimeout} }},
+		"metricsAddress":                  {"metrics.address", func() { config.Metrics.Address = *metricsAddress }},
+		"pushgatewayURL":                  {"metrics.pushgatewayURL", func() { config.Metrics.PushgatewayURL = *pushgatewayURL }},
+		"leaderElect":                     {"leaderElection.enabled", func() { config.LeaderElection.Enabled = *leaderElect }},
+		"leaderElectionLeaseName":         {"leaderElection.leaseName", func() { config.LeaderElection.LeaseName = *leaderElectionLeaseName }},
+		"leaderElectionLeaseNamespace":    {"leaderElection.leaseNamespace", func() { config.LeaderElection.LeaseNamespace = *leaderElectionLeaseNamespace }},
+		"leaderElectionIdentity":          {"leaderElection.identity", func() { config.LeaderElection.Identity = *leaderElectionIdentity }},
+		"leaderElectionLeaseDuration":     {"leaderElection.leaseDuration", func() { config.LeaderElection.LeaseDuration = gc.Duration{Duration: *leaderElectionLeaseDuration} }},
+		"leaderElectionRenewDeadline":     {"leaderElection.renewDeadline", func() { config.LeaderElection.RenewDeadline = gc.Duration{Duration: *leaderElectionRenewDeadline} }},
+		"leaderElectionRetryPeriod":       {"leaderElection.retryPeriod", func() { config.LeaderElection.RetryPeriod = gc.Duration{Duration: *leaderElectionRetryPeriod} }},
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		override, ok := overrides[f.Name]
+		if ok {
+			override.apply()
+			source.Override(override.field, f.Name)
+		}
+	})
+
+	err := config.ValidateSource(source)
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	return config, flag.Args()
+}
+
+func seconds(s int64) gc.Duration {
+	return gc.Duration{Duration: time.Duration(s) * time.Second}
+}
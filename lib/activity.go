@@ -0,0 +1,214 @@
+package gc
+
+import (
+	"context"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// FieldManager is the manager of all writes of the gc, its own writes don't count as activity
+const FieldManager = "k8s-gitlab-gc"
+
+// activitySuffix selects the time of the latest change of an age source instead of its creation, e.g. "deployment:activity"
+const activitySuffix = ":activity"
+
+// AvailableActivityFuncs are the built in age sources which can be selected with the activity suffix
+var AvailableActivityFuncs = map[string]YoungestResourceAgeFunc{
+	"namespace":   NamespaceActivityAge,
+	"pod":         YoungestPodActivityAge,
+	"deployment":  YoungestDeploymentActivityAge,
+	"statefulset": YoungestStatefulsetActivityAge,
+	"daemonset":   YoungestDaemonsetActivityAge,
+	"cronjob":     YoungestCronjobActivityAge,
+}
+
+// usesReplicaSets reports if the activity of deployments is an age source, it includes their rollouts
+func usesReplicaSets(sources []AgeSource) bool {
+	for _, source := range sources {
+		if source.Name == "deployment"+activitySuffix {
+			return true
+		}
+	}
+	return false
+}
+
+// splitActivity strips the activity suffix from an age source name
+func splitActivity(name string) (string, bool) {
+	return strings.CutSuffix(name, activitySuffix)
+}
+
+// activityTime returns the latest of the creation, the managed fields and the given times, managed fields of the
+// status subresource or written by the gc itself are ignored as they don't change what is deployed
+func activityTime(object metav1.Object, times ...metav1.Time) metav1.Time {
+	latest := object.GetCreationTimestamp()
+
+	for _, entry := range object.GetManagedFields() {
+		if entry.Subresource == "status" || entry.Manager == FieldManager || entry.Time == nil {
+			continue
+		}
+		times = append(times, *entry.Time)
+	}
+
+	for _, t := range times {
+		if latest.Before(&t) {
+			latest = t
+		}
+	}
+
+	return latest
+}
+
+func NamespaceActivityAge(_ context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	ns := api.Namespace()
+	return ResourceAge(age(activityTime(&ns.ObjectMeta))), true, nil
+}
+
+func YoungestPodActivityAge(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	pods, err := api.Pods(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	activityGetter := func(item v1.Pod) metav1.Time {
+		return activityTime(&item.ObjectMeta)
+	}
+
+	return getYoungestItemsResourceAge(pods, activityGetter)
+}
+
+// rolloutReasons are the reasons of the conditions of a deployment set by a rollout, other reasons like MinimumReplicasUnavailable
+// change with crash looping pods or scaling, e.g. by hibernation
+var rolloutReasons = map[string]bool{
+	"NewReplicaSetCreated":   true,
+	"FoundNewReplicaSet":     true,
+	"ReplicaSetUpdated":      true,
+	"NewReplicaSetAvailable": true,
+}
+
+// rolloutTransition reports if the transition of a condition was caused by a rollout
+func rolloutTransition(conditionType, reason string) bool {
+	return (conditionType == string(appsv1.DeploymentAvailable) || conditionType == string(appsv1.DeploymentProgressing)) && rolloutReasons[reason]
+}
+
+// YoungestDeploymentActivityAge includes the rollouts of each deployment, the creation of its newest replica set and the transitions
+// of its conditions caused by a rollout
+func YoungestDeploymentActivityAge(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	deployments, err := api.Deployments(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	replicaSets, err := api.ReplicaSets(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	rollouts := map[types.UID]metav1.Time{}
+	for _, replicaSet := range replicaSets {
+		owner := metav1.GetControllerOf(&replicaSet)
+		if owner == nil || owner.Kind != "Deployment" {
+			continue
+		}
+		created := replicaSet.ObjectMeta.CreationTimestamp
+		if latest := rollouts[owner.UID]; latest.Before(&created) {
+			rollouts[owner.UID] = created
+		}
+	}
+
+	activityGetter := func(item appsv1.Deployment) metav1.Time {
+		times := []metav1.Time{rollouts[item.ObjectMeta.UID]}
+		for _, condition := range item.Status.Conditions {
+			if rolloutTransition(string(condition.Type), condition.Reason) {
+				times = append(times, condition.LastTransitionTime)
+			}
+		}
+		return activityTime(&item.ObjectMeta, times...)
+	}
+
+	return getYoungestItemsResourceAge(deployments, activityGetter)
+}
+
+func YoungestStatefulsetActivityAge(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	statefulSets, err := api.StatefulSets(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	activityGetter := func(item appsv1.StatefulSet) metav1.Time {
+		return activityTime(&item.ObjectMeta)
+	}
+
+	return getYoungestItemsResourceAge(statefulSets, activityGetter)
+}
+
+func YoungestDaemonsetActivityAge(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	daemonSets, err := api.DaemonSets(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	activityGetter := func(item appsv1.DaemonSet) metav1.Time {
+		return activityTime(&item.ObjectMeta)
+	}
+
+	return getYoungestItemsResourceAge(daemonSets, activityGetter)
+}
+
+// YoungestCronjobActivityAge ignores the schedule, a cron job running every hour isn't activity
+func YoungestCronjobActivityAge(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	cronJobs, err := api.CronJobs(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	activityGetter := func(item batchv1.CronJob) metav1.Time {
+		return activityTime(&item.ObjectMeta)
+	}
+
+	return getYoungestItemsResourceAge(cronJobs, activityGetter)
+}
+
+// unstructuredActivityTime includes status.startTime, e.g. of jobs, and the transitions of the status conditions caused by
+// a rollout like those of deployment:activity
+func unstructuredActivityTime(item unstructured.Unstructured) metav1.Time {
+	times := []metav1.Time{}
+
+	startTime, found, err := unstructured.NestedString(item.Object, "status", "startTime")
+	if found && err == nil {
+		times = appendParsedTime(times, startTime)
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]any)
+		if !ok {
+			continue
+		}
+		conditionType, _ := fields["type"].(string)
+		reason, _ := fields["reason"].(string)
+		if !rolloutTransition(conditionType, reason) {
+			continue
+		}
+		if value, ok := fields["lastTransitionTime"].(string); ok {
+			times = appendParsedTime(times, value)
+		}
+	}
+
+	return activityTime(&item, times...)
+}
+
+// appendParsedTime appends the RFC3339 time, invalid times are skipped
+func appendParsedTime(times []metav1.Time, value string) []metav1.Time {
+	t := metav1.Time{}
+	err := t.UnmarshalQueryParameter(value)
+	if err != nil || t.IsZero() {
+		return times
+	}
+	return append(times, t)
+}
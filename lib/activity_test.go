@@ -0,0 +1,210 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_activityTime(t *testing.T) {
+	created := metav1.NewTime(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	later := metav1.NewTime(created.Add(time.Hour))
+	latest := metav1.NewTime(created.Add(2 * time.Hour))
+
+	tests := []struct {
+		name          string
+		managedFields []metav1.ManagedFieldsEntry
+		times         []metav1.Time
+		want          metav1.Time
+	}{
+		{
+			name: "creation",
+			want: created,
+		},
+		{
+			name: "apply",
+			managedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-client-side-apply", Time: &later},
+				{Manager: "helm", Time: &latest},
+			},
+			want: latest,
+		},
+		{
+			name: "status and own writes",
+			managedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kube-controller-manager", Subresource: "status", Time: &latest},
+				{Manager: FieldManager, Time: &latest},
+				{Manager: "kubectl-edit"},
+			},
+			want: created,
+		},
+		{
+			name:  "condition",
+			times: []metav1.Time{{}, later},
+			want:  later,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := metav1.ObjectMeta{CreationTimestamp: created, ManagedFields: tt.managedFields}
+			if got := activityTime(&meta, tt.times...); !got.Equal(&tt.want) {
+				t.Errorf("activityTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYoungestDeploymentActivityAge(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration) metav1.Time {
+		return metav1.NewTime(now.Add(-ago))
+	}
+	controller := true
+
+	api := &KubernetesAPIMock{
+		deployments: []appsv1.Deployment{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", UID: "web", CreationTimestamp: at(72 * time.Hour)},
+				Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentAvailable, Reason: "MinimumReplicasAvailable", LastUpdateTime: at(48 * time.Hour), LastTransitionTime: at(48 * time.Hour)},
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker", UID: "worker", CreationTimestamp: at(72 * time.Hour)},
+				Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Reason: "NewReplicaSetAvailable", LastUpdateTime: at(5 * time.Hour), LastTransitionTime: at(10 * time.Hour)},
+				}},
+			},
+			{
+				// a crash looping pod flips the available condition, that isn't a rollout
+				ObjectMeta: metav1.ObjectMeta{Name: "crashing", UID: "crashing", CreationTimestamp: at(72 * time.Hour)},
+				Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentAvailable, Reason: "MinimumReplicasUnavailable", LastUpdateTime: at(time.Minute), LastTransitionTime: at(time.Minute)},
+				}},
+			},
+		},
+		replicaSets: []appsv1.ReplicaSet{
+			{ObjectMeta: metav1.ObjectMeta{Name: "web-1", CreationTimestamp: at(72 * time.Hour), OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", UID: "web", Controller: &controller}}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "web-2", CreationTimestamp: at(time.Hour), OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", UID: "web", Controller: &controller}}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "orphan", CreationTimestamp: at(time.Minute)}},
+		},
+	}
+
+	got, found, err := YoungestDeploymentActivityAge(context.Background(), api)
+	if err != nil {
+		t.Fatalf("YoungestDeploymentActivityAge() error = %v", err)
+	}
+	if !found {
+		t.Fatalf("YoungestDeploymentActivityAge() found = false")
+	}
+	// the rollout of web an hour ago, not the orphaned replica set
+	if got < 3600 || got > 3601 {
+		t.Errorf("YoungestDeploymentActivityAge() = %d, want 3600", got)
+	}
+
+	api.replicaSets = nil
+	got, _, err = YoungestDeploymentActivityAge(context.Background(), api)
+	if err != nil {
+		t.Fatalf("YoungestDeploymentActivityAge() error = %v", err)
+	}
+	// the rollout reported by the progressing condition of worker
+	if got < 36000 || got > 36001 {
+		t.Errorf("YoungestDeploymentActivityAge() without rollouts = %d, want 36000", got)
+	}
+}
+
+func Test_unstructuredActivityTime(t *testing.T) {
+	job := unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":              "migrate",
+			"creationTimestamp": "2026-10-01T12:00:00Z",
+		},
+		"status": map[string]any{
+			"startTime": "2026-10-01T13:00:00Z",
+			"conditions": []any{
+				map[string]any{"type": "Complete", "lastTransitionTime": "2026-10-01T14:00:00Z"},
+			},
+		},
+	}}
+
+	// the completion isn't a rollout
+	want := metav1.NewTime(time.Date(2026, 10, 1, 13, 0, 0, 0, time.UTC))
+	if got := unstructuredActivityTime(job); !got.Equal(&want) {
+		t.Errorf("unstructuredActivityTime() = %v, want %v", got, want)
+	}
+
+	// deployments.apps:activity counts the conditions like deployment:activity
+	deployment := unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]any{
+			"name":              "worker",
+			"creationTimestamp": "2026-10-01T12:00:00Z",
+		},
+		"status": map[string]any{
+			"conditions": []any{
+				map[string]any{"type": "Progressing", "reason": "NewReplicaSetAvailable", "lastUpdateTime": "2026-10-03T12:00:00Z", "lastTransitionTime": "2026-10-02T12:00:00Z"},
+				map[string]any{"type": "Available", "reason": "MinimumReplicasUnavailable", "lastTransitionTime": "2026-10-03T12:00:00Z"},
+				map[string]any{"type": "Progressing", "reason": "NewReplicaSetAvailable", "lastTransitionTime": "yesterday"},
+			},
+		},
+	}}
+
+	want = metav1.NewTime(time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC))
+	if got := unstructuredActivityTime(deployment); !got.Equal(&want) {
+		t.Errorf("unstructuredActivityTime() of deployment = %v, want %v", got, want)
+	}
+}
+
+// TestYoungestDeploymentActivityAge_hibernated hibernates a deployment, the deployment controller updating its conditions isn't activity
+func TestYoungestDeploymentActivityAge_hibernated(t *testing.T) {
+	ctx := context.Background()
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}}
+	created := metav1.NewTime(time.Now().Add(-72 * time.Hour))
+	three := int32(3)
+
+	clientset := fake.NewClientset(
+		ns.DeepCopy(),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns.ObjectMeta.Name, Name: "web", CreationTimestamp: created},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three},
+			Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, LastUpdateTime: created, LastTransitionTime: created},
+			}},
+		},
+	)
+	api := NewKubernetesClient(clientset, ns)
+
+	_, err := hibernateNamespace(ctx, api)
+	if err != nil {
+		t.Fatalf("hibernateNamespace() error = %v", err)
+	}
+
+	deployments := clientset.AppsV1().Deployments(ns.ObjectMeta.Name)
+	deployment, err := deployments.Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deployment controller updates the conditions after scaling, the fake clientset wouldn't record it as status subresource
+	scaled := metav1.Now()
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentAvailable, Reason: "MinimumReplicasUnavailable", LastUpdateTime: scaled, LastTransitionTime: scaled},
+		{Type: appsv1.DeploymentProgressing, Reason: "NewReplicaSetAvailable", LastUpdateTime: scaled, LastTransitionTime: created},
+	}
+
+	got, found, err := YoungestDeploymentActivityAge(ctx, &KubernetesAPIMock{deployments: []appsv1.Deployment{*deployment}})
+	if err != nil || !found {
+		t.Fatalf("YoungestDeploymentActivityAge() = %d, %v, %v", got, found, err)
+	}
+	if got < 72*3600 {
+		t.Errorf("YoungestDeploymentActivityAge() after hibernation = %d, want the age of the deployment %d", got, 72*3600)
+	}
+}
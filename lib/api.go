@@ -2,6 +2,10 @@ package gc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
@@ -16,23 +20,113 @@ type KubernetesAPI interface {
 	StatefulSets(ctx context.Context) ([]appsv1.StatefulSet, error)
 	DaemonSets(ctx context.Context) ([]appsv1.DaemonSet, error)
 	CronJobs(ctx context.Context) ([]batchv1.CronJob, error)
+	ReplicaSets(ctx context.Context) ([]appsv1.ReplicaSet, error)
 	Namespace() v1.Namespace
 	DeleteCurrentNamespace(ctx context.Context) error
+	UpdateDeployment(ctx context.Context, deployment *appsv1.Deployment) error
+	UpdateStatefulSet(ctx context.Context, statefulSet *appsv1.StatefulSet) error
+	UpdateCronJob(ctx context.Context, cronJob *batchv1.CronJob) error
 }
 
 type ResourceAge int64
 type YoungestResourceAgeFunc func(ctx context.Context, k8sClients KubernetesAPI) (ResourceAge, bool, error)
 
+// AvailableAgeFuncs are the resources which can be selected to determine the age of a namespace
+var AvailableAgeFuncs = map[string]YoungestResourceAgeFunc{
+	"namespace":   NamespaceAge,
+	"pod":         YoungestPodAge,
+	"deployment":  YoungestDeploymentAge,
+	"statefulset": YoungestStatefulsetAge,
+	"daemonset":   YoungestDaemonsetAge,
+	"cronjob":     YoungestCronjobAge,
+}
+
+// AvailableAgeFuncNames returns the sorted keys of AvailableAgeFuncs
+func AvailableAgeFuncNames() []string {
+	names := []string{}
+	for name := range AvailableAgeFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AgeSource is an age function and the name it was selected by
+type AgeSource struct {
+	Name     string
+	Youngest YoungestResourceAgeFunc
+}
+
+// AgeSourceClients resolve the age sources which aren't built in, age sources of nil clients are rejected
+type AgeSourceClients struct {
+	// Resources resolves resources like "jobs.batch"
+	Resources *ResourceAges
+	// Traffic measures the time since the last request for TrafficAgeSource
+	Traffic *TrafficAges
+}
+
+// SelectAgeSources looks up the age functions by their names, names which are not in AvailableAgeFuncs
+// are resolved as resources like "jobs.batch" or as TrafficAgeSource by the clients,
+// names with the suffix ":activity" use the latest change instead of the creation
+func SelectAgeSources(names []string, clients AgeSourceClients) ([]AgeSource, error) {
+	selected := []AgeSource{}
+	for _, name := range names {
+		if name == TrafficAgeSource {
+			if clients.Traffic == nil {
+				return []AgeSource{}, fmt.Errorf("the age source \"%s\" requires a prometheus url", name)
+			}
+			selected = append(selected, AgeSource{Name: name, Youngest: clients.Traffic.Youngest})
+			continue
+		}
+
+		base, activity := splitActivity(name)
+
+		available := AvailableAgeFuncs
+		if activity {
+			available = AvailableActivityFuncs
+		}
+		ageFn, ok := available[base]
+		if ok {
+			selected = append(selected, AgeSource{Name: name, Youngest: ageFn})
+			continue
+		}
+
+		err := validateResourceName(base)
+		if err != nil {
+			return []AgeSource{}, err
+		}
+		if clients.Resources == nil {
+			return []AgeSource{}, fmt.Errorf("the resource \"%s\" requires discovery, valid options without it are: \"%s\"", name, strings.Join(AvailableAgeFuncNames(), ","))
+		}
+
+		ageFn, err = clients.Resources.AgeFunc(base, activity)
+		if err != nil {
+			return []AgeSource{}, err
+		}
+		selected = append(selected, AgeSource{Name: name, Youngest: ageFn})
+	}
+
+	return selected, nil
+}
+
 type KubernetesClient struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	namespace v1.Namespace
 }
 
+// NewKubernetesClient returns a KubernetesAPI for the given namespace backed by live api calls
+func NewKubernetesClient(clientset kubernetes.Interface, namespace v1.Namespace) *KubernetesClient {
+	return &KubernetesClient{
+		clientset: clientset,
+		namespace: namespace,
+	}
+}
+
 func (k *KubernetesClient) Pods(ctx context.Context) ([]v1.Pod, error) {
 	namespaceName := k.namespace.ObjectMeta.Name
 	pods, err := k.clientset.CoreV1().Pods(namespaceName).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError(podResource, err)
 	}
 
 	return pods.Items, nil
@@ -42,7 +136,7 @@ func (k *KubernetesClient) Deployments(ctx context.Context) ([]appsv1.Deployment
 	namespaceName := k.namespace.ObjectMeta.Name
 	deployments, err := k.clientset.AppsV1().Deployments(namespaceName).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError("deployments", err)
 	}
 
 	return deployments.Items, nil
@@ -52,7 +146,7 @@ func (k *KubernetesClient) StatefulSets(ctx context.Context) ([]appsv1.StatefulS
 	namespaceName := k.namespace.ObjectMeta.Name
 	statefulSets, err := k.clientset.AppsV1().StatefulSets(namespaceName).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError("statefulsets", err)
 	}
 
 	return statefulSets.Items, nil
@@ -62,7 +156,7 @@ func (k *KubernetesClient) DaemonSets(ctx context.Context) ([]appsv1.DaemonSet,
 	namespaceName := k.namespace.ObjectMeta.Name
 	daemonSets, err := k.clientset.AppsV1().DaemonSets(namespaceName).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError("daemonsets", err)
 	}
 
 	return daemonSets.Items, nil
@@ -72,48 +166,93 @@ func (k *KubernetesClient) CronJobs(ctx context.Context) ([]batchv1.CronJob, err
 	namespaceName := k.namespace.ObjectMeta.Name
 	cronJobs, err := k.clientset.BatchV1().CronJobs(namespaceName).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError("cronjobs", err)
 	}
 
 	return cronJobs.Items, nil
 }
 
+func (k *KubernetesClient) ReplicaSets(ctx context.Context) ([]appsv1.ReplicaSet, error) {
+	namespaceName := k.namespace.ObjectMeta.Name
+	replicaSets, err := k.clientset.AppsV1().ReplicaSets(namespaceName).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("replicasets", err)
+	}
+
+	return replicaSets.Items, nil
+}
+
 func (k *KubernetesClient) Namespace() v1.Namespace {
 	return k.namespace
 }
 
 func (k *KubernetesClient) DeleteCurrentNamespace(ctx context.Context) error {
 	namespaceName := k.namespace.ObjectMeta.Name
-	return k.clientset.CoreV1().Namespaces().Delete(ctx, namespaceName, metav1.DeleteOptions{})
+	return apiError(namespaceResource, k.clientset.CoreV1().Namespaces().Delete(ctx, namespaceName, metav1.DeleteOptions{}))
 }
 
-func youngestAge(ctx context.Context, ageFuncs []YoungestResourceAgeFunc, api KubernetesAPI) (ResourceAge, bool, error) {
-	ages := []ResourceAge{}
-	for _, ageFn := range ageFuncs {
-		age, found, err := ageFn(ctx, api)
-		if err != nil {
-			return 0, false, err
-		}
+func (k *KubernetesClient) UpdateDeployment(ctx context.Context, deployment *appsv1.Deployment) error {
+	_, err := k.clientset.AppsV1().Deployments(k.namespace.ObjectMeta.Name).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("deployments", err)
+}
 
-		if !found {
-			continue
+func (k *KubernetesClient) UpdateStatefulSet(ctx context.Context, statefulSet *appsv1.StatefulSet) error {
+	_, err := k.clientset.AppsV1().StatefulSets(k.namespace.ObjectMeta.Name).Update(ctx, statefulSet, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("statefulsets", err)
+}
+
+func (k *KubernetesClient) UpdateCronJob(ctx context.Context, cronJob *batchv1.CronJob) error {
+	_, err := k.clientset.BatchV1().CronJobs(k.namespace.ObjectMeta.Name).Update(ctx, cronJob, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("cronjobs", err)
+}
+
+// sourcedAge is the age reported by a single age function, found is false if it has no resources
+type sourcedAge struct {
+	source string
+	age    ResourceAge
+	found  bool
+}
+
+// errAgeSourceFailed marks an age source which failed for a namespace, e.g. a resource removed since discovery, the namespace is
+// skipped and the run goes on
+var errAgeSourceFailed = errors.New("skipping the namespace")
+
+// resourceAges calls every age source, the ages are in the order of the age sources
+func resourceAges(ctx context.Context, sources []AgeSource, api KubernetesAPI) ([]sourcedAge, error) {
+	ages := []sourcedAge{}
+	for _, source := range sources {
+		age, found, err := source.Youngest(ctx, api)
+		if err != nil {
+			return nil, fmt.Errorf("age source %s: %v, %w", source.Name, err, errAgeSourceFailed)
 		}
 
-		ages = append(ages, age)
+		ages = append(ages, sourcedAge{source: source.Name, age: age, found: found})
 	}
 
-	if len(ages) == 0 {
-		return 0, false, nil
+	return ages, nil
+}
+
+// youngestOf returns the youngest found age, the first one wins on equal ages, a found traffic age overrides the other
+// sources as a rollout doesn't make an unvisited namespace used
+func youngestOf(ages []sourcedAge) (sourcedAge, bool) {
+	for _, age := range ages {
+		if age.source == TrafficAgeSource && age.found {
+			return age, true
+		}
 	}
 
-	youngestResourceAge := ages[0]
+	youngest := sourcedAge{}
 	for _, age := range ages {
-		if age < youngestResourceAge {
-			youngestResourceAge = age
+		if !age.found {
+			continue
+		}
+
+		if !youngest.found || age.age < youngest.age {
+			youngest = age
 		}
 	}
 
-	return ResourceAge(youngestResourceAge), true, nil
+	return youngest, youngest.found
 }
 
 func getYoungestItemsResourceAge[item any](items []item, creationTimestampGetter func(item) metav1.Time) (ResourceAge, bool, error) {
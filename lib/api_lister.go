@@ -0,0 +1,147 @@
+package gc
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Listers bundles the informer caches used to evaluate namespaces without live list calls
+type Listers struct {
+	Namespaces   corelisters.NamespaceLister
+	Pods         corelisters.PodLister
+	Deployments  appslisters.DeploymentLister
+	StatefulSets appslisters.StatefulSetLister
+	DaemonSets   appslisters.DaemonSetLister
+	CronJobs     batchlisters.CronJobLister
+	// ReplicaSets are only cached if an age source or policies may use them, they are listed live if nil
+	ReplicaSets appslisters.ReplicaSetLister
+}
+
+// NewListers registers the required informers with the factory and returns their listers
+func NewListers(factory informers.SharedInformerFactory) *Listers {
+	return &Listers{
+		Namespaces:   factory.Core().V1().Namespaces().Lister(),
+		Pods:         factory.Core().V1().Pods().Lister(),
+		Deployments:  factory.Apps().V1().Deployments().Lister(),
+		StatefulSets: factory.Apps().V1().StatefulSets().Lister(),
+		DaemonSets:   factory.Apps().V1().DaemonSets().Lister(),
+		CronJobs:     factory.Batch().V1().CronJobs().Lister(),
+	}
+}
+
+// KubernetesListerClient is a KubernetesAPI reading from informer caches, writes still go to the api server
+type KubernetesListerClient struct {
+	clientset kubernetes.Interface
+	listers   *Listers
+	namespace v1.Namespace
+}
+
+// NewKubernetesListerClient returns a KubernetesAPI for the given namespace backed by listers
+func NewKubernetesListerClient(clientset kubernetes.Interface, listers *Listers, namespace v1.Namespace) *KubernetesListerClient {
+	return &KubernetesListerClient{
+		clientset: clientset,
+		listers:   listers,
+		namespace: namespace,
+	}
+}
+
+func (k *KubernetesListerClient) Pods(_ context.Context) ([]v1.Pod, error) {
+	pods, err := k.listers.Pods.Pods(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(pods), nil
+}
+
+func (k *KubernetesListerClient) Deployments(_ context.Context) ([]appsv1.Deployment, error) {
+	deployments, err := k.listers.Deployments.Deployments(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(deployments), nil
+}
+
+func (k *KubernetesListerClient) StatefulSets(_ context.Context) ([]appsv1.StatefulSet, error) {
+	statefulSets, err := k.listers.StatefulSets.StatefulSets(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(statefulSets), nil
+}
+
+func (k *KubernetesListerClient) DaemonSets(_ context.Context) ([]appsv1.DaemonSet, error) {
+	daemonSets, err := k.listers.DaemonSets.DaemonSets(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(daemonSets), nil
+}
+
+func (k *KubernetesListerClient) CronJobs(_ context.Context) ([]batchv1.CronJob, error) {
+	cronJobs, err := k.listers.CronJobs.CronJobs(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(cronJobs), nil
+}
+
+func (k *KubernetesListerClient) ReplicaSets(ctx context.Context) ([]appsv1.ReplicaSet, error) {
+	if k.listers.ReplicaSets == nil {
+		return NewKubernetesClient(k.clientset, k.namespace).ReplicaSets(ctx)
+	}
+
+	replicaSets, err := k.listers.ReplicaSets.ReplicaSets(k.namespace.ObjectMeta.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	return dereference(replicaSets), nil
+}
+
+func (k *KubernetesListerClient) Namespace() v1.Namespace {
+	return k.namespace
+}
+
+func (k *KubernetesListerClient) DeleteCurrentNamespace(ctx context.Context) error {
+	namespaceName := k.namespace.ObjectMeta.Name
+	return apiError(namespaceResource, k.clientset.CoreV1().Namespaces().Delete(ctx, namespaceName, metav1.DeleteOptions{}))
+}
+
+func (k *KubernetesListerClient) UpdateDeployment(ctx context.Context, deployment *appsv1.Deployment) error {
+	_, err := k.clientset.AppsV1().Deployments(k.namespace.ObjectMeta.Name).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("deployments", err)
+}
+
+func (k *KubernetesListerClient) UpdateStatefulSet(ctx context.Context, statefulSet *appsv1.StatefulSet) error {
+	_, err := k.clientset.AppsV1().StatefulSets(k.namespace.ObjectMeta.Name).Update(ctx, statefulSet, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("statefulsets", err)
+}
+
+func (k *KubernetesListerClient) UpdateCronJob(ctx context.Context, cronJob *batchv1.CronJob) error {
+	_, err := k.clientset.BatchV1().CronJobs(k.namespace.ObjectMeta.Name).Update(ctx, cronJob, metav1.UpdateOptions{FieldManager: FieldManager})
+	return apiError("cronjobs", err)
+}
+
+// dereference turns the pointers returned by listers into the values KubernetesAPI returns
+func dereference[item any](items []*item) []item {
+	values := make([]item, 0, len(items))
+	for _, i := range items {
+		values = append(values, *i)
+	}
+	return values
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -77,7 +78,7 @@ func Test_getYoungestItemsResourceAge(t *testing.T) {
 	}
 }
 
-func Test_youngestAge(t *testing.T) {
+func Test_youngestOf(t *testing.T) {
 	tests := []struct {
 		name     string
 		ageFuncs []YoungestResourceAgeFunc
@@ -148,21 +149,55 @@ func Test_youngestAge(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			got, found, err := youngestAge(ctx, tt.ageFuncs, nil)
+			ages, err := resourceAges(ctx, unnamedAgeSources(tt.ageFuncs...), nil)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("ageFns.youngestAge() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("resourceAges() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("ageFns.youngestAge() = %v, want %v", got, tt.want)
+			got, found := youngestOf(ages)
+			if got.age != tt.want {
+				t.Errorf("youngestOf() = %v, want %v", got.age, tt.want)
 			}
 			if found != tt.found {
 				t.Errorf("found = %v, want %v", found, tt.found)
 			}
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ageFns.youngestAge() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
 		})
 	}
 }
+
+func Test_youngestOf_source(t *testing.T) {
+	api := &KubernetesAPIMock{
+		namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour))}},
+		pods:      []v1.Pod{{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute))}}},
+	}
+
+	ages, err := resourceAges(context.TODO(), builtinAgeSources("namespace", "pod", "deployment"), api)
+	if err != nil {
+		t.Fatalf("resourceAges() error = %v", err)
+	}
+	youngest, found := youngestOf(ages)
+	if !found {
+		t.Fatalf("youngestOf() found = false")
+	}
+	if youngest.source != "pod" {
+		t.Errorf("youngestOf() source = %v, want pod", youngest.source)
+	}
+}
+
+// builtinAgeSources selects age sources of AvailableAgeFuncs by their names
+func builtinAgeSources(names ...string) []AgeSource {
+	sources, err := SelectAgeSources(names, AgeSourceClients{})
+	if err != nil {
+		panic(err)
+	}
+	return sources
+}
+
+// unnamedAgeSources wraps age functions of tests, their ages are reported without a source
+func unnamedAgeSources(ageFuncs ...YoungestResourceAgeFunc) []AgeSource {
+	sources := []AgeSource{}
+	for _, ageFn := range ageFuncs {
+		sources = append(sources, AgeSource{Youngest: ageFn})
+	}
+	return sources
+}
@@ -0,0 +1,391 @@
+package gc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// errArchiveFailed marks the failed archive of a namespace, the namespace is skipped and the run goes on
+var errArchiveFailed = errors.New("keeping the namespace")
+
+// archiveTimeFormat and archiveSuffix make up the key "<namespace>-<time>.tar.gz" of an archive
+const (
+	archiveTimeFormat = "20060102T150405Z"
+	archiveSuffix     = ".tar.gz"
+)
+
+// archiveKey identifies archives written by the gc, retention doesn't touch other objects of the store
+var archiveKey = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?-\d{8}T\d{6}Z\.tar\.gz$`)
+
+// ArchiveStore keeps the archives of deleted namespaces
+type ArchiveStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	List(ctx context.Context) ([]ArchivedObject, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ArchivedObject is an object of an ArchiveStore
+type ArchivedObject struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Archiver dumps all objects of a namespace to a tar.gz before the namespace is deleted
+type Archiver struct {
+	discovery discovery.DiscoveryInterface
+	dynamic   dynamic.Interface
+	store     ArchiveStore
+	// ExcludeSecrets leaves secrets out of the archives
+	ExcludeSecrets bool
+	// Retention deletes archives older than this at the end of each run, 0 keeps them forever
+	Retention time.Duration
+	// Timeout limits the archive of each namespace, 0 only applies the deadline of the caller
+	Timeout time.Duration
+
+	// resources are discovered once per run, EndRun drops them to find resources installed since
+	resources []schema.GroupVersionResource
+	lock      sync.Mutex
+}
+
+// apiTimeout limits the api calls of a single controller sync or of listing the objects of a run
+const apiTimeout = time.Minute
+
+// discardTimeout limits deleting the archive of a kept namespace, the deadline of the namespace may have passed already
+const discardTimeout = 10 * time.Second
+
+// namespaceTimeout limits the evaluation and deletion of a single namespace, archiving gets its own timeout on top
+func (r NamespaceRules) namespaceTimeout() time.Duration {
+	if r.Archiver == nil {
+		return apiTimeout
+	}
+	return apiTimeout + r.Archiver.Timeout
+}
+
+// NewArchiver lists namespaced resources by discovery and reads their objects with the dynamic client
+func NewArchiver(discoveryClient discovery.DiscoveryInterface, dynamicClient dynamic.Interface, store ArchiveStore) *Archiver {
+	return &Archiver{
+		discovery: discoveryClient,
+		dynamic:   dynamicClient,
+		store:     store,
+	}
+}
+
+// Archive writes all objects of the namespace to the store, it returns the key of the archive
+func (a *Archiver) Archive(ctx context.Context, namespace string, now time.Time) (string, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	data, err := a.dump(ctx, namespace)
+	if err != nil {
+		return "", fmt.Errorf("failed to archive namespace %s: %v", namespace, err)
+	}
+
+	key := fmt.Sprintf("%s-%s%s", namespace, now.UTC().Format(archiveTimeFormat), archiveSuffix)
+	err = a.store.Put(ctx, key, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to store archive of namespace %s: %v", namespace, err)
+	}
+
+	return key, nil
+}
+
+// EndRun prunes expired archives and drops the discovered resources, a oneshot run calls it at its end, the controller at
+// every resync
+func (a *Archiver) EndRun(ctx context.Context, now time.Time) {
+	a.lock.Lock()
+	a.resources = nil
+	a.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	err := a.Prune(ctx, now)
+	if err != nil {
+		slog.Warn("failed to prune archives", "error", err)
+	}
+}
+
+// archiveNamespace archives the namespace before its deletion if an archiver is configured, it returns the key of the
+// archive or "" without archiver
+func archiveNamespace(ctx context.Context, archiver *Archiver, namespace string) (string, error) {
+	if archiver == nil {
+		return "", nil
+	}
+
+	key, err := archiver.Archive(ctx, namespace, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("%v, %w", err, errArchiveFailed)
+	}
+
+	slog.Info("archived namespace", "namespace", namespace, "archive", key)
+	return key, nil
+}
+
+// discardArchive deletes the archive of a namespace whose deletion was refused, the namespace lives on and the stale
+// archive would otherwise count as a backup of it
+func discardArchive(archiver *Archiver, namespace, key string) {
+	if archiver == nil || key == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discardTimeout)
+	defer cancel()
+
+	err := archiver.store.Delete(ctx, key)
+	if err != nil {
+		slog.Warn("failed to delete archive of kept namespace", "namespace", namespace, "archive", key, "error", err)
+		return
+	}
+
+	slog.Info("deleted archive of kept namespace", "namespace", namespace, "archive", key)
+}
+
+// Prune deletes archives older than the retention
+func (a *Archiver) Prune(ctx context.Context, now time.Time) error {
+	if a.Retention <= 0 {
+		return nil
+	}
+
+	objects, err := a.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if !archiveKey.MatchString(object.Key) || now.Sub(object.LastModified) < a.Retention {
+			continue
+		}
+
+		err := a.store.Delete(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		slog.Info("deleted expired archive", "archive", object.Key)
+	}
+
+	return nil
+}
+
+// dump writes every listable namespaced object as YAML file "<resource>.<group>/<name>.yaml" into a tar.gz,
+// status and managed fields are stripped, events are left out
+func (a *Archiver) dump(ctx context.Context, namespace string) ([]byte, error) {
+	resources, err := a.namespacedResources()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+
+	for _, resource := range resources {
+		list, err := a.dynamic.Resource(resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, apiError(resource.Resource, err)
+		}
+
+		for _, item := range list.Items {
+			data, err := manifest(item)
+			if err != nil {
+				return nil, err
+			}
+
+			header := &tar.Header{
+				Name:    archivePath(resource, item.GetName()),
+				Mode:    0o644,
+				Size:    int64(len(data)),
+				ModTime: item.GetCreationTimestamp().Time,
+			}
+			err = tw.WriteHeader(header)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tw.Write(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// namespacedResources returns the preferred versions of all listable namespaced resources sorted by group and resource,
+// groups which failed discovery are skipped and discovered again for the next namespace
+func (a *Archiver) namespacedResources() ([]schema.GroupVersionResource, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.resources != nil {
+		return a.resources, nil
+	}
+
+	lists, err := discovery.ServerPreferredNamespacedResources(a.discovery)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	complete := err == nil
+	if err != nil {
+		slog.Warn("skipping api groups which failed discovery", "error", err)
+	}
+
+	resources := []schema.GroupVersionResource{}
+	for _, list := range lists {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") || !hasVerb(resource.Verbs, "list") || !a.archived(groupVersion.Group, resource.Name) {
+				continue
+			}
+			resources = append(resources, groupVersion.WithResource(resource.Name))
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		return resources[i].Resource < resources[j].Resource
+	})
+
+	if complete {
+		a.resources = resources
+	}
+
+	return resources, nil
+}
+
+func (a *Archiver) archived(group, resource string) bool {
+	if resource == "events" {
+		return false
+	}
+	return group != "" || resource != "secrets" || !a.ExcludeSecrets
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func archivePath(resource schema.GroupVersionResource, name string) string {
+	directory := resource.Resource
+	if resource.Group != "" {
+		directory += "." + resource.Group
+	}
+	return directory + "/" + name + ".yaml"
+}
+
+// manifest strips the status and managed fields and marshals the object to YAML
+func manifest(item unstructured.Unstructured) ([]byte, error) {
+	object := item.DeepCopy()
+	unstructured.RemoveNestedField(object.Object, "status")
+	unstructured.RemoveNestedField(object.Object, "metadata", "managedFields")
+
+	buffer := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(2)
+	err := encoder.Encode(object.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// DirectoryStore keeps archives as files in a local directory
+type DirectoryStore struct {
+	directory string
+}
+
+// NewDirectoryStore creates the directory if it doesn't exist
+func NewDirectoryStore(directory string) (*DirectoryStore, error) {
+	err := os.MkdirAll(directory, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	return &DirectoryStore{directory: directory}, nil
+}
+
+// Put writes the archive readable only by the gc, archives can contain secrets
+func (s *DirectoryStore) Put(_ context.Context, key string, data []byte) error {
+	return os.WriteFile(filepath.Join(s.directory, key), data, 0o600)
+}
+
+func (s *DirectoryStore) List(_ context.Context) ([]ArchivedObject, error) {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []ArchivedObject{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, ArchivedObject{Key: entry.Name(), LastModified: info.ModTime()})
+	}
+
+	return objects, nil
+}
+
+func (s *DirectoryStore) Delete(_ context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.directory, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Store keeps archives in a bucket of an S3 compatible object store, objects are addressed path style
+type S3Store struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	timeout time.Duration
+}
+
+// NewS3Store creates a store for the bucket at endpoint, e.g. "https://s3.eu-central-1.amazonaws.com", keys are prefixed by prefix
+func NewS3Store(endpoint, bucket, prefix, region, accessKeyID, secretAccessKey string, timeout time.Duration) (*S3Store, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure:       u.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+
+	return &S3Store{
+		client:  client,
+		bucket:  bucket,
+		prefix:  prefix,
+		timeout: timeout,
+	}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/gzip"})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, s3Error(err))
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context) ([]ArchivedObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	objects := []ArchivedObject{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list bucket %s: %v", s.bucket, s3Error(object.Err))
+		}
+		objects = append(objects, ArchivedObject{Key: strings.TrimPrefix(object.Key, s.prefix), LastModified: object.LastModified})
+	}
+
+	return objects, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", key, s3Error(err))
+	}
+	return nil
+}
+
+// s3Error adds the status of a rejected request to the error
+func s3Error(err error) error {
+	response := minio.ToErrorResponse(err)
+	if response.StatusCode == 0 {
+		return err
+	}
+	return fmt.Errorf("unexpected status %d: %v", response.StatusCode, err)
+}
@@ -0,0 +1,139 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestS3Store(t *testing.T) {
+	modified := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	mu := sync.Mutex{}
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key, _ := strings.CutPrefix(r.URL.Path, "/archives/")
+		switch {
+		case r.Method == http.MethodPut && key != "":
+			data, _ := io.ReadAll(r.Body)
+			if r.Header.Get("x-amz-content-sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+				data = decodeAWSChunked(data)
+			}
+			objects[key] = data
+		case r.Method == http.MethodDelete && key != "":
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+			keys := []string{}
+			for key := range objects {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			// one object per page to exercise the continuation
+			start := 0
+			if token := r.URL.Query().Get("continuation-token"); token != "" {
+				start = sort.SearchStrings(keys, token)
+			}
+
+			type content struct {
+				Key          string
+				LastModified time.Time
+			}
+			result := struct {
+				XMLName               xml.Name `xml:"ListBucketResult"`
+				IsTruncated           bool
+				NextContinuationToken string `xml:",omitempty"`
+				Contents              []content
+			}{}
+			if start < len(keys) {
+				result.Contents = append(result.Contents, content{Key: keys[start], LastModified: modified})
+			}
+			if start+1 < len(keys) {
+				result.IsTruncated = true
+				result.NextContinuationToken = keys[start+1]
+			}
+			_ = xml.NewEncoder(w).Encode(result)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store, err := NewS3Store(server.URL, "archives", "gc/", "us-east-1", "access", "secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a-20261017T120000Z.tar.gz", "b-20261017T120000Z.tar.gz", "c 1.tar.gz"} {
+		err := store.Put(ctx, key, []byte(key))
+		if err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if string(objects["gc/c 1.tar.gz"]) != "c 1.tar.gz" {
+		t.Errorf("Put() stored %v", objects)
+	}
+
+	err = store.Delete(ctx, "b-20261017T120000Z.tar.gz")
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	got, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []ArchivedObject{
+		{Key: "a-20261017T120000Z.tar.gz", LastModified: modified},
+		{Key: "c 1.tar.gz", LastModified: modified},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	denied, err := NewS3Store(server.URL, "archives", "gc/", "us-east-1", "denied", "secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = denied.Put(ctx, "d.tar.gz", nil)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() error = %v, want the status of the rejected request", err)
+	}
+}
+
+// decodeAWSChunked strips the chunk headers "<size>;chunk-signature=<signature>" of a signed streaming upload
+func decodeAWSChunked(body []byte) []byte {
+	data := []byte{}
+	for len(body) > 0 {
+		header, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		size, err := strconv.ParseInt(string(bytes.SplitN(header, []byte(";"), 2)[0]), 16, 64)
+		if err != nil || size == 0 || int64(len(rest)) < size {
+			break
+		}
+		data = append(data, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return data
+}
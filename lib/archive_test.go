@@ -0,0 +1,320 @@
+package gc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestArchiver_Archive(t *testing.T) {
+	object := func(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata": map[string]any{
+				"namespace":     namespace,
+				"name":          name,
+				"managedFields": []any{map[string]any{"manager": "kubectl"}},
+			},
+			"status": map[string]any{"phase": "Active"},
+		}}
+		return u
+	}
+
+	clientset := fake.NewClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"list", "delete"}},
+				{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: metav1.Verbs{"list"}},
+				{Name: "events", Namespaced: true, Kind: "Event", Verbs: metav1.Verbs{"list"}},
+				{Name: "pods/log", Namespaced: true, Kind: "Pod", Verbs: metav1.Verbs{"get"}},
+				{Name: "bindings", Namespaced: true, Kind: "Binding", Verbs: metav1.Verbs{"create"}},
+				{Name: "namespaces", Namespaced: false, Kind: "Namespace", Verbs: metav1.Verbs{"list"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: metav1.Verbs{"list"}},
+			},
+		},
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}:                 "ConfigMapList",
+		{Version: "v1", Resource: "secrets"}:                    "SecretList",
+		{Version: "v1", Resource: "events"}:                     "EventList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		object("v1", "ConfigMap", "shop-ci-feature", "app"),
+		object("v1", "ConfigMap", "other", "app"),
+		object("v1", "Secret", "shop-ci-feature", "credentials"),
+		object("v1", "Event", "shop-ci-feature", "web.1"),
+		object("apps/v1", "Deployment", "shop-ci-feature", "web"),
+	)
+
+	tests := []struct {
+		name           string
+		excludeSecrets bool
+		want           []string
+	}{
+		{
+			name:           "without secrets",
+			excludeSecrets: true,
+			want:           []string{"configmaps/app.yaml", "deployments.apps/web.yaml"},
+		},
+		{
+			name: "with secrets",
+			want: []string{"configmaps/app.yaml", "deployments.apps/web.yaml", "secrets/credentials.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewDirectoryStore(filepath.Join(t.TempDir(), "archives"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			archiver := NewArchiver(clientset.Discovery(), dynamicClient, store)
+			archiver.ExcludeSecrets = tt.excludeSecrets
+
+			now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			key, err := archiver.Archive(context.Background(), "shop-ci-feature", now)
+			if err != nil {
+				t.Fatalf("Archive() error = %v", err)
+			}
+			if key != "shop-ci-feature-20261017T120000Z.tar.gz" {
+				t.Errorf("Archive() = %s", key)
+			}
+
+			info, err := os.Stat(filepath.Join(store.directory, key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("archive mode = %v, want -rw-------", info.Mode().Perm())
+			}
+
+			files := readArchive(t, filepath.Join(store.directory, key))
+
+			names := []string{}
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("archived files = %v, want %v", names, tt.want)
+			}
+
+			configMap := files["configmaps/app.yaml"]
+			if !strings.Contains(configMap, "name: app") || strings.Contains(configMap, "managedFields") || strings.Contains(configMap, "status") {
+				t.Errorf("configmaps/app.yaml = %s, want the object without status and managed fields", configMap)
+			}
+		})
+	}
+}
+
+func readArchive(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
+func TestArchiver_Prune(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	directory := t.TempDir()
+
+	files := map[string]time.Time{
+		"old-20260901T120000Z.tar.gz":    now.Add(-45 * 24 * time.Hour),
+		"recent-20261010T120000Z.tar.gz": now.Add(-7 * 24 * time.Hour),
+		"notes.txt":                      now.Add(-45 * 24 * time.Hour),
+		"backup.tar.gz":                  now.Add(-45 * 24 * time.Hour),
+		"db-dump-2026-09-01.tar.gz":      now.Add(-45 * 24 * time.Hour),
+	}
+	for name, modified := range files {
+		path := filepath.Join(directory, name)
+		err := os.WriteFile(path, []byte{}, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewDirectoryStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := NewArchiver(nil, nil, store)
+	archiver.Retention = 30 * 24 * time.Hour
+
+	err = archiver.Prune(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	objects, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, object := range objects {
+		got = append(got, object.Key)
+	}
+	sort.Strings(got)
+
+	want := []string{"backup.tar.gz", "db-dump-2026-09-01.tar.gz", "notes.txt", "recent-20261010T120000Z.tar.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kept files = %v, want %v", got, want)
+	}
+}
+
+// blockingStore hangs on uploads of the archives of the blocked namespace until the context is done
+type blockingStore struct {
+	ArchiveStore
+	blocked string
+}
+
+func (s blockingStore) Put(ctx context.Context, key string, data []byte) error {
+	if strings.HasPrefix(key, s.blocked+"-") {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.ArchiveStore.Put(ctx, key, data)
+}
+
+func TestArchiver_Archive_timeout(t *testing.T) {
+	directory, err := NewDirectoryStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewClientset()
+	archiver := NewArchiver(clientset.Discovery(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), blockingStore{directory, "shop-ci-hanging"})
+	archiver.Timeout = 10 * time.Millisecond
+
+	// the deadline of the caller is far away, the archive of a single namespace must not use it up
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	_, err = archiver.Archive(ctx, "shop-ci-hanging", time.Now())
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Archive() error = %v, want the timeout", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("the timeout of the archive canceled the caller")
+	}
+}
+
+// TestArchiver_EndRun discovers the archived resources once per run and prunes the expired archives at its end
+func TestArchiver_EndRun(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	directory := t.TempDir()
+	expired := filepath.Join(directory, "old-20260901T120000Z.tar.gz")
+	err := os.WriteFile(expired, []byte{}, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(expired, now.Add(-45*24*time.Hour), now.Add(-45*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewDirectoryStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"list"}}},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{{Version: "v1", Resource: "configmaps"}: "ConfigMapList"},
+	)
+	archiver := NewArchiver(clientset.Discovery(), dynamicClient, store)
+	archiver.Retention = 30 * 24 * time.Hour
+
+	discoveries := func() int {
+		count := 0
+		for _, action := range clientset.Actions() {
+			if action.GetResource().Resource == "group" {
+				count++
+			}
+		}
+		return count
+	}
+
+	for _, namespace := range []string{"shop-ci-feature", "shop-ci-fix"} {
+		_, err := archiver.Archive(context.Background(), namespace, now)
+		if err != nil {
+			t.Fatalf("Archive() error = %v", err)
+		}
+	}
+	if got := discoveries(); got != 1 {
+		t.Errorf("discoveries = %d, want a single one for the run", got)
+	}
+	if _, err := os.Stat(expired); err != nil {
+		t.Errorf("the expired archive was pruned during the run: %v", err)
+	}
+
+	archiver.EndRun(context.Background(), now)
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("the expired archive wasn't pruned at the end of the run: %v", err)
+	}
+
+	_, err = archiver.Archive(context.Background(), "shop-ci-feature", now)
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	if got := discoveries(); got != 2 {
+		t.Errorf("discoveries = %d, want the next run to discover again", got)
+	}
+}
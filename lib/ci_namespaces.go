@@ -2,8 +2,11 @@ package gc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,138 +15,610 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-var hashRegex = regexp.MustCompile("[0-9a-fA-F]{15,}$")
+// NamespaceRules configures which namespaces are garbage collected and when
+type NamespaceRules struct {
+	AgeSources        []AgeSource
+	ProtectedBranches []string
+	OptOutAnnotations []string
+	TTLAnnotation     string
+	// KeepUntilAnnotation and LastUsedAtAnnotation extend the lifetime of a namespace by at most MaxExtension seconds, 0 ignores them
+	KeepUntilAnnotation  string
+	LastUsedAtAnnotation string
+	MaxExtension         int64
+	// Classes are matched in order, DefaultNamespaceClasses of MaxTestingAge and MaxReviewAge are used if nil
+	Classes       []NamespaceClass
+	MaxTestingAge int64
+	MaxReviewAge  int64
+	// NameParsers extract project, branch, pipeline and sha by named capture groups, the first matching parser applies
+	NameParsers []*regexp.Regexp
+	// Gitlab deletes namespaces whose branch or merge request is gone immediately, it is not consulted if nil
+	Gitlab GitlabAPI
+	// StopEnvironmentAnnotation names the annotation holding the gitlab environment to stop after a namespace is deleted, no environment is stopped if empty
+	StopEnvironmentAnnotation string
+	// Events records deletions and notable skips on the namespaces, no events are recorded if nil
+	Events *Events
+	// DeletionNotice is the number of seconds before its deletion a namespace gets an event announcing it, 0 disables the announcement
+	DeletionNotice int64
+	// AgeSourceClients resolve the age sources of policies which aren't built in, policies using age sources of nil clients are skipped
+	AgeSourceClients AgeSourceClients
+	// Archiver dumps the objects of a namespace before it is deleted, the namespace is kept if that fails, nothing is archived if nil
+	Archiver *Archiver
+	// Annotate writes the expiry, class and time of the evaluation onto kept ci namespaces
+	Annotate bool
+	// HibernateAfter is the number of seconds a namespace is idle before its workloads are scaled to zero, 0 disables the hibernation
+	HibernateAfter int64
+	// WarningPeriod is the number of seconds a deletable namespace is labeled ScheduledForDeletionLabel before it is deleted, 0 deletes immediately
+	WarningPeriod int64
+
+	// PolicyAllowlist are patterns of namespaces policies may select although they are no ci namespaces, policies only apply to ci namespaces otherwise
+	PolicyAllowlist []*regexp.Regexp
+	// SystemNamespaces are never evaluated besides default and the kube-* namespaces, e.g. the namespace of the gc
+	SystemNamespaces []string
+
+	// policies are sorted by priority, see WithPolicies
+	policies []namespacePolicy
+}
+
+// reasons a namespace is deleted or kept for
+const (
+	reasonTerminating     = "terminating"
+	reasonProtected       = "protected"
+	reasonNotCI           = "not-ci"
+	reasonOptedOut        = "opted-out"
+	reasonTooYoung        = "too-young"
+	reasonExpired         = "expired"
+	reasonGitlab          = "gitlab"
+	reasonInvalidTTL      = "invalid-ttl"
+	reasonMissingAge      = "missing-age"
+	reasonExtended        = "extended"
+	reasonScheduled       = "scheduled"
+	reasonHibernated      = "hibernated"
+	reasonSystem          = "system"
+	reasonRescued         = "rescued"
+	reasonAgeSourceFailed = "age-source-failed"
+)
+
+// sources of the max age of a namespace
+const (
+	maxAgeFromFlag          = "flag"
+	maxAgeFromTTLAnnotation = "ttl-annotation"
+	maxAgeFromClass         = "class"
+	maxAgeFromPolicy        = "policy"
+)
+
+// ageFromRescue is the age source of a namespace whose rescue is younger than its resources
+const ageFromRescue = "rescue"
+
+// Decision explains why a namespace is deleted or kept
+type Decision struct {
+	Namespace string
+	Delete    bool
+	// Reason is one of the reason codes, e.g. "expired" or "protected"
+	Reason string
+	// Rule is the setting which led to the decision, e.g. "protectedBranches=main" or "class=review"
+	Rule string
+	// MaxAge is the max age in seconds, MaxAgeSource tells if it is set by a flag, the ttl annotation, a class or a policy
+	MaxAge       int64
+	MaxAgeSource string
+	// Age is the age in seconds of the youngest resource, AgeSource is the resource type supplying it
+	Age       int64
+	AgeSource string
+	// Expires is set for ci namespaces that are kept because they are too young
+	Expires bool
+	// ExpiresIn is the number of seconds until the namespace reaches its max age, including the extension
+	ExpiresIn int64
+	// Extension is the number of seconds the keep-until or last-used-at annotation ExtendedBy extends the lifetime
+	Extension  int64
+	ExtendedBy string
+	// Class is the name of the NamespaceClass the namespace belongs to
+	Class string
+	// Policy is the name of the NamespaceGCPolicy applied to the namespace
+	Policy string
+	// Name holds the values parsed from the namespace name
+	Name NamespaceName
+	// Gitlab is the reason the namespace is deleted regardless of its age
+	Gitlab string
+	// Warning describes why the namespace is skipped for an invalid ttl annotation or a missing age
+	Warning string
+	// DryRun is set by policies which only log deletions and hibernations
+	DryRun bool
+	// Hibernate scales the workloads of an idle namespace to zero
+	Hibernate bool
+	// Schedule labels a deletable namespace for the warning period, Unschedule removes the label of a namespace no longer deletable,
+	// Rescue resets the age of a namespace whose label was removed within the warning period
+	Schedule   bool
+	Unschedule bool
+	Rescue     bool
+	// Steps lists the checks in the order they were made
+	Steps []DecisionStep
+}
+
+// DecisionStep is a single check made while deciding about a namespace
+type DecisionStep struct {
+	Check  string
+	Result string
+}
+
+func (d *Decision) step(check, format string, a ...any) {
+	d.Steps = append(d.Steps, DecisionStep{Check: check, Result: fmt.Sprintf(format, a...)})
+}
+
+// Verdict is "delete" or "keep"
+func (d Decision) Verdict() string {
+	if d.Delete {
+		return "delete"
+	}
+	return "keep"
+}
 
 // ContinuousIntegrationNamespaces removes no longer used namespaces
 func ContinuousIntegrationNamespaces(
 	ctx context.Context,
-	clientset *kubernetes.Clientset,
-	ageFuncs []YoungestResourceAgeFunc,
-	protectedBranches,
-	optOutAnnotations []string,
-	ttlAnnotation string,
-	maxTestingAge,
-	maxReviewAge int64,
+	clientset kubernetes.Interface,
+	rules NamespaceRules,
 	dryRun bool,
 ) error {
+	defer observeDuration("namespaces", time.Now())
+
+	listCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
 	namespaces := clientset.CoreV1().Namespaces()
-	nss, err := namespaces.List(ctx, metav1.ListOptions{})
+	nss, err := namespaces.List(listCtx, metav1.ListOptions{})
 	if err != nil {
-		return err
+		return apiError(namespaceResource, err)
 	}
 
+	oldest := int64(0)
+	unarchived := []string{}
+	unevaluated := []string{}
 	for _, ns := range nss.Items {
-		api := &KubernetesClient{
-			namespace: ns,
-			clientset: clientset,
-		}
-
-		delete, err := shouldDeleteNamespace(
-			ctx,
-			api,
-			ageFuncs,
-			protectedBranches,
-			optOutAnnotations,
-			ttlAnnotation,
-			maxTestingAge,
-			maxReviewAge,
-		)
+		decision, err := collectNamespace(ctx, clientset, rules, ns, dryRun)
+		if errors.Is(err, errArchiveFailed) {
+			slog.Error("skipping namespace", "namespace", ns.ObjectMeta.Name, "error", err)
+			unarchived = append(unarchived, ns.ObjectMeta.Name)
+			continue
+		}
+		if errors.Is(err, errAgeSourceFailed) {
+			slog.Error("skipping namespace", "namespace", ns.ObjectMeta.Name, "error", err)
+			unevaluated = append(unevaluated, ns.ObjectMeta.Name)
+			continue
+		}
 		if err != nil {
 			return err
 		}
 
-		if delete {
-			name := ns.ObjectMeta.Name
+		if decision.Expires {
+			oldest = max(oldest, age(ns.ObjectMeta.CreationTimestamp))
+		}
+	}
 
-			fmt.Printf("deleting namespace: %s\n", name)
+	oldestNamespaceAge.Set(float64(oldest))
 
-			if dryRun {
-				continue
-			}
+	if rules.Archiver != nil && !dryRun {
+		rules.Archiver.EndRun(ctx, time.Now())
+	}
 
-			err := clientset.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
-			if err != nil {
-				return err
-			}
+	errs := []error{}
+	if len(unarchived) > 0 {
+		errs = append(errs, fmt.Errorf("kept %d namespaces whose archive failed: %s", len(unarchived), strings.Join(unarchived, ", ")))
+	}
+	if len(unevaluated) > 0 {
+		errs = append(errs, fmt.Errorf("kept %d namespaces whose age sources failed: %s", len(unevaluated), strings.Join(unevaluated, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectNamespace evaluates and deletes a single namespace within rules.namespaceTimeout
+func collectNamespace(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, ns v1.Namespace, dryRun bool) (Decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, rules.namespaceTimeout())
+	defer cancel()
+
+	api := NewKubernetesClient(clientset, ns)
+
+	decision, err := shouldDeleteNamespace(ctx, api, rules, dryRun)
+	if err != nil {
+		return decision, err
+	}
+
+	observeEvaluation(namespaceResource, decision.Name.Project, decision.Delete, decision.Reason)
+	logDecision(decision, dryRun)
+	recordDecision(rules.Events, ns, decision, dryRun, rules.DeletionNotice)
+
+	if !dryRun {
+		err := rules.scheduleDeletion(ctx, clientset, ns, decision, time.Now())
+		if err != nil {
+			return decision, err
+		}
+	}
+
+	if rules.Annotate && !dryRun {
+		err := annotateNamespace(ctx, clientset, ns, decision, time.Now())
+		if err != nil {
+			return decision, err
+		}
+	}
+
+	if decision.Hibernate {
+		err := hibernate(ctx, api, rules, decision, dryRun)
+		if err != nil {
+			return decision, err
 		}
 	}
 
+	if decision.Delete {
+		return decision, deleteNamespace(ctx, api, rules, decision, dryRun)
+	}
+
+	return decision, nil
+}
+
+// logDecision logs deletions and kept ci namespaces, namespaces which are no ci namespaces or terminating are logged at debug level
+func logDecision(decision Decision, dryRun bool) {
+	level := slog.LevelInfo
+	switch decision.Reason {
+	case reasonNotCI, reasonSystem, reasonTerminating:
+		level = slog.LevelDebug
+	case reasonInvalidTTL, reasonMissingAge:
+		level = slog.LevelWarn
+	}
+
+	message := "keeping namespace"
+	if decision.Delete {
+		message = "deleting namespace"
+	}
+
+	attrs := []slog.Attr{
+		slog.String("namespace", decision.Namespace),
+		slog.String("verdict", decision.Verdict()),
+		slog.String("reason", decision.Reason),
+	}
+	if decision.Rule != "" {
+		attrs = append(attrs, slog.String("rule", decision.Rule))
+	}
+	if decision.MaxAgeSource != "" {
+		attrs = append(attrs, slog.Int64("maxAge", decision.MaxAge), slog.String("maxAgeSource", decision.MaxAgeSource))
+	}
+	if decision.AgeSource != "" || decision.Age > 0 {
+		attrs = append(attrs, slog.Int64("age", decision.Age), slog.String("ageSource", decision.AgeSource))
+	}
+	if decision.Expires {
+		attrs = append(attrs, slog.Int64("expiresIn", decision.ExpiresIn))
+	}
+	if decision.Extension > 0 {
+		attrs = append(attrs, slog.Int64("extension", decision.Extension), slog.String("extendedBy", decision.ExtendedBy))
+	}
+	if decision.Class != "" {
+		attrs = append(attrs, slog.String("class", decision.Class))
+	}
+	if decision.Policy != "" {
+		attrs = append(attrs, slog.String("policy", decision.Policy))
+	}
+	for _, field := range decision.Name.fields() {
+		attrs = append(attrs, slog.String(field.name, field.value))
+	}
+	if decision.Gitlab != "" {
+		attrs = append(attrs, slog.String("gitlab", decision.Gitlab))
+	}
+	if decision.Warning != "" {
+		attrs = append(attrs, slog.String("warning", decision.Warning))
+	}
+	if decision.Delete {
+		attrs = append(attrs, slog.Bool("dryRun", dryRun || decision.DryRun))
+	}
+
+	slog.LogAttrs(context.Background(), level, message, attrs...)
+}
+
+func deleteNamespace(ctx context.Context, api KubernetesAPI, rules NamespaceRules, decision Decision, dryRun bool) error {
+	if dryRun || decision.DryRun {
+		observeDeletion(namespaceResource, decision.Name.Project, decision.Reason, true)
+		return nil
+	}
+
+	archive, err := archiveNamespace(ctx, rules.Archiver, api.Namespace().ObjectMeta.Name)
+	if err != nil {
+		return err
+	}
+
+	rules.Events.namespaceNow(ctx, api.Namespace(), v1.EventTypeNormal, DeletingEventReason, "deleting namespace, %s", describeDecision(decision))
+
+	err = api.DeleteCurrentNamespace(ctx)
+	if err != nil {
+		discardArchive(rules.Archiver, api.Namespace().ObjectMeta.Name, archive)
+		return err
+	}
+
+	observeDeletion(namespaceResource, decision.Name.Project, decision.Reason, false)
+
+	if rules.Gitlab != nil && rules.StopEnvironmentAnnotation != "" {
+		stopGitlabEnvironment(ctx, rules.Gitlab, api.Namespace(), rules.StopEnvironmentAnnotation)
+	}
+
 	return nil
 }
 
-func shouldDeleteNamespace(
-	ctx context.Context,
-	api KubernetesAPI,
-	ageFuncs []YoungestResourceAgeFunc,
-	protectedBranches,
-	optOutAnnotations []string,
-	ttlAnnotation string,
-	maxTestingAge,
-	maxReviewAge int64,
-) (bool, error) {
+// shouldDeleteNamespace decides if the namespace is deleted and records which rule led to the decision, dry runs don't
+// change the warning period of the namespace
+func shouldDeleteNamespace(ctx context.Context, api KubernetesAPI, rules NamespaceRules, dryRun bool) (Decision, error) {
+	decision, err := evaluateNamespace(ctx, api, rules)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	rules.applyWarningPeriod(api.Namespace(), &decision, time.Now(), dryRun)
+	return decision, nil
+}
+
+// evaluateNamespace decides if the namespace is deletable, regardless of the warning period
+func evaluateNamespace(ctx context.Context, api KubernetesAPI, rules NamespaceRules) (Decision, error) {
 	ns := api.Namespace()
+	name := ns.ObjectMeta.Name
+
+	decision := Decision{Namespace: name}
 
 	if isTerminating(ns) {
-		return false, nil
+		decision.Reason = reasonTerminating
+		decision.step("terminating", "yes")
+		return decision, nil
 	}
+	decision.step("terminating", "no")
 
-	name := ns.ObjectMeta.Name
+	if rules.isSystemNamespace(name) {
+		decision.Reason = reasonSystem
+		decision.step("system namespace", "yes")
+		return decision, nil
+	}
+
+	policy, hasPolicy := rules.matchingPolicy(ns)
+	class, isClassified := rules.classify(ns)
+	parsed, isParsed := rules.parseName(name)
+
+	switch {
+	case hasPolicy && !isClassified && !rules.allowsPolicy(name):
+		decision.step("policy", "%s ignored, the namespace is no ci namespace and not in the policy allowlist", policy.name)
+		policy, hasPolicy = namespacePolicy{}, false
+	case hasPolicy:
+		decision.step("policy", "%s, priority: %d, maxAge: %ds", policy.name, policy.priority, policy.maxAge)
+	default:
+		decision.step("policy", "none")
+	}
+
+	decision.Class = class.Name
+	decision.Policy = policy.name
+	decision.Name = parsed
+
+	if isClassified {
+		decision.step("class", "%s, maxAge: %ds", class.Name, class.MaxAge)
+	} else {
+		decision.step("class", "none")
+	}
+
+	if isParsed {
+		decision.step("name parser", "%s", parsed)
+	} else if len(rules.NameParsers) > 0 {
+		decision.step("name parser", "no parser matches")
+	}
 
-	if isProtected(name, protectedBranches) {
-		return false, nil
+	// policies add protected patterns, they can't lift the global protection
+	branch := rules.protectingBranch(name, parsed)
+	if branch != "" {
+		decision.Reason = reasonProtected
+		decision.Rule = "protectedBranches=" + branch
+		decision.step("protected branches", "%s", describeProtection(name, parsed, branch))
+		return decision, nil
+	}
+	decision.step("protected branches", "%s", describeProtection(name, parsed, ""))
+
+	if hasPolicy && len(policy.protected) > 0 {
+		pattern := policy.protectingPattern(name)
+		if pattern != "" {
+			decision.Reason = reasonProtected
+			decision.Rule = fmt.Sprintf("policy=%s protectedPatterns=%s", policy.name, pattern)
+			decision.step("protected patterns", "matched by %s", pattern)
+			return decision, nil
+		}
+		decision.step("protected patterns", "none of %d patterns matches", len(policy.protected))
 	}
 
-	if !isCI(name) {
-		return false, nil
+	if !hasPolicy && !isClassified {
+		decision.Reason = reasonNotCI
+		return decision, nil
 	}
 
-	if hasOptedOut(ns.ObjectMeta.Annotations, optOutAnnotations) {
-		return false, nil
+	annotation := optedOutBy(ns.ObjectMeta.Annotations, rules.OptOutAnnotations)
+	if annotation != "" {
+		decision.Reason = reasonOptedOut
+		decision.Rule = "optOutAnnotations=" + annotation
+		decision.step("opt-out annotations", "%s is \"true\"", annotation)
+		return decision, nil
+	}
+	decision.step("opt-out annotations", "none of %s is \"true\"", strings.Join(rules.OptOutAnnotations, ", "))
+
+	if rules.Gitlab != nil {
+		reason, err := gitlabGone(ctx, rules.Gitlab, ns)
+		if err != nil {
+			slog.Warn("falling back to age", "namespace", name, "error", err)
+			decision.step("gitlab", "falling back to age: %v", err)
+		}
+		if reason != "" {
+			decision.Delete = true
+			decision.Reason = reasonGitlab
+			decision.Rule = "gitlab"
+			decision.Gitlab = reason
+			decision.DryRun = policy.dryRun
+			decision.step("gitlab", "%s", reason)
+			return decision, nil
+		}
+		if err == nil {
+			decision.step("gitlab", "branch or merge request is in use or not referenced")
+		}
 	}
 
-	maxAge, found, err := ttlAnnotationValue(ns.ObjectMeta.Annotations, ttlAnnotation)
+	maxAge, found, err := ttlAnnotationValue(ns.ObjectMeta.Annotations, rules.TTLAnnotation)
 	if err != nil {
-		return false, err
+		decision.Reason = reasonInvalidTTL
+		decision.Rule = "ttlAnnotation=" + rules.TTLAnnotation
+		decision.Warning = fmt.Sprintf("ttl annotation %s is invalid: %v", rules.TTLAnnotation, err)
+		decision.step("ttl annotation", "%s is \"%s\", invalid: %v", rules.TTLAnnotation, ns.ObjectMeta.Annotations[rules.TTLAnnotation], err)
+		return decision, nil
 	}
 
+	switch {
+	case found:
+		decision.MaxAgeSource = maxAgeFromTTLAnnotation
+		decision.Rule = "ttlAnnotation=" + rules.TTLAnnotation
+		decision.step("ttl annotation", "%s is \"%s\", %ds", rules.TTLAnnotation, ns.ObjectMeta.Annotations[rules.TTLAnnotation], maxAge)
+	case hasPolicy:
+		maxAge = policy.maxAge
+		decision.MaxAgeSource = maxAgeFromPolicy
+		decision.Rule = "policy=" + policy.name
+	case rules.Classes == nil:
+		maxAge = class.MaxAge
+		decision.MaxAgeSource = maxAgeFromFlag
+		decision.Rule = "class=" + class.Name
+	default:
+		maxAge = class.MaxAge
+		decision.MaxAgeSource = maxAgeFromClass
+		decision.Rule = "class=" + class.Name
+	}
+	decision.MaxAge = maxAge
 	if !found {
-		isHashbased := hashRegex.MatchString(name)
+		decision.step("ttl annotation", "%s is not set", rules.TTLAnnotation)
+	}
+	decision.step("max age", "%ds from %s", maxAge, decision.MaxAgeSource)
 
-		maxAge = maxReviewAge
-		if isHashbased {
-			maxAge = maxTestingAge
-		}
+	ageSources := rules.AgeSources
+	if len(policy.ageSources) > 0 {
+		ageSources = policy.ageSources
 	}
 
-	age, found, err := youngestAge(ctx, ageFuncs, api)
+	ages, err := resourceAges(ctx, ageSources, api)
 	if err != nil {
-		return false, err
+		return Decision{}, err
 	}
 
+	for _, age := range ages {
+		if age.found {
+			decision.step("age of "+age.source, "%ds", age.age)
+		} else {
+			decision.step("age of "+age.source, "no resources")
+		}
+	}
+
+	youngest, found := youngestOf(ages)
 	if !found {
-		return false, fmt.Errorf("no item with an age was found - this should not happen")
+		sources := []string{}
+		for _, age := range ages {
+			sources = append(sources, age.source)
+		}
+		decision.Reason = reasonMissingAge
+		decision.Warning = fmt.Sprintf("no resource of the age sources %s exists", strings.Join(sources, ", "))
+		return decision, nil
 	}
 
-	if int64(age) < maxAge {
-		return false, nil
+	decision.Age = int64(youngest.age)
+	decision.AgeSource = youngest.source
+
+	// a rescued namespace starts over, regardless of the extension annotations
+	rescued, found := rescuedAge(ns.ObjectMeta.Annotations, time.Now())
+	if found && rescued < decision.Age {
+		decision.Age = rescued
+		decision.AgeSource = ageFromRescue
+		decision.step("rescue", "rescued %ds ago by removing the label %s", rescued, ScheduledForDeletionLabel)
 	}
 
-	return true, nil
+	expiresIn := maxAge - decision.Age
+
+	extension, annotation, err := rules.extension(ns.ObjectMeta.Annotations, maxAge, expiresIn, time.Now())
+	if err != nil {
+		slog.Warn("ignoring extension annotation", "namespace", name, "error", err)
+		decision.step("extension", "ignoring %v", err)
+	}
+	if extension > 0 {
+		decision.Extension = extension
+		decision.ExtendedBy = annotation
+		decision.step("extension", "%ds by %s, capped at %ds", extension, annotation, rules.MaxExtension)
+	} else if err == nil && rules.MaxExtension > 0 {
+		decision.step("extension", "none")
+	}
+
+	if expiresIn > 0 {
+		decision.Reason = reasonTooYoung
+		decision.Expires = true
+		decision.ExpiresIn = expiresIn + extension
+
+		if rules.HibernateAfter > 0 && rules.HibernateAfter < maxAge {
+			idle := rules.idleAge(ns.ObjectMeta.Annotations, decision.Age, time.Now())
+			decision.step("hibernation", "idle for %ds, hibernating after %ds", idle, rules.HibernateAfter)
+			if idle >= rules.HibernateAfter {
+				decision.Reason = reasonHibernated
+				decision.Hibernate = true
+			}
+		}
+		return decision, nil
+	}
+
+	if expiresIn+extension > 0 {
+		decision.Reason = reasonExtended
+		decision.Rule = "lastUsedAtAnnotation=" + annotation
+		if annotation == rules.KeepUntilAnnotation {
+			decision.Rule = "keepUntilAnnotation=" + annotation
+		}
+		decision.Expires = true
+		decision.ExpiresIn = expiresIn + extension
+		return decision, nil
+	}
+
+	decision.Delete = true
+	decision.Reason = reasonExpired
+	decision.DryRun = policy.dryRun
+	return decision, nil
+}
+
+// ExplainNamespace evaluates a single namespace without deleting it, the steps of the decision describe every check
+func ExplainNamespace(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, name string) (Decision, error) {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return Decision{}, apiError(namespaceResource, err)
+	}
+
+	return shouldDeleteNamespace(ctx, NewKubernetesClient(clientset, *ns), rules, false)
+}
+
+// describeProtection explains how the protected branches are compared with the namespace, branch is the matching protected branch
+func describeProtection(name string, parsed NamespaceName, branch string) string {
+	if parsed.Branch != "" {
+		if branch == "" {
+			return fmt.Sprintf("parsed branch %s is not protected", parsed.Branch)
+		}
+		return fmt.Sprintf("parsed branch %s is protected", parsed.Branch)
+	}
+
+	if branch == "" {
+		return fmt.Sprintf("%s is tagged by no protected branch", name)
+	}
+	return fmt.Sprintf("%s is tagged by %s", name, branch)
 }
 
 func NamespaceAge(_ context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
 	return ResourceAge(age(api.Namespace().ObjectMeta.CreationTimestamp)), true, nil
 }
 
-func hasOptedOut(annotations map[string]string, optOutAnnotations []string) bool {
+// optedOutBy returns the opt out annotation set to "true", the first annotation present decides
+func optedOutBy(annotations map[string]string, optOutAnnotations []string) string {
 	for _, optOutAnnotation := range optOutAnnotations {
 		optOut, ok := annotations[optOutAnnotation]
 		if !ok {
 			continue
 		}
-		return optOut == "true"
+		if optOut == "true" {
+			return optOutAnnotation
+		}
+		return ""
 	}
-	return false
+	return ""
 }
 
 func ttlAnnotationValue(annotations map[string]string, ttlAnnotation string) (maxAge int64, found bool, err error) {
@@ -165,17 +640,62 @@ func ttlAnnotationValue(annotations map[string]string, ttlAnnotation string) (ma
 	return
 }
 
-func isCI(name string) bool {
-	return isTaggedBy(name, "ci")
+// extension returns the seconds the keep-until and last-used-at annotations extend the lifetime beyond expiresIn and the annotation supplying it,
+// a heartbeat in last-used-at counts like a resource created at that time, invalid values are ignored and reported in the error
+func (r NamespaceRules) extension(annotations map[string]string, maxAge, expiresIn int64, now time.Time) (int64, string, error) {
+	if r.MaxExtension <= 0 {
+		return 0, "", nil
+	}
+
+	extension := int64(0)
+	extendedBy := ""
+	errs := []error{}
+
+	candidates := []struct {
+		annotation string
+		expiresIn  func(t time.Time) int64
+	}{
+		{r.KeepUntilAnnotation, func(t time.Time) int64 { return int64(t.Sub(now).Seconds()) }},
+		{r.LastUsedAtAnnotation, func(t time.Time) int64 { return maxAge - int64(now.Sub(t).Seconds()) }},
+	}
+	for _, candidate := range candidates {
+		value, ok := annotations[candidate.annotation]
+		if candidate.annotation == "" || !ok {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("annotation %s is not an RFC3339 time: \"%s\"", candidate.annotation, value))
+			continue
+		}
+
+		beyond := candidate.expiresIn(t) - expiresIn
+		if beyond > extension {
+			extension = beyond
+			extendedBy = candidate.annotation
+		}
+	}
+
+	return min(extension, r.MaxExtension), extendedBy, errors.Join(errs...)
 }
 
-func isProtected(name string, protectedBranches []string) bool {
+// protectingBranch returns the protected branch of the namespace, the parsed branch is compared for equality,
+// names without a parsed branch are matched by substring
+func (r NamespaceRules) protectingBranch(name string, parsed NamespaceName) string {
+	if parsed.Branch != "" {
+		return equalProtectedBranch(parsed.Branch, r.ProtectedBranches)
+	}
+	return taggingBranch(name, r.ProtectedBranches)
+}
+
+func taggingBranch(name string, protectedBranches []string) string {
 	for _, branch := range protectedBranches {
 		if isTaggedBy(name, branch) {
-			return true
+			return branch
 		}
 	}
-	return false
+	return ""
 }
 
 func isTaggedBy(s, t string) bool {
@@ -185,3 +705,8 @@ func isTaggedBy(s, t string) bool {
 func isTerminating(ns v1.Namespace) bool {
 	return ns.Status.Phase == v1.NamespaceTerminating
 }
+
+// isSystemNamespace reports default, the kube-* namespaces and the SystemNamespaces, they are never deleted
+func (r NamespaceRules) isSystemNamespace(name string) bool {
+	return name == metav1.NamespaceDefault || strings.HasPrefix(name, "kube-") || slices.Contains(r.SystemNamespaces, name)
+}
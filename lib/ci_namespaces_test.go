@@ -2,6 +2,10 @@ package gc
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +13,7 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 type KubernetesAPIMock struct {
@@ -17,9 +22,11 @@ type KubernetesAPIMock struct {
 	statefulSet      []appsv1.StatefulSet
 	daemonSet        []appsv1.DaemonSet
 	cronJobs         []batchv1.CronJob
+	replicaSets      []appsv1.ReplicaSet
 	namespace        v1.Namespace
 	err              error
 	namespaceDeleted bool
+	updated          []string
 }
 
 func (k *KubernetesAPIMock) Pods(ctx context.Context) ([]v1.Pod, error) {
@@ -42,6 +49,10 @@ func (k *KubernetesAPIMock) CronJobs(ctx context.Context) ([]batchv1.CronJob, er
 	return k.cronJobs, k.err
 }
 
+func (k *KubernetesAPIMock) ReplicaSets(ctx context.Context) ([]appsv1.ReplicaSet, error) {
+	return k.replicaSets, k.err
+}
+
 func (k *KubernetesAPIMock) Namespace() v1.Namespace {
 	return k.namespace
 }
@@ -51,6 +62,21 @@ func (k *KubernetesAPIMock) DeleteCurrentNamespace(_ context.Context) error {
 	return nil
 }
 
+func (k *KubernetesAPIMock) UpdateDeployment(_ context.Context, deployment *appsv1.Deployment) error {
+	k.updated = append(k.updated, "deployment/"+deployment.ObjectMeta.Name)
+	return k.err
+}
+
+func (k *KubernetesAPIMock) UpdateStatefulSet(_ context.Context, statefulSet *appsv1.StatefulSet) error {
+	k.updated = append(k.updated, "statefulset/"+statefulSet.ObjectMeta.Name)
+	return k.err
+}
+
+func (k *KubernetesAPIMock) UpdateCronJob(_ context.Context, cronJob *batchv1.CronJob) error {
+	k.updated = append(k.updated, "cronjob/"+cronJob.ObjectMeta.Name)
+	return k.err
+}
+
 var isHashbasedTests = []struct {
 	in  string
 	out bool
@@ -144,7 +170,27 @@ func Test_shouldDeleteNamespace(t *testing.T) {
 		ttlAnnotation     string
 		maxTestingAge     int64
 		maxReviewAge      int64
+		policies          []NamespaceGCPolicy
+		policyAllowlist   []*regexp.Regexp
+		systemNamespaces  []string
+		nameParsers       []*regexp.Regexp
 	}
+	teamPolicy := func(priority int32, maxAge time.Duration, modify func(spec *NamespaceGCPolicySpec)) NamespaceGCPolicy {
+		policy := NamespaceGCPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("team-a-%d", priority)},
+			Spec: NamespaceGCPolicySpec{
+				Priority:          priority,
+				NamespaceSelector: NamespaceSelector{NamePattern: "^team-a-"},
+				MaxAge:            metav1.Duration{Duration: maxAge},
+			},
+		}
+		if modify != nil {
+			modify(&policy.Spec)
+		}
+		return policy
+	}
+	nameParsers := []*regexp.Regexp{regexp.MustCompile("^(?P<project>.+)-ci-(?P<branch>.+)-(?P<pipeline>[0-9]+)$")}
+	teamAllowlist := []*regexp.Regexp{regexp.MustCompile("^team-a-")}
 	ageFuncs := []YoungestResourceAgeFunc{
 		func(_ context.Context, _ KubernetesAPI) (ResourceAge, bool, error) {
 			return ResourceAge(15), true, nil
@@ -385,26 +431,533 @@ func Test_shouldDeleteNamespace(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "policy selects namespace without ci tag",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-feature",
+					}},
+				},
+				ageFuncs:        ageFuncs,
+				maxReviewAge:    int64(30),
+				policies:        []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, nil)},
+				policyAllowlist: teamAllowlist,
+			},
+			want: true,
+		},
+		{
+			name: "policy ignores namespace without ci tag missing in the allowlist",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-feature",
+					}},
+				},
+				ageFuncs:     ageFuncs,
+				maxReviewAge: int64(30),
+				policies:     []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, nil)},
+			},
+			want: false,
+		},
+		{
+			name: "policy never selects system namespaces",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "kube-system",
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, func(spec *NamespaceGCPolicySpec) {
+					spec.NamespaceSelector.NamePattern = "^(kube-system|default|gitlab-gc)$"
+				})},
+				policyAllowlist: []*regexp.Regexp{regexp.MustCompile("^(kube-system|default|gitlab-gc)$")},
+			},
+			want: false,
+		},
+		{
+			name: "policy never selects the namespace of the gc",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "gitlab-gc",
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, func(spec *NamespaceGCPolicySpec) {
+					spec.NamespaceSelector.NamePattern = "^(kube-system|default|gitlab-gc)$"
+				})},
+				policyAllowlist:  []*regexp.Regexp{regexp.MustCompile("^(kube-system|default|gitlab-gc)$")},
+				systemNamespaces: []string{"gitlab-gc"},
+			},
+			want: false,
+		},
+		{
+			name: "policy max age replaces global max age",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-ci",
+					}},
+				},
+				ageFuncs:     ageFuncs,
+				maxReviewAge: int64(10),
+				policies:     []NamespaceGCPolicy{teamPolicy(1, 20*time.Second, nil)},
+			},
+			want: false,
+		},
+		{
+			name: "highest priority policy wins",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-ci",
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{
+					teamPolicy(1, 20*time.Second, nil),
+					teamPolicy(2, 10*time.Second, nil),
+				},
+			},
+			want: true,
+		},
+		{
+			name: "policy label selector does not match",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-feature",
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, func(spec *NamespaceGCPolicySpec) {
+					spec.NamespaceSelector.LabelSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+				})},
+			},
+			want: false,
+		},
+		{
+			name: "policy protected pattern",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-demo",
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, func(spec *NamespaceGCPolicySpec) {
+					spec.ProtectedPatterns = []string{"-demo$"}
+				})},
+			},
+			want: false,
+		},
+		{
+			name: "policy protected patterns keep protected branches",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-main",
+					}},
+				},
+				ageFuncs:          ageFuncs,
+				protectedBranches: []string{"main"},
+				policies: []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, func(spec *NamespaceGCPolicySpec) {
+					spec.ProtectedPatterns = []string{"-demo$"}
+				})},
+				policyAllowlist: teamAllowlist,
+			},
+			want: false,
+		},
+		{
+			name: "policy without protected patterns uses protected branches",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "team-a-main",
+					}},
+				},
+				ageFuncs:          ageFuncs,
+				protectedBranches: []string{"main"},
+				policies:          []NamespaceGCPolicy{teamPolicy(1, 10*time.Second, nil)},
+			},
+			want: false,
+		},
+		{
+			name: "policy age sources replace global age funcs",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name:              "team-a-feature",
+						CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+					}},
+				},
+				ageFuncs: ageFuncs,
+				policies: []NamespaceGCPolicy{teamPolicy(1, 30*time.Minute, func(spec *NamespaceGCPolicySpec) {
+					spec.AgeSources = []string{"namespace"}
+				})},
+				policyAllowlist: teamAllowlist,
+			},
+			want: true,
+		},
+		{
+			name: "parsed branch equal to protected branch",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "project-shop-ci-main-54823",
+					}},
+				},
+				ageFuncs:          ageFuncs,
+				protectedBranches: []string{"main"},
+				nameParsers:       nameParsers,
+			},
+			want: false,
+		},
+		{
+			name: "parsed branch containing protected branch",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "project-shop-ci-fix-main-54823",
+					}},
+				},
+				ageFuncs:          ageFuncs,
+				protectedBranches: []string{"main"},
+				maxReviewAge:      int64(10),
+				nameParsers:       nameParsers,
+			},
+			want: true,
+		},
+		{
+			name: "unparsed name falls back to substring protection",
+			args: args{
+				api: &KubernetesAPIMock{
+					namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name: "project-shop-ci-fix-main",
+					}},
+				},
+				ageFuncs:          ageFuncs,
+				protectedBranches: []string{"main"},
+				maxReviewAge:      int64(10),
+				nameParsers:       nameParsers,
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got, err := shouldDeleteNamespace(
 				context.TODO(),
 				tt.args.api,
-				tt.args.ageFuncs,
-				tt.args.protectedBranches,
-				tt.args.optOutAnnotations,
-				tt.args.ttlAnnotation,
-				tt.args.maxTestingAge,
-				tt.args.maxReviewAge,
+				NamespaceRules{
+					AgeSources:        unnamedAgeSources(tt.args.ageFuncs...),
+					ProtectedBranches: tt.args.protectedBranches,
+					OptOutAnnotations: tt.args.optOutAnnotations,
+					TTLAnnotation:     tt.args.ttlAnnotation,
+					MaxTestingAge:     tt.args.maxTestingAge,
+					MaxReviewAge:      tt.args.maxReviewAge,
+					NameParsers:       tt.args.nameParsers,
+					PolicyAllowlist:   tt.args.policyAllowlist,
+					SystemNamespaces:  tt.args.systemNamespaces,
+				}.WithPolicies(tt.args.policies),
+				false,
 			)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("shouldDeleteNamespace() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("shouldDeleteNamespace() = %v, want %v", got, tt.want)
+			if got.Delete != tt.want {
+				t.Errorf("shouldDeleteNamespace() = %v, want %v", got.Delete, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shouldDeleteNamespace_decision(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	namespace := func(name string, annotations map[string]string) *KubernetesAPIMock {
+		return &KubernetesAPIMock{
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created, Annotations: annotations}},
+			pods:      []v1.Pod{{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(time.Now().Add(-30 * time.Minute))}}},
+		}
+	}
+	rules := NamespaceRules{
+		AgeSources:        builtinAgeSources("namespace", "pod"),
+		ProtectedBranches: []string{"main"},
+		OptOutAnnotations: []string{"disable-automatic-garbage-collection"},
+		TTLAnnotation:     "ttl",
+		MaxTestingAge:     int64(time.Hour.Seconds()),
+		MaxReviewAge:      int64(24 * time.Hour.Seconds()),
+	}
+	reviewClass, err := NewNamespaceClass("preview", "-ci-", "", int64(10*time.Minute.Seconds()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		api   KubernetesAPI
+		rules NamespaceRules
+		want  Decision
+	}{
+		{
+			name:  "not ci",
+			api:   namespace("monitoring", nil),
+			rules: rules,
+			want:  Decision{Namespace: "monitoring", Reason: reasonNotCI},
+		},
+		{
+			name:  "system",
+			api:   namespace("kube-system", nil),
+			rules: rules,
+			want:  Decision{Namespace: "kube-system", Reason: reasonSystem},
+		},
+		{
+			name:  "protected branch",
+			api:   namespace("shop-ci-main", nil),
+			rules: rules,
+			want:  Decision{Namespace: "shop-ci-main", Reason: reasonProtected, Rule: "protectedBranches=main", Class: ReviewNamespaceClass},
+		},
+		{
+			name:  "opted out",
+			api:   namespace("shop-ci-feature", map[string]string{"disable-automatic-garbage-collection": "true"}),
+			rules: rules,
+			want:  Decision{Namespace: "shop-ci-feature", Reason: reasonOptedOut, Rule: "optOutAnnotations=disable-automatic-garbage-collection", Class: ReviewNamespaceClass},
+		},
+		{
+			name:  "kept by flag",
+			api:   namespace("shop-ci-feature", nil),
+			rules: rules,
+			want: Decision{Namespace: "shop-ci-feature", Reason: reasonTooYoung, Rule: "class=review", Class: ReviewNamespaceClass,
+				MaxAge: 86400, MaxAgeSource: maxAgeFromFlag, Age: 1800, AgeSource: "pod", Expires: true, ExpiresIn: 84600},
+		},
+		{
+			name: "hibernated",
+			api:  namespace("shop-ci-feature", nil),
+			rules: func() NamespaceRules {
+				rules := rules
+				rules.HibernateAfter = int64(15 * time.Minute.Seconds())
+				return rules
+			}(),
+			want: Decision{Namespace: "shop-ci-feature", Reason: reasonHibernated, Rule: "class=review", Class: ReviewNamespaceClass,
+				MaxAge: 86400, MaxAgeSource: maxAgeFromFlag, Age: 1800, AgeSource: "pod", Expires: true, ExpiresIn: 84600, Hibernate: true},
+		},
+		{
+			name:  "expired by ttl annotation",
+			api:   namespace("shop-ci-feature", map[string]string{"ttl": "15m"}),
+			rules: rules,
+			want: Decision{Namespace: "shop-ci-feature", Delete: true, Reason: reasonExpired, Rule: "ttlAnnotation=ttl", Class: ReviewNamespaceClass,
+				MaxAge: 900, MaxAgeSource: maxAgeFromTTLAnnotation, Age: 1800, AgeSource: "pod"},
+		},
+		{
+			name: "extended by keep-until annotation",
+			api:  namespace("shop-ci-feature", map[string]string{"ttl": "15m", "keep-until": "2100-01-01T00:00:00Z"}),
+			rules: func() NamespaceRules {
+				rules := rules
+				rules.KeepUntilAnnotation = "keep-until"
+				rules.MaxExtension = int64(time.Hour.Seconds())
+				return rules
+			}(),
+			want: Decision{Namespace: "shop-ci-feature", Reason: reasonExtended, Rule: "keepUntilAnnotation=keep-until", Class: ReviewNamespaceClass,
+				MaxAge: 900, MaxAgeSource: maxAgeFromTTLAnnotation, Age: 1800, AgeSource: "pod", Expires: true, ExpiresIn: 2700,
+				Extension: 3600, ExtendedBy: "keep-until"},
+		},
+		{
+			name:  "invalid ttl annotation",
+			api:   namespace("shop-ci-feature", map[string]string{"ttl": "soon"}),
+			rules: rules,
+			want: Decision{Namespace: "shop-ci-feature", Reason: reasonInvalidTTL, Rule: "ttlAnnotation=ttl", Class: ReviewNamespaceClass,
+				Warning: "ttl annotation ttl is invalid: time: invalid duration \"soon\""},
+		},
+		{
+			name: "missing age",
+			api: &KubernetesAPIMock{
+				namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", CreationTimestamp: created}},
+			},
+			rules: func() NamespaceRules {
+				rules := rules
+				rules.AgeSources = builtinAgeSources("pod", "deployment")
+				return rules
+			}(),
+			want: Decision{Namespace: "shop-ci-feature", Reason: reasonMissingAge, Rule: "class=review", Class: ReviewNamespaceClass,
+				MaxAge: 86400, MaxAgeSource: maxAgeFromFlag, Warning: "no resource of the age sources pod, deployment exists"},
+		},
+		{
+			name: "expired by class",
+			api:  namespace("shop-ci-feature", nil),
+			rules: func() NamespaceRules {
+				rules := rules
+				rules.Classes = []NamespaceClass{reviewClass}
+				return rules
+			}(),
+			want: Decision{Namespace: "shop-ci-feature", Delete: true, Reason: reasonExpired, Rule: "class=preview", Class: "preview",
+				MaxAge: 600, MaxAgeSource: maxAgeFromClass, Age: 1800, AgeSource: "pod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shouldDeleteNamespace(context.TODO(), tt.api, tt.rules, false)
+			if err != nil {
+				t.Fatalf("shouldDeleteNamespace() error = %v", err)
+			}
+			// ages are measured in seconds, allow the clock to tick during the test
+			if got.Age-tt.want.Age == 1 {
+				got.Age = tt.want.Age
+				got.ExpiresIn = tt.want.ExpiresIn
+			}
+			got.Steps = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shouldDeleteNamespace() = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestNamespaceRules_extension(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rules := NamespaceRules{KeepUntilAnnotation: "keep-until", LastUsedAtAnnotation: "last-used-at", MaxExtension: 7 * 24 * 3600}
+
+	tests := []struct {
+		name          string
+		rules         NamespaceRules
+		annotations   map[string]string
+		expiresIn     int64
+		want          int64
+		wantExtension string
+		wantErr       bool
+	}{
+		{
+			name:        "no annotations",
+			rules:       rules,
+			annotations: map[string]string{},
+			expiresIn:   -600,
+		},
+		{
+			name:          "keep until",
+			rules:         rules,
+			annotations:   map[string]string{"keep-until": "2026-10-18T12:00:00Z"},
+			expiresIn:     -600,
+			want:          86400 + 600,
+			wantExtension: "keep-until",
+		},
+		{
+			name:        "keep until before expiry",
+			rules:       rules,
+			annotations: map[string]string{"keep-until": "2026-10-17T12:30:00Z"},
+			expiresIn:   3600,
+		},
+		{
+			name:          "heartbeat",
+			rules:         rules,
+			annotations:   map[string]string{"last-used-at": "2026-10-17T11:30:00Z"},
+			expiresIn:     -600,
+			want:          1800 + 600,
+			wantExtension: "last-used-at",
+		},
+		{
+			name:          "later of both",
+			rules:         rules,
+			annotations:   map[string]string{"keep-until": "2026-10-17T12:10:00Z", "last-used-at": "2026-10-17T11:30:00Z"},
+			expiresIn:     0,
+			want:          1800,
+			wantExtension: "last-used-at",
+		},
+		{
+			name:          "capped",
+			rules:         rules,
+			annotations:   map[string]string{"keep-until": "2100-01-01T00:00:00Z"},
+			expiresIn:     0,
+			want:          7 * 24 * 3600,
+			wantExtension: "keep-until",
+		},
+		{
+			name: "disabled",
+			rules: func() NamespaceRules {
+				rules := rules
+				rules.MaxExtension = 0
+				return rules
+			}(),
+			annotations: map[string]string{"keep-until": "2100-01-01T00:00:00Z"},
+		},
+		{
+			name:          "invalid value",
+			rules:         rules,
+			annotations:   map[string]string{"keep-until": "tomorrow", "last-used-at": "2026-10-17T11:30:00Z"},
+			expiresIn:     0,
+			want:          1800,
+			wantExtension: "last-used-at",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, extension, err := tt.rules.extension(tt.annotations, 3600, tt.expiresIn, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("extension() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || (got > 0 && extension != tt.wantExtension) {
+				t.Errorf("extension() = %d, %s, want %d, %s", got, extension, tt.want, tt.wantExtension)
+			}
+		})
+	}
+}
+
+func TestExplainNamespace(t *testing.T) {
+	clientset := fake.NewClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              "shop-ci-feature-upload",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+			Annotations:       map[string]string{"ttl": "3h"},
+		}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "shop-ci-feature-upload",
+			Name:              "web",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		}},
+	)
+	rules := NamespaceRules{
+		AgeSources:        builtinAgeSources("namespace", "pod", "deployment"),
+		ProtectedBranches: []string{"main"},
+		OptOutAnnotations: []string{"disable-automatic-garbage-collection"},
+		TTLAnnotation:     "ttl",
+		MaxReviewAge:      int64(time.Hour.Seconds()),
+	}
+
+	got, err := ExplainNamespace(context.TODO(), clientset, rules, "shop-ci-feature-upload")
+	if err != nil {
+		t.Fatalf("ExplainNamespace() error = %v", err)
+	}
+
+	if got.Delete || got.Reason != reasonTooYoung {
+		t.Errorf("ExplainNamespace() verdict = %s (%s), want keep (%s)", got.Verdict(), got.Reason, reasonTooYoung)
+	}
+
+	steps := []string{}
+	for _, step := range got.Steps {
+		steps = append(steps, step.Check+": "+step.Result)
+	}
+	explanation := strings.Join(steps, "\n")
+
+	for _, want := range []string{
+		"terminating: no",
+		"class: review, maxAge: 3600s",
+		"protected branches: shop-ci-feature-upload is tagged by no protected branch",
+		"opt-out annotations: none of disable-automatic-garbage-collection is \"true\"",
+		"ttl annotation: ttl is \"3h\", 10800s",
+		"max age: 10800s from ttl-annotation",
+		"age of namespace: 720",
+		"age of pod: 360",
+		"age of deployment: no resources",
+	} {
+		if !strings.Contains(explanation, want) {
+			t.Errorf("ExplainNamespace() steps = \n%s\nwant them to contain %q", explanation, want)
+		}
+	}
+
+	_, err = ExplainNamespace(context.TODO(), clientset, rules, "missing")
+	if err == nil {
+		t.Errorf("ExplainNamespace() of a missing namespace expected error")
+	}
+}
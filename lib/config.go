@@ -0,0 +1,896 @@
+package gc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	ConfigAPIVersion = "k8s-gitlab-gc/v1alpha1"
+	ConfigKind       = "Config"
+
+	ModeOneshot    = "oneshot"
+	ModeController = "controller"
+)
+
+// log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Config is the schema of the configuration file, command line flags override its values
+type Config struct {
+	APIVersion      string                `yaml:"apiVersion"`
+	Kind            string                `yaml:"kind"`
+	Kubeconfig      string                `yaml:"kubeconfig"`
+	DryRun          bool                  `yaml:"dryRun"`
+	Mode            string                `yaml:"mode"`
+	LogFormat       string                `yaml:"logFormat"`
+	ResyncInterval  Duration              `yaml:"resyncInterval"`
+	Gitlab          GitlabConfig          `yaml:"gitlab"`
+	Traffic         TrafficConfig         `yaml:"traffic"`
+	GitlabExecutors GitlabExecutorsConfig `yaml:"gitlabExecutors"`
+	Namespaces      NamespacesConfig      `yaml:"namespaces"`
+	LeaderElection  LeaderElectionOptions `yaml:"leaderElection"`
+	Metrics         MetricsConfig         `yaml:"metrics"`
+	Events          EventsConfig          `yaml:"events"`
+	Archive         ArchiveConfig         `yaml:"archive"`
+}
+
+// ArchiveConfig exports the objects of namespaces to a local directory or an S3 compatible bucket before they are deleted
+type ArchiveConfig struct {
+	// Enabled requires permissions to list all namespaced resources and a Directory or an S3 bucket
+	Enabled        bool     `yaml:"enabled"`
+	Directory      string   `yaml:"directory"`
+	S3             S3Config `yaml:"s3"`
+	ExcludeSecrets bool     `yaml:"excludeSecrets"`
+	// Retention deletes archives older than this, 0 keeps them forever
+	Retention Duration `yaml:"retention"`
+	// Timeout limits the archive of each namespace, a namespace whose archive fails is kept
+	Timeout Duration `yaml:"timeout"`
+}
+
+// S3Config addresses a bucket path style, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are used if the keys are empty
+type S3Config struct {
+	Endpoint        string   `yaml:"endpoint"`
+	Bucket          string   `yaml:"bucket"`
+	Prefix          string   `yaml:"prefix"`
+	Region          string   `yaml:"region"`
+	AccessKeyID     string   `yaml:"accessKeyID"`
+	SecretAccessKey string   `yaml:"secretAccessKey"`
+	Timeout         Duration `yaml:"timeout"`
+}
+
+// EventsConfig configures the kubernetes events recorded on deleted and skipped namespaces and pods
+type EventsConfig struct {
+	// Enabled requires permissions to create, update and patch events in all namespaces
+	Enabled bool `yaml:"enabled"`
+	// DeletionNotice announces the deletion of a namespace this long before, 0 disables the announcement
+	DeletionNotice Duration `yaml:"deletionNotice"`
+}
+
+// MetricsConfig configures how the prometheus metrics are exposed
+type MetricsConfig struct {
+	// Address serves /metrics in controller mode, it is disabled if empty
+	Address string `yaml:"address"`
+	// PushgatewayURL receives the metrics at the end of a oneshot run, it is disabled if empty
+	PushgatewayURL string `yaml:"pushgatewayURL"`
+	PushgatewayJob string `yaml:"pushgatewayJob"`
+}
+
+// GitlabConfig enables looking up branches and merge requests of namespaces, the gitlab API is not used if URL is empty
+type GitlabConfig struct {
+	URL string `yaml:"url"`
+	// Token is a personal, group or project access token with read_api scope, StopEnvironments requires the api scope, the
+	// GITLAB_TOKEN environment variable is used if empty
+	Token   string   `yaml:"token"`
+	Timeout Duration `yaml:"timeout"`
+	// StopEnvironments stops the environment named by EnvironmentAnnotation after its namespace was deleted
+	StopEnvironments      bool   `yaml:"stopEnvironments"`
+	EnvironmentAnnotation string `yaml:"environmentAnnotation"`
+}
+
+// TrafficConfig enables the age source "traffic", the time since the last request to a namespace, the API is not used if PrometheusURL is empty
+type TrafficConfig struct {
+	// PrometheusURL is the base URL of a prometheus compatible HTTP API, e.g. "http://prometheus.monitoring:9090"
+	PrometheusURL string `yaml:"prometheusURL"`
+	// Query returns the request rate of a namespace, "$namespace" is replaced by its name
+	Query string `yaml:"query"`
+	// Window is the time range searched for requests, namespaces whose series have no requests in it are idle for the window,
+	// so it has to be longer than the max age of the namespaces traffic should let expire, a shorter window keeps quiet
+	// namespaces at an age below their max age forever, namespaces without any series have no traffic age
+	Window Duration `yaml:"window"`
+	// Step is the resolution of the samples, it must not be longer than the ranges of the query
+	Step    Duration `yaml:"step"`
+	Timeout Duration `yaml:"timeout"`
+}
+
+type GitlabExecutorsConfig struct {
+	// RunnerNamespace is the namespace of the only target if Targets are empty
+	RunnerNamespace string   `yaml:"runnerNamespace"`
+	MaxAge          Duration `yaml:"maxAge"`
+	// MaxCompletedAge, MaxStuckAge and MaxUnreachableAge are disabled if 0, the default, see ExecutorRules
+	MaxCompletedAge   Duration `yaml:"maxCompletedAge"`
+	MaxStuckAge       Duration `yaml:"maxStuckAge"`
+	MaxUnreachableAge Duration `yaml:"maxUnreachableAge"`
+	// ForceDeleteUnreachable skips the grace period of pods deleted by MaxUnreachableAge, see ExecutorRules
+	ForceDeleteUnreachable bool `yaml:"forceDeleteUnreachable"`
+	// MaxOrphanAge is the age of secrets, config maps, services and claims of gone executors to delete them at, 0 disables it
+	MaxOrphanAge Duration `yaml:"maxOrphanAge"`
+	// Targets replace the RunnerNamespace, their max ages default to the ones above
+	Targets []RunnerTargetConfig `yaml:"targets"`
+}
+
+// RunnerTargetConfig selects executor pods by namespace, all namespaces if empty, and label selector
+type RunnerTargetConfig struct {
+	Namespace         string    `yaml:"namespace"`
+	LabelSelector     string    `yaml:"labelSelector"`
+	MaxAge            *Duration `yaml:"maxAge"`
+	MaxCompletedAge   *Duration `yaml:"maxCompletedAge"`
+	MaxStuckAge       *Duration `yaml:"maxStuckAge"`
+	MaxUnreachableAge *Duration `yaml:"maxUnreachableAge"`
+	MaxOrphanAge      *Duration `yaml:"maxOrphanAge"`
+}
+
+type NamespacesConfig struct {
+	ProtectedBranches []string `yaml:"protectedBranches"`
+	OptOutAnnotations []string `yaml:"optOutAnnotations"`
+	TTLAnnotation     string   `yaml:"ttlAnnotation"`
+	// KeepUntilAnnotation holds an RFC3339 time and LastUsedAtAnnotation an RFC3339 heartbeat, both extend the lifetime by at most MaxExtension
+	KeepUntilAnnotation  string   `yaml:"keepUntilAnnotation"`
+	LastUsedAtAnnotation string   `yaml:"lastUsedAtAnnotation"`
+	MaxExtension         Duration `yaml:"maxExtension"`
+	OnlyUseAgesOf        []string `yaml:"onlyUseAgesOf"`
+	// MaxBuildAge and MaxReviewAge configure the default classes, they are ignored if Classes are set
+	MaxBuildAge  Duration               `yaml:"maxBuildAge"`
+	MaxReviewAge Duration               `yaml:"maxReviewAge"`
+	Classes      []NamespaceClassConfig `yaml:"classes"`
+	// NameParsers are regular expressions with the named groups project, branch, pipeline and sha
+	NameParsers []string `yaml:"nameParsers"`
+	// UsePolicies evaluates namespaces by NamespaceGCPolicy resources, the custom resource definition has to be installed
+	UsePolicies bool `yaml:"usePolicies"`
+	// PolicyAllowlist are regular expressions of namespaces policies may select although no class matches them
+	PolicyAllowlist []string `yaml:"policyAllowlist"`
+	// Annotate writes expires-at, gc-class and last-evaluated onto kept ci namespaces, it requires permissions to patch namespaces
+	Annotate bool `yaml:"annotate"`
+	// HibernateAfter scales the workloads of ci namespaces idle this long to zero, it requires permissions to update deployments, stateful sets and cron jobs
+	HibernateAfter Duration `yaml:"hibernateAfter"`
+	// WarningPeriod labels deletable namespaces and deletes them once they stayed deletable this long, it requires permissions to patch namespaces
+	WarningPeriod Duration `yaml:"warningPeriod"`
+}
+
+// NamespaceClassConfig is matched against namespaces in order, the first class matching by name pattern and label selector applies
+type NamespaceClassConfig struct {
+	Name          string   `yaml:"name"`
+	NamePattern   string   `yaml:"namePattern"`
+	LabelSelector string   `yaml:"labelSelector"`
+	MaxAge        Duration `yaml:"maxAge"`
+}
+
+type LeaderElectionOptions struct {
+	Enabled        bool     `yaml:"enabled"`
+	LeaseName      string   `yaml:"leaseName"`
+	LeaseNamespace string   `yaml:"leaseNamespace"`
+	Identity       string   `yaml:"identity"`
+	LeaseDuration  Duration `yaml:"leaseDuration"`
+	RenewDeadline  Duration `yaml:"renewDeadline"`
+	RetryPeriod    Duration `yaml:"retryPeriod"`
+}
+
+// Duration is a time.Duration written in the configuration file as a string like "2h45m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a duration like \"2h45m\"", node.Line)
+	}
+
+	duration, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration \"%s\", valid time units are \"ns\", \"us\", \"ms\", \"s\", \"m\", \"h\"", node.Line, node.Value)
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+// Seconds returns the duration in whole seconds as used by the ages of resources
+func (d Duration) Seconds() int64 {
+	return int64(d.Duration / time.Second)
+}
+
+// ConfigError is a validation error of a single field, Line is only known for values read from a file and Flag for values set by a flag
+type ConfigError struct {
+	Field   string
+	File    string
+	Line    int
+	Flag    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	switch {
+	case e.Flag != "":
+		return fmt.Sprintf("flag -%s: %s: %s", e.Flag, e.Field, e.Message)
+	case e.Line > 0 && e.File != "":
+		return fmt.Sprintf("%s line %d: %s: %s", e.File, e.Line, e.Field, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ConfigSource records where the values of a config came from, validation errors point at the line of the file or at the flag
+type ConfigSource struct {
+	// File is the path of the config file, empty without one
+	File string
+	root *yaml.Node
+	// flags maps overridden fields like "gitlab.url" to the flags setting them
+	flags map[string]string
+}
+
+// Override records that the flag set the field, errors of the field and the fields below it refer to the flag instead of the file
+func (s *ConfigSource) Override(field, flag string) {
+	if s.flags == nil {
+		s.flags = map[string]string{}
+	}
+	s.flags[field] = flag
+}
+
+// flagOf returns the flag overriding the field or one of its parents, e.g. "namespaces.onlyUseAgesOf" for "namespaces.onlyUseAgesOf[2]"
+func (s ConfigSource) flagOf(field string) (string, bool) {
+	for overridden, flag := range s.flags {
+		if field == overridden || strings.HasPrefix(field, overridden+".") || strings.HasPrefix(field, overridden+"[") {
+			return flag, true
+		}
+	}
+	return "", false
+}
+
+// DefaultConfig returns the configuration used for values neither set in the file nor by flags
+func DefaultConfig() Config {
+	return Config{
+		APIVersion:     ConfigAPIVersion,
+		Kind:           ConfigKind,
+		Mode:           ModeOneshot,
+		LogFormat:      LogFormatText,
+		ResyncInterval: Duration{10 * time.Minute},
+		Gitlab: GitlabConfig{
+			Timeout:               Duration{10 * time.Second},
+			EnvironmentAnnotation: GitlabEnvironmentAnnotation,
+		},
+		Traffic: TrafficConfig{
+			Query:   DefaultTrafficQuery,
+			Window:  Duration{72 * time.Hour},
+			Step:    Duration{5 * time.Minute},
+			Timeout: Duration{10 * time.Second},
+		},
+		GitlabExecutors: GitlabExecutorsConfig{
+			RunnerNamespace: "gitlab-runner",
+			MaxAge:          Duration{70 * time.Minute},
+		},
+		Namespaces: NamespacesConfig{
+			ProtectedBranches:    []string{"develop", "master", "main", "preview", "review", "stage", "staging"},
+			OptOutAnnotations:    []string{"disable-automatic-garbage-collection", "k8s-gitlab-gc.utopia-planitia.non-existing-tld/disable-automatic-garbage-collection"},
+			TTLAnnotation:        "k8s-gitlab-gc.utopia-planitia.non-existing-tld/ns-ttl-duration",
+			KeepUntilAnnotation:  "k8s-gitlab-gc.utopia-planitia.non-existing-tld/keep-until",
+			LastUsedAtAnnotation: "k8s-gitlab-gc.utopia-planitia.non-existing-tld/last-used-at",
+			OnlyUseAgesOf:        []string{"namespace", "deployment", "statefulset", "daemonset", "cronjob"},
+			MaxBuildAge:          Duration{2 * time.Hour},
+			MaxReviewAge:         Duration{48 * time.Hour},
+		},
+		Events: EventsConfig{
+			DeletionNotice: Duration{time.Hour},
+		},
+		Archive: ArchiveConfig{
+			S3: S3Config{
+				Region:  "us-east-1",
+				Timeout: Duration{time.Minute},
+			},
+			ExcludeSecrets: true,
+			Retention:      Duration{30 * 24 * time.Hour},
+			Timeout:        Duration{5 * time.Minute},
+		},
+		Metrics: MetricsConfig{
+			Address:        ":8080",
+			PushgatewayJob: "k8s-gitlab-gc",
+		},
+		LeaderElection: LeaderElectionOptions{
+			LeaseName:     "k8s-gitlab-gc",
+			LeaseDuration: Duration{15 * time.Second},
+			RenewDeadline: Duration{10 * time.Second},
+			RetryPeriod:   Duration{2 * time.Second},
+		},
+	}
+}
+
+// LoadConfig reads a YAML or JSON configuration file on top of the DefaultConfig, it is not validated as flags may still override its values
+func LoadConfig(path string) (Config, ConfigSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, ConfigSource{}, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config, root, err := decodeConfig(data)
+	if err != nil {
+		return Config{}, ConfigSource{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return config, ConfigSource{File: path, root: root}, nil
+}
+
+// ParseConfig decodes YAML or JSON strictly, unknown fields and invalid values are reported with their line
+func ParseConfig(data []byte) (Config, error) {
+	config, root, err := decodeConfig(data)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = config.ValidateSource(ConfigSource{root: root})
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// decodeConfig decodes strictly without validating the values, apiVersion and kind are not defaulted as files must state them
+func decodeConfig(data []byte) (Config, *yaml.Node, error) {
+	config := DefaultConfig()
+	config.APIVersion = ""
+	config.Kind = ""
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(&config)
+	if errors.Is(err, io.EOF) {
+		return Config{}, nil, errors.New("config is empty")
+	}
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(data, &root)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	err = requireFields(&root, map[string]string{"apiVersion": ConfigAPIVersion, "kind": ConfigKind})
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	return config, &root, nil
+}
+
+// requireFields reports every missing top level field at the line the document starts
+func requireFields(root *yaml.Node, fields map[string]string) error {
+	document := root
+	if document.Kind == yaml.DocumentNode && len(document.Content) > 0 {
+		document = document.Content[0]
+	}
+
+	errs := []error{}
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		if mappingValue(document, field) == nil {
+			errs = append(errs, &ConfigError{Field: field, Line: document.Line, Message: fmt.Sprintf("is required, expected \"%s\"", fields[field])})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateSource validates the config and points every error at the flag or the line of the file its value came from
+func (c Config) ValidateSource(source ConfigSource) error {
+	err := c.Validate()
+	if err != nil {
+		return source.locate(err)
+	}
+	return nil
+}
+
+// Validate checks the values of all fields and returns every violation
+func (c Config) Validate() error {
+	errs := []error{}
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, &ConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.APIVersion == "" {
+		invalid("apiVersion", "is required, expected \"%s\"", ConfigAPIVersion)
+	} else if c.APIVersion != ConfigAPIVersion {
+		invalid("apiVersion", "unsupported version \"%s\", expected \"%s\"", c.APIVersion, ConfigAPIVersion)
+	}
+
+	if c.Kind == "" {
+		invalid("kind", "is required, expected \"%s\"", ConfigKind)
+	} else if c.Kind != ConfigKind {
+		invalid("kind", "unsupported kind \"%s\", expected \"%s\"", c.Kind, ConfigKind)
+	}
+
+	if c.Mode != ModeOneshot && c.Mode != ModeController {
+		invalid("mode", "unknown mode \"%s\", valid modes are: \"%s\", \"%s\"", c.Mode, ModeOneshot, ModeController)
+	}
+
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		invalid("logFormat", "unknown log format \"%s\", valid formats are: \"%s\", \"%s\"", c.LogFormat, LogFormatText, LogFormatJSON)
+	}
+
+	if c.ResyncInterval.Duration < 0 {
+		invalid("resyncInterval", "must not be negative")
+	}
+
+	if c.Gitlab.URL != "" {
+		u, err := url.Parse(c.Gitlab.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("gitlab.url", "\"%s\" is not an absolute http(s) URL", c.Gitlab.URL)
+		}
+	}
+
+	if c.Gitlab.Timeout.Duration <= 0 {
+		invalid("gitlab.timeout", "must be positive")
+	}
+
+	if c.Gitlab.StopEnvironments && c.Gitlab.URL == "" {
+		invalid("gitlab.stopEnvironments", "requires gitlab.url")
+	}
+
+	if c.Gitlab.StopEnvironments && c.Gitlab.EnvironmentAnnotation == "" {
+		invalid("gitlab.environmentAnnotation", "must not be empty")
+	}
+
+	if c.Traffic.PrometheusURL != "" {
+		u, err := url.Parse(c.Traffic.PrometheusURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("traffic.prometheusURL", "\"%s\" is not an absolute http(s) URL", c.Traffic.PrometheusURL)
+		}
+	}
+
+	if !strings.Contains(c.Traffic.Query, "$namespace") {
+		invalid("traffic.query", "must contain \"$namespace\"")
+	}
+
+	switch {
+	case c.Traffic.Window.Duration <= 0:
+		invalid("traffic.window", "must be positive")
+	case c.Traffic.Step.Duration <= 0:
+		invalid("traffic.step", "must be positive")
+	case c.Traffic.Window.Duration/c.Traffic.Step.Duration > maxTrafficSamples:
+		invalid("traffic.step", "the window must not have more than %d steps", maxTrafficSamples)
+	}
+
+	shortest, found := shortestRange(c.Traffic.Query)
+	if found && c.Traffic.Step.Duration > shortest {
+		invalid("traffic.step", "must not be longer than the range %s of traffic.query, requests between the steps would be missed", shortest)
+	}
+
+	if c.Traffic.Timeout.Duration <= 0 {
+		invalid("traffic.timeout", "must be positive")
+	}
+
+	if c.GitlabExecutors.RunnerNamespace == "" && len(c.GitlabExecutors.Targets) == 0 {
+		invalid("gitlabExecutors.runnerNamespace", "must not be empty")
+	}
+
+	if c.GitlabExecutors.MaxAge.Duration <= 0 {
+		invalid("gitlabExecutors.maxAge", "must be positive")
+	}
+
+	if c.GitlabExecutors.MaxCompletedAge.Duration < 0 {
+		invalid("gitlabExecutors.maxCompletedAge", "must not be negative")
+	}
+
+	if c.GitlabExecutors.MaxStuckAge.Duration < 0 {
+		invalid("gitlabExecutors.maxStuckAge", "must not be negative")
+	}
+
+	if c.GitlabExecutors.MaxUnreachableAge.Duration < 0 {
+		invalid("gitlabExecutors.maxUnreachableAge", "must not be negative")
+	}
+
+	if c.GitlabExecutors.MaxOrphanAge.Duration < 0 {
+		invalid("gitlabExecutors.maxOrphanAge", "must not be negative")
+	}
+
+	for i, target := range c.GitlabExecutors.Targets {
+		field := fmt.Sprintf("gitlabExecutors.targets[%d]", i)
+
+		if _, err := labels.Parse(target.LabelSelector); err != nil {
+			invalid(field+".labelSelector", "%v", err)
+		}
+
+		if target.MaxAge != nil && target.MaxAge.Duration <= 0 {
+			invalid(field+".maxAge", "must be positive")
+		}
+
+		for _, maxAge := range []struct {
+			name  string
+			value *Duration
+		}{
+			{"maxCompletedAge", target.MaxCompletedAge},
+			{"maxStuckAge", target.MaxStuckAge},
+			{"maxUnreachableAge", target.MaxUnreachableAge},
+			{"maxOrphanAge", target.MaxOrphanAge},
+		} {
+			if maxAge.value != nil && maxAge.value.Duration < 0 {
+				invalid(field+"."+maxAge.name, "must not be negative")
+			}
+		}
+
+		if target.Namespace == "" && target.MaxOrphanAge != nil && target.MaxOrphanAge.Duration > 0 {
+			invalid(field+".maxOrphanAge", "side objects are only collected in targets of a single namespace")
+		}
+	}
+
+	if len(c.Namespaces.OnlyUseAgesOf) == 0 {
+		invalid("namespaces.onlyUseAgesOf", "at least one resource is required")
+	}
+
+	for i, name := range c.Namespaces.OnlyUseAgesOf {
+		if err := validateAgeSourceName(name); err != nil {
+			invalid(fmt.Sprintf("namespaces.onlyUseAgesOf[%d]", i), "%v", err)
+		}
+		if name == TrafficAgeSource && c.Traffic.PrometheusURL == "" {
+			invalid(fmt.Sprintf("namespaces.onlyUseAgesOf[%d]", i), "\"%s\" requires traffic.prometheusURL", name)
+		}
+	}
+
+	if c.Namespaces.MaxBuildAge.Duration < 0 {
+		invalid("namespaces.maxBuildAge", "must not be negative")
+	}
+
+	if c.Namespaces.MaxReviewAge.Duration < 0 {
+		invalid("namespaces.maxReviewAge", "must not be negative")
+	}
+
+	if c.Namespaces.MaxExtension.Duration < 0 {
+		invalid("namespaces.maxExtension", "must not be negative")
+	}
+
+	if c.Namespaces.HibernateAfter.Duration < 0 {
+		invalid("namespaces.hibernateAfter", "must not be negative")
+	}
+
+	if c.Namespaces.WarningPeriod.Duration < 0 {
+		invalid("namespaces.warningPeriod", "must not be negative")
+	}
+
+	classNames := map[string]bool{}
+	for i, class := range c.Namespaces.Classes {
+		field := fmt.Sprintf("namespaces.classes[%d]", i)
+
+		if class.Name == "" {
+			invalid(field+".name", "must not be empty")
+		}
+
+		if classNames[class.Name] {
+			invalid(field+".name", "duplicate class \"%s\"", class.Name)
+		}
+		classNames[class.Name] = true
+
+		if class.NamePattern == "" && class.LabelSelector == "" {
+			invalid(field, "a namePattern or a labelSelector is required")
+		}
+
+		if _, err := regexp.Compile(class.NamePattern); err != nil {
+			invalid(field+".namePattern", "%v", err)
+		}
+
+		if _, err := labels.Parse(class.LabelSelector); err != nil {
+			invalid(field+".labelSelector", "%v", err)
+		}
+
+		if class.MaxAge.Duration <= 0 {
+			invalid(field+".maxAge", "must be positive")
+		}
+	}
+
+	for i, pattern := range c.Namespaces.NameParsers {
+		if _, err := NewNameParser(pattern); err != nil {
+			invalid(fmt.Sprintf("namespaces.nameParsers[%d]", i), "%v", err)
+		}
+	}
+
+	for i, pattern := range c.Namespaces.PolicyAllowlist {
+		if _, err := regexp.Compile(pattern); err != nil {
+			invalid(fmt.Sprintf("namespaces.policyAllowlist[%d]", i), "%v", err)
+		}
+	}
+
+	if c.Events.DeletionNotice.Duration < 0 {
+		invalid("events.deletionNotice", "must not be negative")
+	}
+
+	if c.Archive.Enabled {
+		switch {
+		case c.Archive.Directory == "" && c.Archive.S3.Bucket == "":
+			invalid("archive", "a directory or an s3 bucket is required")
+		case c.Archive.Directory != "" && c.Archive.S3.Bucket != "":
+			invalid("archive.directory", "only one of directory and s3 bucket may be set")
+		}
+
+		if c.Archive.S3.Bucket != "" {
+			u, err := url.Parse(c.Archive.S3.Endpoint)
+			switch {
+			case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+				invalid("archive.s3.endpoint", "\"%s\" is not an absolute http(s) URL", c.Archive.S3.Endpoint)
+			case strings.Trim(u.Path, "/") != "":
+				invalid("archive.s3.endpoint", "\"%s\" must not have a path, buckets are addressed path style", c.Archive.S3.Endpoint)
+			}
+
+			if c.Archive.S3.Region == "" {
+				invalid("archive.s3.region", "must not be empty")
+			}
+
+			if c.Archive.S3.Timeout.Duration <= 0 {
+				invalid("archive.s3.timeout", "must be positive")
+			}
+		}
+	}
+
+	if c.Archive.Retention.Duration < 0 {
+		invalid("archive.retention", "must not be negative")
+	}
+
+	if c.Archive.Timeout.Duration <= 0 {
+		invalid("archive.timeout", "must be positive")
+	}
+
+	if c.Metrics.PushgatewayURL != "" {
+		u, err := url.Parse(c.Metrics.PushgatewayURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("metrics.pushgatewayURL", "\"%s\" is not an absolute http(s) URL", c.Metrics.PushgatewayURL)
+		}
+
+		if c.Metrics.PushgatewayJob == "" {
+			invalid("metrics.pushgatewayJob", "must not be empty")
+		}
+	}
+
+	if c.LeaderElection.Enabled {
+		if c.Mode != ModeController {
+			invalid("leaderElection.enabled", "leader election is only supported in \"%s\" mode", ModeController)
+		}
+
+		if c.LeaderElection.LeaseName == "" {
+			invalid("leaderElection.leaseName", "must not be empty")
+		}
+
+		if c.LeaderElection.LeaseDuration.Duration <= c.LeaderElection.RenewDeadline.Duration {
+			invalid("leaderElection.leaseDuration", "must be greater than renewDeadline")
+		}
+
+		if c.LeaderElection.RenewDeadline.Duration <= c.LeaderElection.RetryPeriod.Duration {
+			invalid("leaderElection.renewDeadline", "must be greater than retryPeriod")
+		}
+
+		if c.LeaderElection.RetryPeriod.Duration <= 0 {
+			invalid("leaderElection.retryPeriod", "must be positive")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ExecutorRules converts the max ages, the gitlab client has to be set by the caller
+func (c GitlabExecutorsConfig) ExecutorRules() ExecutorRules {
+	return ExecutorRules{
+		MaxAge:            c.MaxAge.Seconds(),
+		MaxCompletedAge:   c.MaxCompletedAge.Seconds(),
+		MaxStuckAge:       c.MaxStuckAge.Seconds(),
+		MaxUnreachableAge: c.MaxUnreachableAge.Seconds(),
+		MaxOrphanAge:      c.MaxOrphanAge.Seconds(),
+
+		ForceDeleteUnreachable: c.ForceDeleteUnreachable,
+	}
+}
+
+// RunnerTargets compiles the targets, the gitlab clients of their rules have to be set by the caller
+func (c GitlabExecutorsConfig) RunnerTargets() ([]RunnerTarget, error) {
+	if len(c.Targets) == 0 {
+		target, err := NewRunnerTarget(c.RunnerNamespace, DefaultExecutorSelector, c.ExecutorRules())
+		if err != nil {
+			return nil, err
+		}
+		return []RunnerTarget{target}, nil
+	}
+
+	override := func(value *Duration, fallback int64) int64 {
+		if value == nil {
+			return fallback
+		}
+		return value.Seconds()
+	}
+
+	defaults := c.ExecutorRules()
+	targets := []RunnerTarget{}
+	for i, targetConfig := range c.Targets {
+		rules := ExecutorRules{
+			MaxAge:            override(targetConfig.MaxAge, defaults.MaxAge),
+			MaxCompletedAge:   override(targetConfig.MaxCompletedAge, defaults.MaxCompletedAge),
+			MaxStuckAge:       override(targetConfig.MaxStuckAge, defaults.MaxStuckAge),
+			MaxUnreachableAge: override(targetConfig.MaxUnreachableAge, defaults.MaxUnreachableAge),
+			MaxOrphanAge:      override(targetConfig.MaxOrphanAge, defaults.MaxOrphanAge),
+
+			ForceDeleteUnreachable: defaults.ForceDeleteUnreachable,
+		}
+
+		target, err := NewRunnerTarget(targetConfig.Namespace, targetConfig.LabelSelector, rules)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %v", i, err)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// NamespaceRules resolves the configured age sources into the rules used to evaluate namespaces,
+// clients are required for age sources which aren't built in
+func (c NamespacesConfig) NamespaceRules(clients AgeSourceClients) (NamespaceRules, error) {
+	ageSources, err := SelectAgeSources(c.OnlyUseAgesOf, clients)
+	if err != nil {
+		return NamespaceRules{}, err
+	}
+
+	var classes []NamespaceClass
+	for _, classConfig := range c.Classes {
+		class, err := NewNamespaceClass(classConfig.Name, classConfig.NamePattern, classConfig.LabelSelector, classConfig.MaxAge.Seconds())
+		if err != nil {
+			return NamespaceRules{}, fmt.Errorf("class %s: %v", classConfig.Name, err)
+		}
+		classes = append(classes, class)
+	}
+
+	var nameParsers []*regexp.Regexp
+	for _, pattern := range c.NameParsers {
+		parser, err := NewNameParser(pattern)
+		if err != nil {
+			return NamespaceRules{}, fmt.Errorf("name parser %s: %v", pattern, err)
+		}
+		nameParsers = append(nameParsers, parser)
+	}
+
+	var policyAllowlist []*regexp.Regexp
+	for _, pattern := range c.PolicyAllowlist {
+		allowed, err := regexp.Compile(pattern)
+		if err != nil {
+			return NamespaceRules{}, fmt.Errorf("policy allowlist %s: %v", pattern, err)
+		}
+		policyAllowlist = append(policyAllowlist, allowed)
+	}
+
+	return NamespaceRules{
+		AgeSources:           ageSources,
+		PolicyAllowlist:      policyAllowlist,
+		AgeSourceClients:     clients,
+		ProtectedBranches:    c.ProtectedBranches,
+		OptOutAnnotations:    c.OptOutAnnotations,
+		TTLAnnotation:        c.TTLAnnotation,
+		KeepUntilAnnotation:  c.KeepUntilAnnotation,
+		LastUsedAtAnnotation: c.LastUsedAtAnnotation,
+		MaxExtension:         c.MaxExtension.Seconds(),
+		Classes:              classes,
+		NameParsers:          nameParsers,
+		MaxTestingAge:        c.MaxBuildAge.Seconds(),
+		MaxReviewAge:         c.MaxReviewAge.Seconds(),
+		Annotate:             c.Annotate,
+		HibernateAfter:       c.HibernateAfter.Seconds(),
+		WarningPeriod:        c.WarningPeriod.Seconds(),
+	}, nil
+}
+
+// LeaderElectionConfig converts the options, identity and namespace have to be defaulted by the caller
+func (o LeaderElectionOptions) LeaderElectionConfig() LeaderElectionConfig {
+	return LeaderElectionConfig{
+		LeaseName:      o.LeaseName,
+		LeaseNamespace: o.LeaseNamespace,
+		Identity:       o.Identity,
+		LeaseDuration:  o.LeaseDuration.Duration,
+		RenewDeadline:  o.RenewDeadline.Duration,
+		RetryPeriod:    o.RetryPeriod.Duration,
+	}
+}
+
+// locate looks up the flag or the line in the parsed document of every ConfigError, flags are listed first
+func (s ConfigSource) locate(err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return err
+	}
+
+	errs := joined.Unwrap()
+	for _, err := range errs {
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			continue
+		}
+		if flag, ok := s.flagOf(configErr.Field); ok {
+			configErr.Flag = flag
+			continue
+		}
+		if s.root != nil {
+			configErr.File = s.File
+			configErr.Line = lineOf(s.root, configErr.Field)
+		}
+	}
+
+	slices.SortStableFunc(errs, func(a, b error) int {
+		return lineOfError(a) - lineOfError(b)
+	})
+
+	return errors.Join(errs...)
+}
+
+func lineOfError(err error) int {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return configErr.Line
+	}
+	return 0
+}
+
+// lineOf resolves a field path like "namespaces.onlyUseAgesOf[2]" to its line, 0 if the field is not in the document
+func lineOf(root *yaml.Node, field string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, part := range strings.Split(field, ".") {
+		key, index, hasIndex := strings.Cut(strings.TrimSuffix(part, "]"), "[")
+
+		node = mappingValue(node, key)
+		if node == nil {
+			return 0
+		}
+
+		if !hasIndex {
+			continue
+		}
+
+		i, err := strconv.Atoi(index)
+		if err != nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+			return 0
+		}
+		node = node.Content[i]
+	}
+
+	return node.Line
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,447 @@
+package gc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		check   func(t *testing.T, config Config)
+		wantErr []string
+	}{
+		{
+			name: "yaml overrides defaults",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+mode: controller
+gitlabExecutors:
+  maxAge: 1h30m
+namespaces:
+  protectedBranches:
+    - main
+  onlyUseAgesOf: [namespace, pod]
+  maxBuildAge: 2h
+`,
+			check: func(t *testing.T, config Config) {
+				if config.Mode != ModeController {
+					t.Errorf("mode = %v, want %v", config.Mode, ModeController)
+				}
+				if config.GitlabExecutors.MaxAge.Duration != 90*time.Minute {
+					t.Errorf("gitlabExecutors.maxAge = %v, want %v", config.GitlabExecutors.MaxAge, 90*time.Minute)
+				}
+				if config.GitlabExecutors.RunnerNamespace != "gitlab-runner" {
+					t.Errorf("gitlabExecutors.runnerNamespace = %v, want default", config.GitlabExecutors.RunnerNamespace)
+				}
+				if strings.Join(config.Namespaces.ProtectedBranches, ",") != "main" {
+					t.Errorf("namespaces.protectedBranches = %v, want [main]", config.Namespaces.ProtectedBranches)
+				}
+				if config.Namespaces.MaxBuildAge.Seconds() != 7200 {
+					t.Errorf("namespaces.maxBuildAge = %v, want 7200s", config.Namespaces.MaxBuildAge.Seconds())
+				}
+			},
+		},
+		{
+			name: "json",
+			data: `{"apiVersion": "k8s-gitlab-gc/v1alpha1", "kind": "Config", "dryRun": true, "namespaces": {"maxReviewAge": "72h"}}`,
+			check: func(t *testing.T, config Config) {
+				if !config.DryRun {
+					t.Errorf("dryRun = %v, want %v", config.DryRun, true)
+				}
+				if config.Namespaces.MaxReviewAge.Duration != 72*time.Hour {
+					t.Errorf("namespaces.maxReviewAge = %v, want %v", config.Namespaces.MaxReviewAge, 72*time.Hour)
+				}
+			},
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: []string{"config is empty"},
+		},
+		{
+			name: "missing apiVersion and kind",
+			data: `mode: controller
+dryRun: true
+`,
+			wantErr: []string{
+				"line 1: apiVersion: is required, expected \"k8s-gitlab-gc/v1alpha1\"",
+				"line 1: kind: is required, expected \"Config\"",
+			},
+		},
+		{
+			name:    "missing kind in json",
+			data:    `{"apiVersion": "k8s-gitlab-gc/v1alpha1", "dryRun": true}`,
+			wantErr: []string{"line 1: kind: is required"},
+		},
+		{
+			name: "empty apiVersion",
+			data: `kind: Config
+apiVersion: ""
+`,
+			wantErr: []string{"line 2: apiVersion: is required"},
+		},
+		{
+			name: "unknown field",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  maxAge: 2h
+`,
+			wantErr: []string{"line 4: field maxAge not found"},
+		},
+		{
+			name: "invalid duration",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+gitlabExecutors:
+  maxAge: 70
+`,
+			wantErr: []string{"line 4: invalid duration \"70\""},
+		},
+		{
+			name: "invalid values",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha2
+kind: Config
+mode: cron
+namespaces:
+  onlyUseAgesOf:
+    - namespace
+    - ReplicaSet
+  maxExtension: -24h
+logFormat: logfmt
+`,
+			wantErr: []string{
+				"line 1: apiVersion: unsupported version",
+				"line 3: mode: unknown mode \"cron\"",
+				"line 7: namespaces.onlyUseAgesOf[1]: \"ReplicaSet\" is not a valid key",
+				"line 8: namespaces.maxExtension: must not be negative",
+				"line 9: logFormat: unknown log format \"logfmt\"",
+			},
+		},
+		{
+			name: "classes",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  classes:
+    - name: pipeline
+      namePattern: "^gl-.*-[0-9]+$"
+      maxAge: 2h
+    - name: preview
+      labelSelector: "gc-class=preview"
+      maxAge: 72h
+`,
+			check: func(t *testing.T, config Config) {
+				rules, err := config.Namespaces.NamespaceRules(AgeSourceClients{})
+				if err != nil {
+					t.Fatalf("NamespaceRules() error = %v", err)
+				}
+				if len(rules.Classes) != 2 || rules.Classes[0].Name != "pipeline" || rules.Classes[1].MaxAge != 72*60*60 {
+					t.Errorf("classes = %v, want pipeline and preview", rules.Classes)
+				}
+			},
+		},
+		{
+			name: "invalid classes",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  classes:
+    - name: pipeline
+      namePattern: "^gl-("
+      maxAge: 2h
+    - name: pipeline
+      maxAge: 2h
+`,
+			wantErr: []string{
+				"line 6: namespaces.classes[0].namePattern: error parsing regexp",
+				"line 8: namespaces.classes[1].name: duplicate class \"pipeline\"",
+				"line 8: namespaces.classes[1]: a namePattern or a labelSelector is required",
+			},
+		},
+		{
+			name: "runner targets",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+gitlabExecutors:
+  maxAge: 1h
+  maxStuckAge: 10m
+  targets:
+    - namespace: runner-a
+    - namespace: ""
+      labelSelector: "runner in (large,xlarge)"
+      maxAge: 4h
+      maxStuckAge: 0s
+`,
+			check: func(t *testing.T, config Config) {
+				targets, err := config.GitlabExecutors.RunnerTargets()
+				if err != nil {
+					t.Fatalf("RunnerTargets() error = %v", err)
+				}
+				if len(targets) != 2 {
+					t.Fatalf("len(targets) = %d, want 2", len(targets))
+				}
+				if targets[0].Namespace != "runner-a" || targets[0].Selector.String() != DefaultExecutorSelector || targets[0].Rules.MaxAge != 60*60 || targets[0].Rules.MaxStuckAge != 10*60 {
+					t.Errorf("targets[0] = %+v, want defaults of runner-a", targets[0])
+				}
+				if targets[1].Namespace != "" || targets[1].Rules.MaxAge != 4*60*60 || targets[1].Rules.MaxStuckAge != 0 {
+					t.Errorf("targets[1] = %+v, want overridden max ages", targets[1])
+				}
+			},
+		},
+		{
+			name: "invalid runner targets",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+gitlabExecutors:
+  targets:
+    - labelSelector: "runner in ("
+      maxAge: 0s
+      maxOrphanAge: 10m
+`,
+			wantErr: []string{
+				"line 5: gitlabExecutors.targets[0].labelSelector:",
+				"line 6: gitlabExecutors.targets[0].maxAge: must be positive",
+				"line 7: gitlabExecutors.targets[0].maxOrphanAge: side objects are only collected in targets of a single namespace",
+			},
+		},
+		{
+			name: "invalid gitlab",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+gitlab:
+  url: gitlab.example.com
+  timeout: 0s
+`,
+			wantErr: []string{
+				"line 4: gitlab.url: \"gitlab.example.com\" is not an absolute http(s) URL",
+				"line 5: gitlab.timeout: must be positive",
+			},
+		},
+		{
+			name: "invalid metrics",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+metrics:
+  pushgatewayURL: pushgateway:9091
+  pushgatewayJob: ""
+`,
+			wantErr: []string{
+				"line 4: metrics.pushgatewayURL: \"pushgateway:9091\" is not an absolute http(s) URL",
+				"line 5: metrics.pushgatewayJob: must not be empty",
+			},
+		},
+		{
+			name: "negative events deletion notice",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+events:
+  enabled: true
+  deletionNotice: -1h
+`,
+			wantErr: []string{"line 5: events.deletionNotice: must not be negative"},
+		},
+		{
+			name: "invalid name parsers",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  nameParsers:
+    - "^(?P<project>.+)-ci-(?P<branch>.+)$"
+    - "^(?P<team>.+)-ci$"
+`,
+			wantErr: []string{"line 6: namespaces.nameParsers[1]: unknown capture group \"team\""},
+		},
+		{
+			name: "resource and activity age sources",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  onlyUseAgesOf: [namespace, deployment:activity, jobs.batch:activity, ingresses.networking.k8s.io]
+`,
+			check: func(t *testing.T, config Config) {
+				got := strings.Join(config.Namespaces.OnlyUseAgesOf, ",")
+				if got != "namespace,deployment:activity,jobs.batch:activity,ingresses.networking.k8s.io" {
+					t.Errorf("namespaces.onlyUseAgesOf = %v", got)
+				}
+			},
+		},
+		{
+			name: "archive without a target",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+archive:
+  enabled: true
+  retention: -1h
+`,
+			wantErr: []string{
+				"line 4: archive: a directory or an s3 bucket is required",
+				"line 5: archive.retention: must not be negative",
+			},
+		},
+		{
+			name: "archive with two targets",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+archive:
+  enabled: true
+  directory: /var/lib/k8s-gitlab-gc
+  s3:
+    endpoint: s3.eu-central-1.amazonaws.com
+    bucket: archives
+    region: ""
+`,
+			wantErr: []string{
+				"line 5: archive.directory: only one of directory and s3 bucket may be set",
+				"line 7: archive.s3.endpoint: \"s3.eu-central-1.amazonaws.com\" is not an absolute http(s) URL",
+				"line 9: archive.s3.region: must not be empty",
+			},
+		},
+		{
+			name: "traffic age source",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  onlyUseAgesOf: [namespace, traffic]
+traffic:
+  prometheusURL: http://prometheus.monitoring:9090
+  window: 24h
+`,
+			check: func(t *testing.T, config Config) {
+				if config.Traffic.Window.Duration != 24*time.Hour || config.Traffic.Query != DefaultTrafficQuery {
+					t.Errorf("traffic = %+v", config.Traffic)
+				}
+			},
+		},
+		{
+			name: "invalid traffic",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+namespaces:
+  onlyUseAgesOf: [traffic]
+traffic:
+  query: sum(rate(nginx_ingress_controller_requests[5m]))
+  window: 720h
+  step: 1m
+  timeout: 0s
+`,
+			wantErr: []string{
+				"line 4: namespaces.onlyUseAgesOf[0]: \"traffic\" requires traffic.prometheusURL",
+				"line 6: traffic.query: must contain \"$namespace\"",
+				"line 8: traffic.step: the window must not have more than 11000 steps",
+				"line 9: traffic.timeout: must be positive",
+			},
+		},
+		{
+			name: "traffic step longer than the rate range",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+traffic:
+  query: sum(rate(nginx_ingress_controller_requests{exported_namespace="$namespace"}[1h])) + sum(rate(haproxy_requests{namespace="$namespace"}[5m]))
+  step: 10m
+`,
+			wantErr: []string{
+				"line 5: traffic.step: must not be longer than the range 5m0s of traffic.query, requests between the steps would be missed",
+			},
+		},
+		{
+			name: "leader election requires controller mode",
+			data: `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+leaderElection:
+  enabled: true
+  renewDeadline: 20s
+`,
+			wantErr: []string{
+				"line 4: leaderElection.enabled: leader election is only supported in \"controller\" mode",
+				"leaderElection.leaseDuration: must be greater than renewDeadline",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(tt.data))
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("ParseConfig() error = %v", err)
+				}
+				tt.check(t, config)
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("ParseConfig() expected error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ParseConfig() error = %v, want it to contain %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	err := DefaultConfig().Validate()
+	if err != nil {
+		t.Errorf("DefaultConfig().Validate() error = %v", err)
+	}
+
+	_, err = DefaultConfig().Namespaces.NamespaceRules(AgeSourceClients{})
+	if err != nil {
+		t.Errorf("NamespaceRules() error = %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `apiVersion: k8s-gitlab-gc/v1alpha1
+kind: Config
+gitlab:
+  url: gitlab.example.com
+  stopEnvironments: true
+namespaces:
+  onlyUseAgesOf: [Jobs]
+`
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, source, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v, the file is only validated after the flags", err)
+	}
+
+	err = config.ValidateSource(source)
+	for _, want := range []string{
+		path + " line 4: gitlab.url: \"gitlab.example.com\" is not an absolute http(s) URL",
+		path + " line 7: namespaces.onlyUseAgesOf[0]: \"Jobs\" is not a valid key",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateSource() error = %v, want it to contain %s", err, want)
+		}
+	}
+
+	config.Gitlab.URL = "https://gitlab.example.com"
+	source.Override("gitlab.url", "gitlabURL")
+	config.Namespaces.OnlyUseAgesOf = []string{"pod", "Jobs"}
+	source.Override("namespaces.onlyUseAgesOf", "onlyUseAgesOf")
+
+	err = config.ValidateSource(source)
+	want := "flag -onlyUseAgesOf: namespaces.onlyUseAgesOf[1]: \"Jobs\" is not a valid key"
+	if err == nil || !strings.Contains(err.Error(), want) || strings.Contains(err.Error(), "gitlab.url") {
+		t.Errorf("ValidateSource() error = %v, want only %s", err, want)
+	}
+
+	config.Namespaces.OnlyUseAgesOf = []string{"pod"}
+	err = config.ValidateSource(source)
+	if err != nil {
+		t.Errorf("ValidateSource() error = %v, the flags fixed the file", err)
+	}
+}
@@ -0,0 +1,589 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller keeps informers warm and removes ci namespaces and gitlab executors as soon as they expire
+type Controller struct {
+	clientset     kubernetes.Interface
+	factory       informers.SharedInformerFactory
+	policyFactory dynamicinformer.DynamicSharedInformerFactory
+	listers       *Listers
+	policies      cache.GenericLister
+	queue         workqueue.TypedRateLimitingInterface[queueKey]
+	options       ControllerOptions
+	// nodes is only watched if the unreachable executor rule is enabled
+	nodes corelisters.NodeLister
+
+	// expiring holds the creation time of namespaces waiting for their max age, the oldest is exposed as metric
+	expiring     map[string]metav1.Time
+	expiringLock sync.Mutex
+
+	// compiled are the configured rules combined with the cached policies, they are compiled on policy changes only
+	compiled  NamespaceRules
+	rulesLock sync.RWMutex
+	// policiesLoaded is set by Start once the initial policies are compiled, the events of the initial list are ignored
+	policiesLoaded atomic.Bool
+	policyEvents   cache.ResourceEventHandlerRegistration
+	// policiesLock serializes the compilations, a slow one must not overwrite a newer one
+	policiesLock sync.Mutex
+}
+
+type ControllerOptions struct {
+	ResyncInterval time.Duration
+	// RunnerTargets select the gitlab executor pods, a pod is evaluated by the first target selecting it
+	RunnerTargets []RunnerTarget
+	Rules         NamespaceRules
+	DryRun        bool
+	// PolicyClient enables NamespaceGCPolicies, they are ignored if it is nil
+	PolicyClient dynamic.Interface
+}
+
+// queueKey identifies a namespace or, if pod is set, a gitlab executor pod
+type queueKey struct {
+	namespace string
+	pod       string
+}
+
+func (k queueKey) String() string {
+	if k.pod == "" {
+		return "namespace " + k.namespace
+	}
+	return "pod " + k.namespace + "/" + k.pod
+}
+
+// NewController wires the informers, every change to a namespace, the workloads in it or a policy triggers a re-evaluation
+func NewController(clientset kubernetes.Interface, options ControllerOptions) (*Controller, error) {
+	factory := informers.NewSharedInformerFactory(clientset, options.ResyncInterval)
+
+	c := &Controller{
+		clientset: clientset,
+		factory:   factory,
+		listers:   NewListers(factory),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[queueKey](),
+			workqueue.TypedRateLimitingQueueConfig[queueKey]{Name: "k8s-gitlab-gc"},
+		),
+		options:  options,
+		expiring: map[string]metav1.Time{},
+		compiled: options.Rules,
+	}
+
+	_, err := factory.Core().V1().Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueNamespace,
+		UpdateFunc: func(oldObj, obj any) {
+			if !onlyEvaluated(oldObj, obj) {
+				c.enqueueNamespace(obj)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch namespaces: %v", err)
+	}
+
+	_, err = factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueuePod,
+		UpdateFunc: func(_, obj any) { c.enqueuePod(obj) },
+		DeleteFunc: c.enqueueOwningNamespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pods: %v", err)
+	}
+
+	workloadHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueOwningNamespace,
+		UpdateFunc: func(_, obj any) { c.enqueueOwningNamespace(obj) },
+		DeleteFunc: c.enqueueOwningNamespace,
+	}
+	workloadInformers := []cache.SharedIndexInformer{
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Apps().V1().StatefulSets().Informer(),
+		factory.Apps().V1().DaemonSets().Informer(),
+		factory.Batch().V1().CronJobs().Informer(),
+	}
+	// policies can select the activity of deployments at any time, their replica sets are cached whenever policies are enabled
+	if usesReplicaSets(options.Rules.AgeSources) || options.PolicyClient != nil {
+		c.listers.ReplicaSets = factory.Apps().V1().ReplicaSets().Lister()
+		workloadInformers = append(workloadInformers, factory.Apps().V1().ReplicaSets().Informer())
+	}
+	for _, informer := range workloadInformers {
+		_, err = informer.AddEventHandler(workloadHandler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch workloads: %v", err)
+		}
+	}
+
+	if usesUnreachableRule(options.RunnerTargets) {
+		c.nodes = factory.Core().V1().Nodes().Lister()
+	}
+
+	// age sources like jobs.batch read from informers, those of policies are watched once the policy is compiled
+	if resources := options.Rules.AgeSourceClients.Resources; resources != nil {
+		err = resources.UseInformers(options.ResyncInterval, workloadHandler)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.PolicyClient != nil {
+		c.policyFactory = dynamicinformer.NewDynamicSharedInformerFactory(options.PolicyClient, options.ResyncInterval)
+		policyInformer := c.policyFactory.ForResource(NamespaceGCPolicyResource)
+		c.policies = policyInformer.Lister()
+
+		c.policyEvents, err = policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(_ any) { c.updatePolicies() },
+			UpdateFunc: func(_, _ any) { c.updatePolicies() },
+			DeleteFunc: func(_ any) { c.updatePolicies() },
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch namespace gc policies: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Start starts the informers and waits for their caches to sync, standbys call it to be ready for a takeover
+func (c *Controller) Start(ctx context.Context) error {
+	c.factory.Start(ctx.Done())
+
+	for informerType, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer cache for %v", informerType)
+		}
+	}
+
+	resources := c.options.Rules.AgeSourceClients.Resources
+	if resources != nil {
+		err := resources.StartInformers(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.policyFactory == nil {
+		return nil
+	}
+
+	c.policyFactory.Start(ctx.Done())
+
+	for resource, synced := range c.policyFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer cache for %v", resource)
+		}
+	}
+
+	// the namespaces must not be evaluated without the policies covering them
+	if !cache.WaitForCacheSync(ctx.Done(), c.policyEvents.HasSynced) {
+		return fmt.Errorf("failed to sync informer cache for %v", NamespaceGCPolicyResource)
+	}
+	c.policiesLoaded.Store(true)
+	c.updatePolicies()
+
+	// wait for the informers of the age sources of the policies, too
+	if resources != nil {
+		return resources.StartInformers(ctx)
+	}
+
+	return nil
+}
+
+// Run processes the queue until the context is canceled, Start has to be called before
+func (c *Controller) Run(ctx context.Context, workers int) {
+	defer c.queue.ShutDown()
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	if len(orphanTargets(c.options.RunnerTargets)) > 0 && c.options.ResyncInterval > 0 {
+		go wait.UntilWithContext(ctx, c.collectOrphans, c.options.ResyncInterval)
+	}
+
+	if c.options.Rules.Archiver != nil && !c.options.DryRun && c.options.ResyncInterval > 0 {
+		go wait.UntilWithContext(ctx, c.endArchiveRun, c.options.ResyncInterval)
+	}
+
+	<-ctx.Done()
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	timeout := apiTimeout
+	if key.pod == "" {
+		timeout = c.rules().namespaceTimeout()
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := c.sync(syncCtx, key)
+	if err != nil {
+		slog.Error("failed to process", "key", key.String(), "error", err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) sync(ctx context.Context, key queueKey) error {
+	if key.pod != "" {
+		defer observeDuration("executor_sync", time.Now())
+		return c.syncGitlabExecutor(ctx, key)
+	}
+
+	defer observeDuration("namespace_sync", time.Now())
+	return c.syncNamespace(ctx, key)
+}
+
+func (c *Controller) syncNamespace(ctx context.Context, key queueKey) error {
+	ns, err := c.listers.Namespaces.Get(key.namespace)
+	if apierrors.IsNotFound(err) {
+		c.trackExpiring(key.namespace, nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	api := NewKubernetesListerClient(c.clientset, c.listers, *ns)
+	rules := c.rules()
+
+	decision, err := shouldDeleteNamespace(ctx, api, rules, c.options.DryRun)
+	if err != nil {
+		return err
+	}
+
+	observeEvaluation(namespaceResource, decision.Name.Project, decision.Delete, decision.Reason)
+	logDecision(decision, c.options.DryRun)
+	recordDecision(rules.Events, *ns, decision, c.options.DryRun, rules.DeletionNotice)
+
+	if !c.options.DryRun {
+		err := rules.scheduleDeletion(ctx, c.clientset, *ns, decision, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if rules.Annotate && !c.options.DryRun {
+		err := annotateNamespace(ctx, c.clientset, *ns, decision, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if decision.Hibernate {
+		err := hibernate(ctx, api, rules, decision, c.options.DryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	if decision.Expires {
+		c.trackExpiring(key.namespace, &ns.ObjectMeta.CreationTimestamp)
+
+		// wake up for the deletion notice before the deletion itself
+		requeueIn := time.Duration(decision.ExpiresIn) * time.Second
+		if rules.DeletionNotice > 0 && decision.ExpiresIn > rules.DeletionNotice {
+			requeueIn -= time.Duration(rules.DeletionNotice) * time.Second
+		}
+		if pollsExternalSources(rules) {
+			requeueIn = min(requeueIn, externalPollInterval)
+		}
+		c.queue.AddAfter(key, requeueIn)
+		return nil
+	}
+
+	c.trackExpiring(key.namespace, nil)
+
+	if !decision.Delete {
+		// no event announces a gone branch or the traffic of a namespace
+		if pollsExternalSources(rules) && isEvaluated(decision) {
+			c.queue.AddAfter(key, externalPollInterval)
+		}
+		return nil
+	}
+
+	err = deleteNamespace(ctx, api, rules, decision, c.options.DryRun)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Controller) syncGitlabExecutor(ctx context.Context, key queueKey) error {
+	pod, err := c.listers.Pods.Pods(key.namespace).Get(key.pod)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var nodes nodeGetter
+	if c.nodes != nil {
+		nodes = c.nodes.Get
+	}
+
+	target, ok := matchingTarget(c.options.RunnerTargets, pod)
+	if !ok {
+		return nil
+	}
+
+	evaluation, err := evaluateGitlabExecutor(ctx, *pod, target.Rules, nodes)
+	if err != nil {
+		return err
+	}
+
+	observeEvaluation(podResource, "", evaluation.delete, evaluation.skipReason())
+
+	if evaluation.expires {
+		c.queue.AddAfter(key, time.Duration(evaluation.expiresIn)*time.Second)
+		return nil
+	}
+
+	if !evaluation.delete {
+		return nil
+	}
+
+	err = deleteGitlabExecutor(ctx, c.clientset.CoreV1().Pods(key.namespace), *pod, evaluation, target.Rules.Events, c.options.DryRun)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// externalPollInterval re-evaluates ci namespaces whose decision depends on gitlab or the traffic, no event reports their changes
+const externalPollInterval = 5 * time.Minute
+
+// pollsExternalSources reports rules consulting gitlab or the traffic of namespaces
+func pollsExternalSources(rules NamespaceRules) bool {
+	return rules.Gitlab != nil || rules.AgeSourceClients.Traffic != nil
+}
+
+// isEvaluated reports decisions about ci namespaces, namespaces which are no ci namespaces, system namespaces and terminating
+// namespaces are never deleted
+func isEvaluated(decision Decision) bool {
+	return decision.Reason != reasonNotCI && decision.Reason != reasonSystem && decision.Reason != reasonTerminating
+}
+
+// onlyEvaluated reports namespace updates which only changed the annotations written on every evaluation, they would re-evaluate
+// the namespace right after each evaluation, resyncs of unchanged namespaces are kept
+func onlyEvaluated(oldObj, obj any) bool {
+	oldNs, ok := oldObj.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok || oldNs.ObjectMeta.ResourceVersion == ns.ObjectMeta.ResourceVersion {
+		return false
+	}
+
+	return equality.Semantic.DeepEqual(withoutEvaluation(oldNs), withoutEvaluation(ns))
+}
+
+// withoutEvaluation strips a copy of the namespace of the annotations written on every evaluation and the metadata of the write
+func withoutEvaluation(ns *v1.Namespace) *v1.Namespace {
+	ns = ns.DeepCopy()
+	ns.ObjectMeta.ResourceVersion = ""
+	ns.ObjectMeta.ManagedFields = nil
+	for _, key := range evaluationAnnotations {
+		delete(ns.ObjectMeta.Annotations, key)
+	}
+	if len(ns.ObjectMeta.Annotations) == 0 {
+		ns.ObjectMeta.Annotations = nil
+	}
+	return ns
+}
+
+// trackExpiring adds a namespace waiting for its max age or removes it if created is nil
+func (c *Controller) trackExpiring(name string, created *metav1.Time) {
+	c.expiringLock.Lock()
+	defer c.expiringLock.Unlock()
+
+	if created == nil {
+		delete(c.expiring, name)
+	} else {
+		c.expiring[name] = *created
+	}
+
+	oldest := int64(0)
+	for _, created := range c.expiring {
+		oldest = max(oldest, age(created))
+	}
+	oldestNamespaceAge.Set(float64(oldest))
+}
+
+// collectOrphans removes side objects of gone executor pods, they are listed live to not cache all secrets of the cluster
+func (c *Controller) collectOrphans(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	for _, target := range orphanTargets(c.options.RunnerTargets) {
+		pods, err := c.listers.Pods.Pods(target.Namespace).List(labels.Everything())
+		if err != nil {
+			slog.Error("failed to list gitlab executors", "namespace", target.Namespace, "error", err)
+			return
+		}
+
+		livePods := []v1.Pod{}
+		for _, pod := range pods {
+			livePods = append(livePods, *pod)
+		}
+
+		err = gitlabExecutorOrphans(ctx, c.clientset, target.Namespace, livePods, target.Rules.MaxOrphanAge, c.options.DryRun)
+		if err != nil {
+			slog.Error("failed to clean up gitlab executor side objects", "namespace", target.Namespace, "error", err)
+		}
+	}
+}
+
+// endArchiveRun prunes expired archives and discovers the archived resources again at every resync
+func (c *Controller) endArchiveRun(ctx context.Context) {
+	c.options.Rules.Archiver.EndRun(ctx, time.Now())
+}
+
+// rules returns the configured rules combined with the cached policies as compiled by updatePolicies
+func (c *Controller) rules() NamespaceRules {
+	c.rulesLock.RLock()
+	defer c.rulesLock.RUnlock()
+
+	return c.compiled
+}
+
+// updatePolicies compiles the cached policies and re-evaluates all namespaces, it runs on policy changes only as
+// compiling resolves age sources by discovery, Start compiles the policies of the initial list once
+func (c *Controller) updatePolicies() {
+	if !c.policiesLoaded.Load() {
+		return
+	}
+
+	c.policiesLock.Lock()
+	defer c.policiesLock.Unlock()
+
+	objects, err := c.policies.List(labels.Everything())
+	if err != nil {
+		slog.Error("failed to list namespace gc policies", "error", err)
+		return
+	}
+
+	policies := []NamespaceGCPolicy{}
+	for _, object := range objects {
+		u, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		policy, err := namespaceGCPolicyFromUnstructured(u)
+		if err != nil {
+			slog.Warn("skipping invalid namespace gc policy", "policy", u.GetName(), "error", err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	rules := c.options.Rules.WithPolicies(policies)
+
+	c.rulesLock.Lock()
+	c.compiled = rules
+	c.rulesLock.Unlock()
+
+	c.enqueueAllNamespaces()
+}
+
+func (c *Controller) enqueueAllNamespaces() {
+	namespaces, err := c.listers.Namespaces.List(labels.Everything())
+	if err != nil {
+		slog.Error("failed to list namespaces", "error", err)
+		return
+	}
+
+	for _, ns := range namespaces {
+		c.queue.Add(queueKey{namespace: ns.ObjectMeta.Name})
+	}
+}
+
+func (c *Controller) enqueueNamespace(obj any) {
+	object, ok := objectMeta(obj)
+	if !ok {
+		return
+	}
+
+	c.queue.Add(queueKey{namespace: object.GetName()})
+}
+
+// enqueueOwningNamespace re-evaluates the namespace of a changed workload, as its youngest age might have changed
+func (c *Controller) enqueueOwningNamespace(obj any) {
+	object, ok := objectMeta(obj)
+	if !ok {
+		return
+	}
+
+	c.queue.Add(queueKey{namespace: object.GetNamespace()})
+}
+
+func (c *Controller) enqueuePod(obj any) {
+	c.enqueueOwningNamespace(obj)
+
+	object, ok := objectMeta(obj)
+	if !ok {
+		return
+	}
+
+	_, ok = matchingTarget(c.options.RunnerTargets, object)
+	if !ok {
+		return
+	}
+
+	c.queue.Add(queueKey{namespace: object.GetNamespace(), pod: object.GetName()})
+}
+
+// objectMeta unwraps objects from informer events including tombstones of missed deletions
+func objectMeta(obj any) (metav1.Object, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, false
+	}
+
+	return object, true
+}
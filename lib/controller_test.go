@@ -0,0 +1,426 @@
+package gc
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newSyncedController(t *testing.T, ctx context.Context, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	clientset := fake.NewClientset(objects...)
+
+	rules := NamespaceRules{
+		AgeSources:    builtinAgeSources("namespace", "pod"),
+		MaxTestingAge: int64(60 * 60),
+		MaxReviewAge:  int64(60 * 60 * 24),
+	}
+
+	c, err := NewController(clientset, ControllerOptions{
+		RunnerTargets: []RunnerTarget{{Namespace: "gitlab-runner", Rules: ExecutorRules{MaxAge: int64(60 * 60)}}},
+		Rules:         rules,
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	err = c.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	return c, clientset
+}
+
+func deletedNames(actions []k8stesting.Action) []string {
+	names := []string{}
+	for _, action := range actions {
+		if deleteAction, ok := action.(k8stesting.DeleteAction); ok {
+			names = append(names, deleteAction.GetName())
+		}
+	}
+	return names
+}
+
+func TestController_sync(t *testing.T) {
+	now := time.Now()
+
+	namespace := func(name string, created time.Time) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	pod := func(namespace, name string, labels map[string]string, created time.Time) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	executorLabels := map[string]string{"app": "gitlab-ci-job"}
+
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		key         queueKey
+		wantDeleted []string
+	}{
+		{
+			name:        "delete expired ci namespace",
+			objects:     []runtime.Object{namespace("project-shop-ci", now.Add(-48*time.Hour))},
+			key:         queueKey{namespace: "project-shop-ci"},
+			wantDeleted: []string{"project-shop-ci"},
+		},
+		{
+			name:        "keep young ci namespace",
+			objects:     []runtime.Object{namespace("project-shop-ci", now.Add(-time.Hour))},
+			key:         queueKey{namespace: "project-shop-ci"},
+			wantDeleted: []string{},
+		},
+		{
+			name: "keep ci namespace with young pod",
+			objects: []runtime.Object{
+				namespace("project-shop-ci", now.Add(-48*time.Hour)),
+				pod("project-shop-ci", "app", nil, now.Add(-time.Hour)),
+			},
+			key:         queueKey{namespace: "project-shop-ci"},
+			wantDeleted: []string{},
+		},
+		{
+			name:        "keep non ci namespace",
+			objects:     []runtime.Object{namespace("project-shop", now.Add(-48*time.Hour))},
+			key:         queueKey{namespace: "project-shop"},
+			wantDeleted: []string{},
+		},
+		{
+			name:        "ignore missing namespace",
+			key:         queueKey{namespace: "project-shop-ci"},
+			wantDeleted: []string{},
+		},
+		{
+			name:        "delete expired gitlab executor",
+			objects:     []runtime.Object{pod("gitlab-runner", "runner-1", executorLabels, now.Add(-2*time.Hour))},
+			key:         queueKey{namespace: "gitlab-runner", pod: "runner-1"},
+			wantDeleted: []string{"runner-1"},
+		},
+		{
+			name:        "keep young gitlab executor",
+			objects:     []runtime.Object{pod("gitlab-runner", "runner-1", executorLabels, now.Add(-time.Minute))},
+			key:         queueKey{namespace: "gitlab-runner", pod: "runner-1"},
+			wantDeleted: []string{},
+		},
+		{
+			name:        "keep other pods",
+			objects:     []runtime.Object{pod("gitlab-runner", "runner-1", nil, now.Add(-2*time.Hour))},
+			key:         queueKey{namespace: "gitlab-runner", pod: "runner-1"},
+			wantDeleted: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			c, clientset := newSyncedController(t, ctx, tt.objects...)
+			clientset.ClearActions()
+
+			err := c.sync(ctx, tt.key)
+			if err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			deleted := deletedNames(clientset.Actions())
+			if len(deleted) != len(tt.wantDeleted) {
+				t.Fatalf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			for i := range deleted {
+				if deleted[i] != tt.wantDeleted[i] {
+					t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+				}
+			}
+		})
+	}
+}
+
+func TestController_requeueOnExpiry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the namespace reaches the max testing age of 1h in one second
+	created := time.Now().Add(-time.Hour + time.Second)
+	c, _ := newSyncedController(t, ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "project-shop-ci-54823-3a5db1781ab7cde0c53a3b53d995b75ee5873243",
+		CreationTimestamp: metav1.NewTime(created),
+	}})
+
+	// drain the keys queued by the informer add events
+	for c.queue.Len() > 0 {
+		key, _ := c.queue.Get()
+		c.queue.Done(key)
+		c.queue.Forget(key)
+	}
+
+	key := queueKey{namespace: "project-shop-ci-54823-3a5db1781ab7cde0c53a3b53d995b75ee5873243"}
+	err := c.sync(ctx, key)
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	got, _ := c.queue.Get()
+	if got != key {
+		t.Errorf("queued key = %v, want %v", got, key)
+	}
+}
+
+func TestController_policyAgeSourceInformers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job := &unstructured.Unstructured{}
+	job.SetAPIVersion("batch/v1")
+	job.SetKind("Job")
+	job.SetNamespace("team-a-preview")
+	job.SetName("migrate")
+	job.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-time.Hour)))
+
+	policy := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "k8s-gitlab-gc.utopia-planitia.non-existing-tld/v1alpha1",
+		"kind":       "NamespaceGCPolicy",
+		"metadata":   map[string]any{"name": "team-a"},
+		"spec": map[string]any{
+			"namespaceSelector": map[string]any{"namePattern": "^team-a-"},
+			"maxAge":            "6h",
+			"ageSources":        []any{"jobs.batch"},
+		},
+	}}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			NamespaceGCPolicyResource:                         "NamespaceGCPolicyList",
+			{Group: "batch", Version: "v1", Resource: "jobs"}: "JobList",
+		},
+		policy, job,
+	)
+
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "team-a-preview",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-24 * time.Hour)),
+	}})
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{Name: "jobs", SingularName: "job", Namespaced: true, Kind: "Job", Verbs: metav1.Verbs{"list", "watch"}}},
+	}}
+
+	rules := NamespaceRules{
+		AgeSources:       builtinAgeSources("namespace"),
+		MaxTestingAge:    int64(60 * 60),
+		MaxReviewAge:     int64(60 * 60 * 24),
+		PolicyAllowlist:  []*regexp.Regexp{regexp.MustCompile("^team-a-")},
+		AgeSourceClients: AgeSourceClients{Resources: NewResourceAges(clientset.Discovery(), dynamicClient)},
+	}
+	c, err := NewController(clientset, ControllerOptions{Rules: rules, PolicyClient: dynamicClient, DryRun: true})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	err = c.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if len(c.rules().policies) != 1 {
+		t.Fatalf("compiled policies = %d, want 1", len(c.rules().policies))
+	}
+
+	listedJobs := func() int {
+		count := 0
+		for _, action := range dynamicClient.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == "jobs" {
+				count++
+			}
+		}
+		return count
+	}
+	listed := listedJobs()
+
+	for range 3 {
+		err := c.sync(ctx, queueKey{namespace: "team-a-preview"})
+		if err != nil {
+			t.Fatalf("sync() error = %v", err)
+		}
+	}
+
+	// the job of an hour ago keeps the namespace, it is read from the informer of the policy's age source
+	if got := deletedNames(clientset.Actions()); len(got) != 0 {
+		t.Errorf("deleted = %v, want the namespace to be kept by its job", got)
+	}
+	if got := listedJobs(); got != listed {
+		t.Errorf("jobs listed %d times by syncs, want them to be read from the informer", got-listed)
+	}
+}
+
+func TestController_policyReplicaSetInformer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	policy := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "k8s-gitlab-gc.utopia-planitia.non-existing-tld/v1alpha1",
+		"kind":       "NamespaceGCPolicy",
+		"metadata":   map[string]any{"name": "team-a"},
+		"spec": map[string]any{
+			"namespaceSelector": map[string]any{"namePattern": "^team-a-"},
+			"maxAge":            "6h",
+			"ageSources":        []any{"deployment:activity"},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{NamespaceGCPolicyResource: "NamespaceGCPolicyList"},
+		policy,
+	)
+
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "team-a-preview",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-24 * time.Hour)),
+	}})
+
+	rules := NamespaceRules{
+		AgeSources:      builtinAgeSources("namespace"),
+		MaxTestingAge:   int64(60 * 60),
+		MaxReviewAge:    int64(60 * 60 * 24),
+		PolicyAllowlist: []*regexp.Regexp{regexp.MustCompile("^team-a-")},
+	}
+	c, err := NewController(clientset, ControllerOptions{Rules: rules, PolicyClient: dynamicClient, DryRun: true})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	err = c.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	listedReplicaSets := func() int {
+		count := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == "replicasets" {
+				count++
+			}
+		}
+		return count
+	}
+	listed := listedReplicaSets()
+
+	for range 3 {
+		err := c.sync(ctx, queueKey{namespace: "team-a-preview"})
+		if err != nil {
+			t.Fatalf("sync() error = %v", err)
+		}
+	}
+
+	// the global age sources don't use the activity of deployments, the replica sets of the policy are read from the informer anyway
+	if got := listedReplicaSets(); got != listed {
+		t.Errorf("replica sets listed %d times by syncs, want them to be read from the informer", got-listed)
+	}
+}
+
+// delayRecordingQueue records the delays of AddAfter instead of waiting for them
+type delayRecordingQueue struct {
+	workqueue.TypedRateLimitingInterface[queueKey]
+	delays map[queueKey]time.Duration
+}
+
+func (q *delayRecordingQueue) AddAfter(key queueKey, duration time.Duration) {
+	q.delays[key] = duration
+}
+
+func TestController_requeueExternalSources(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "project-shop-ci",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+	}})
+	rules := NamespaceRules{
+		AgeSources:    builtinAgeSources("namespace"),
+		MaxTestingAge: int64(60 * 60),
+		MaxReviewAge:  int64(60 * 60 * 24),
+	}
+
+	tests := []struct {
+		name   string
+		gitlab GitlabAPI
+		want   time.Duration
+	}{
+		{name: "expiry", want: 23 * time.Hour},
+		{name: "gitlab", gitlab: NewGitlabClient("http://gitlab.invalid", "secret", time.Second), want: externalPollInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := rules
+			rules.Gitlab = tt.gitlab
+			c, err := NewController(clientset, ControllerOptions{Rules: rules, DryRun: true})
+			if err != nil {
+				t.Fatalf("NewController() error = %v", err)
+			}
+			err = c.Start(ctx)
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			queue := &delayRecordingQueue{TypedRateLimitingInterface: c.queue, delays: map[queueKey]time.Duration{}}
+			c.queue = queue
+
+			key := queueKey{namespace: "project-shop-ci"}
+			err = c.sync(ctx, key)
+			if err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			// the namespace is an hour old, a second may pass while syncing
+			if got := queue.delays[key]; got > tt.want || got < tt.want-time.Minute {
+				t.Errorf("requeued after %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_onlyEvaluated(t *testing.T) {
+	namespace := func(resourceVersion string, labels, annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "project-shop-ci", ResourceVersion: resourceVersion, Labels: labels, Annotations: annotations}}
+	}
+	evaluated := map[string]string{LastEvaluatedAnnotation: "2026-10-17T12:00:00Z", ExpiresAtAnnotation: "2026-10-18T12:00:00Z"}
+	reevaluated := map[string]string{LastEvaluatedAnnotation: "2026-10-17T12:05:00Z", ExpiresAtAnnotation: "2026-10-18T12:00:00Z"}
+
+	tests := []struct {
+		name   string
+		oldObj any
+		obj    any
+		want   bool
+	}{
+		{name: "first annotation", oldObj: namespace("1", nil, nil), obj: namespace("2", nil, evaluated), want: true},
+		{name: "annotations refreshed", oldObj: namespace("2", nil, evaluated), obj: namespace("3", nil, reevaluated), want: true},
+		{name: "resync", oldObj: namespace("2", nil, evaluated), obj: namespace("2", nil, evaluated), want: false},
+		{name: "label removed", oldObj: namespace("2", map[string]string{ScheduledForDeletionLabel: "1"}, evaluated), obj: namespace("3", nil, evaluated), want: false},
+		{name: "opted out", oldObj: namespace("2", nil, nil), obj: namespace("3", nil, map[string]string{"disable-automatic-garbage-collection": "true"}), want: false},
+		{name: "tombstone", oldObj: namespace("2", nil, nil), obj: cache.DeletedFinalStateUnknown{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onlyEvaluated(tt.oldObj, tt.obj); got != tt.want {
+				t.Errorf("onlyEvaluated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
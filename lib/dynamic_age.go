@@ -0,0 +1,229 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resourceNamePattern matches resources like "configmaps", "jobs.batch" or "certificates.cert-manager.io"
+var resourceNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// ResourceAges resolves age sources of any namespaced resource by discovery and lists them with the dynamic client,
+// or from informers once the controller called UseInformers
+type ResourceAges struct {
+	mapper  meta.RESTMapper
+	dynamic dynamic.Interface
+
+	// resolved are the resources of all age functions, the informers watch them
+	resolved  map[schema.GroupVersionResource]bool
+	informers map[schema.GroupVersionResource]informers.GenericInformer
+	factory   dynamicinformer.DynamicSharedInformerFactory
+	handler   cache.ResourceEventHandler
+	stop      <-chan struct{}
+	lock      sync.Mutex
+}
+
+// NewResourceAges caches discovery, the cache is refreshed if a resource is unknown, e.g. a CRD installed after the start
+func NewResourceAges(discoveryClient discovery.DiscoveryInterface, dynamicClient dynamic.Interface) *ResourceAges {
+	return &ResourceAges{
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		dynamic:   dynamicClient,
+		resolved:  map[schema.GroupVersionResource]bool{},
+		informers: map[schema.GroupVersionResource]informers.GenericInformer{},
+	}
+}
+
+// UseInformers watches the resources of all age functions, already resolved or resolved later, and notifies the handler
+// of their changes, the age functions read from the informers instead of listing the resources on every evaluation
+func (r *ResourceAges) UseInformers(resync time.Duration, handler cache.ResourceEventHandler) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.factory = dynamicinformer.NewDynamicSharedInformerFactory(r.dynamic, resync)
+	r.handler = handler
+
+	for resource := range r.resolved {
+		_, err := r.watch(resource)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartInformers starts the informers and waits for their caches to sync, informers of resources resolved later are
+// started right away and their age functions fail until the cache synced
+func (r *ResourceAges) StartInformers(ctx context.Context) error {
+	r.lock.Lock()
+	if r.factory == nil {
+		r.lock.Unlock()
+		return nil
+	}
+	r.stop = ctx.Done()
+	factory := r.factory
+	factory.Start(r.stop)
+	r.lock.Unlock()
+
+	for resource, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer cache for %v", resource)
+		}
+	}
+
+	return nil
+}
+
+// informer returns the informer of the resource, it is nil without UseInformers
+func (r *ResourceAges) informer(resource schema.GroupVersionResource) (informers.GenericInformer, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.resolved[resource] = true
+	if r.factory == nil {
+		return nil, nil
+	}
+
+	return r.watch(resource)
+}
+
+// watch creates the informer of a resource once, it has to be called with the lock held
+func (r *ResourceAges) watch(resource schema.GroupVersionResource) (informers.GenericInformer, error) {
+	informer, ok := r.informers[resource]
+	if ok {
+		return informer, nil
+	}
+
+	informer = r.factory.ForResource(resource)
+	_, err := informer.Informer().AddEventHandler(r.handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %v", resource.GroupResource(), err)
+	}
+	r.informers[resource] = informer
+
+	if r.stop != nil {
+		r.factory.Start(r.stop)
+	}
+
+	return informer, nil
+}
+
+// list returns the objects of the resource in the namespace from its informer or, without informers, from the api server
+func (r *ResourceAges) list(ctx context.Context, resource schema.GroupVersionResource, namespace string) ([]unstructured.Unstructured, error) {
+	informer, err := r.informer(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if informer == nil {
+		list, err := r.dynamic.Resource(resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, apiError(resource.Resource, err)
+		}
+		return list.Items, nil
+	}
+
+	// a partial cache could make a namespace look older than it is
+	if !informer.Informer().HasSynced() {
+		return nil, fmt.Errorf("informer cache for %s is not synced yet", resource.GroupResource())
+	}
+
+	objects, err := informer.Lister().ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]unstructured.Unstructured, 0, len(objects))
+	for _, object := range objects {
+		if u, ok := object.(*unstructured.Unstructured); ok {
+			items = append(items, *u)
+		}
+	}
+	return items, nil
+}
+
+// AgeFunc resolves a resource written as "<resource>.<group>" to its preferred version, resources of the core group
+// have no group suffix, the resource has to be namespaced, with activity the age is measured from the latest change
+func (r *ResourceAges) AgeFunc(name string, activity bool) (YoungestResourceAgeFunc, error) {
+	resource, err := r.mapper.ResourceFor(schema.ParseGroupResource(name).WithVersion(""))
+	if err != nil {
+		return nil, fmt.Errorf("unknown resource \"%s\": %v", name, err)
+	}
+
+	kind, err := r.mapper.KindFor(resource)
+	if err != nil {
+		return nil, fmt.Errorf("unknown resource \"%s\": %v", name, err)
+	}
+
+	mapping, err := r.mapper.RESTMapping(kind.GroupKind(), kind.Version)
+	if err != nil {
+		return nil, fmt.Errorf("unknown resource \"%s\": %v", name, err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, fmt.Errorf("resource \"%s\" is not namespaced", name)
+	}
+
+	// with informers the resource is watched as soon as it is resolved
+	_, err = r.informer(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+		items, err := r.list(ctx, resource, api.Namespace().ObjectMeta.Name)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if activity {
+			return getYoungestItemsResourceAge(items, unstructuredActivityTime)
+		}
+
+		creationTimestampGetter := func(item unstructured.Unstructured) metav1.Time {
+			return item.GetCreationTimestamp()
+		}
+
+		return getYoungestItemsResourceAge(items, creationTimestampGetter)
+	}, nil
+}
+
+// validateAgeSourceName accepts the keys of AvailableAgeFuncs and names of resources, both with an optional activity suffix,
+// whether the resource exists is only known after discovery
+func validateAgeSourceName(name string) error {
+	if name == TrafficAgeSource {
+		return nil
+	}
+
+	base, _ := splitActivity(name)
+	if _, ok := AvailableAgeFuncs[base]; ok {
+		return nil
+	}
+
+	return validateResourceName(base)
+}
+
+func validateResourceName(name string) error {
+	if !resourceNamePattern.MatchString(name) {
+		return fmt.Errorf("\"%s\" is not a valid key, valid options are: \"%s\" or a resource like \"jobs.batch\"", name, strings.Join(AvailableAgeFuncNames(), ","))
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package gc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestResourceAges_AgeFunc(t *testing.T) {
+	object := func(apiVersion, kind, namespace, name string, age time.Duration) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		u.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-age)))
+		return u
+	}
+
+	clientset := fake.NewClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", SingularName: "configmap", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"list"}},
+			},
+		},
+		{
+			GroupVersion: "batch/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "jobs", SingularName: "job", Namespaced: true, Kind: "Job", Verbs: metav1.Verbs{"list"}},
+			},
+		},
+		{
+			GroupVersion: "rbac.authorization.k8s.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "clusterroles", SingularName: "clusterrole", Namespaced: false, Kind: "ClusterRole", Verbs: metav1.Verbs{"list"}},
+			},
+		},
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}:           "ConfigMapList",
+		{Group: "batch", Version: "v1", Resource: "jobs"}: "JobList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		object("batch/v1", "Job", "shop-ci-feature", "migrate", 2*time.Hour),
+		object("batch/v1", "Job", "shop-ci-feature", "seed", time.Hour),
+		object("batch/v1", "Job", "other", "import", time.Minute),
+	)
+
+	resources := NewResourceAges(clientset.Discovery(), dynamicClient)
+	api := &KubernetesAPIMock{namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}}}
+
+	tests := []struct {
+		name    string
+		want    ResourceAge
+		found   bool
+		wantErr string
+	}{
+		{name: "jobs.batch", want: 3600, found: true},
+		{name: "job.batch", want: 3600, found: true},
+		{name: "configmaps", found: false},
+		{name: "clusterroles.rbac.authorization.k8s.io", wantErr: "is not namespaced"},
+		{name: "certificates.cert-manager.io", wantErr: "unknown resource"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ageFn, err := resources.AgeFunc(tt.name, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("AgeFunc() error = %v, want it to contain %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AgeFunc() error = %v", err)
+			}
+
+			got, found, err := ageFn(context.Background(), api)
+			if err != nil {
+				t.Fatalf("age error = %v", err)
+			}
+			if found != tt.found {
+				t.Errorf("found = %v, want %v", found, tt.found)
+			}
+			// creation timestamps are truncated to seconds
+			if got < tt.want || got > tt.want+1 {
+				t.Errorf("age = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAgeSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr string
+	}{
+		{name: "built in", names: []string{"namespace", "pod"}, want: []string{"namespace", "pod"}},
+		{name: "built in activity", names: []string{"namespace", "deployment:activity"}, want: []string{"namespace", "deployment:activity"}},
+		{name: "resource activity without discovery", names: []string{"jobs.batch:activity"}, wantErr: "requires discovery"},
+		{name: "resource without discovery", names: []string{"namespace", "jobs.batch"}, wantErr: "requires discovery"},
+		{name: "invalid name", names: []string{"Jobs"}, wantErr: "is not a valid key"},
+		{name: "traffic without prometheus", names: []string{"namespace", "traffic"}, wantErr: "requires a prometheus url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := SelectAgeSources(tt.names, AgeSourceClients{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SelectAgeSources() error = %v, want it to contain %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectAgeSources() error = %v", err)
+			}
+
+			got := []string{}
+			for _, source := range sources {
+				got = append(got, source.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("SelectAgeSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestContinuousIntegrationNamespaces_failedResourceAge forbids listing jobs in one namespace, the other namespace is collected anyway
+func TestContinuousIntegrationNamespaces_failedResourceAge(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-72 * time.Hour))
+	clientset := fake.NewClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-denied", CreationTimestamp: created}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-expired", CreationTimestamp: created}},
+	)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{Name: "jobs", SingularName: "job", Namespaced: true, Kind: "Job", Verbs: metav1.Verbs{"list"}}},
+	}}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{{Group: "batch", Version: "v1", Resource: "jobs"}: "JobList"},
+	)
+	dynamicClient.PrependReactor("list", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "shop-ci-denied" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "", nil)
+	})
+
+	sources, err := SelectAgeSources([]string{"namespace", "jobs.batch"}, AgeSourceClients{Resources: NewResourceAges(clientset.Discovery(), dynamicClient)})
+	if err != nil {
+		t.Fatalf("SelectAgeSources() error = %v", err)
+	}
+	rules := NamespaceRules{AgeSources: sources, MaxTestingAge: int64(60 * 60), MaxReviewAge: int64(60 * 60 * 24)}
+
+	err = ContinuousIntegrationNamespaces(context.Background(), clientset, rules, false)
+	if err == nil || !strings.Contains(err.Error(), "kept 1 namespaces whose age sources failed: shop-ci-denied") {
+		t.Errorf("ContinuousIntegrationNamespaces() error = %v, want shop-ci-denied to be kept", err)
+	}
+
+	if got := deletedNames(clientset.Actions()); strings.Join(got, ",") != "shop-ci-expired" {
+		t.Errorf("deleted = %v, want the namespace after the failed age source", got)
+	}
+}
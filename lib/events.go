@@ -0,0 +1,204 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/record/util"
+)
+
+// reasons of the events the gc records
+const (
+	DeletingEventReason             = "Deleting"
+	DeletionScheduledEventReason    = "DeletionScheduled"
+	InvalidTTLEventReason           = "InvalidTTL"
+	MissingAgeEventReason           = "MissingAge"
+	ScheduledForDeletionEventReason = "ScheduledForDeletion"
+	HibernatedEventReason           = "Hibernated"
+)
+
+// EventComponent is the source of the events the gc records
+const EventComponent = "k8s-gitlab-gc"
+
+// flushKind marks the event Flush records last, the sink doesn't send it but signals that the events recorded before it
+// were handled, they were sent or dropped by the spam filter of the recorder
+const flushKind = "Flush"
+
+// Events records kubernetes events on the namespaces and pods the gc acts on, a nil *Events records nothing
+type Events struct {
+	recorder    record.EventRecorder
+	broadcaster record.EventBroadcaster
+	client      typedcorev1.EventsGetter
+	flushed     chan struct{}
+}
+
+// NewEvents starts recording events through the api server
+func NewEvents(clientset kubernetes.Interface) *Events {
+	broadcaster := record.NewBroadcaster()
+	flushed := make(chan struct{}, 1)
+
+	sink := &typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")}
+	broadcaster.StartRecordingToSink(flushingSink{sink, flushed})
+
+	return &Events{
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: EventComponent}),
+		broadcaster: broadcaster,
+		client:      clientset.CoreV1(),
+		flushed:     flushed,
+	}
+}
+
+// Flush waits until all recorded events were sent or the timeout passed and stops recording, oneshot runs call it before exiting
+func (e *Events) Flush(timeout time.Duration) {
+	if e == nil || e.broadcaster == nil {
+		return
+	}
+
+	// events are handed to the sink in the order they were recorded
+	marker := &v1.ObjectReference{Kind: flushKind, Name: EventComponent, UID: uuid.NewUUID()}
+	e.recorder.Event(marker, v1.EventTypeNormal, flushKind, "all events before were handled")
+
+	select {
+	case <-e.flushed:
+	case <-time.After(timeout):
+	}
+
+	e.broadcaster.Shutdown()
+}
+
+func (e *Events) eventf(object runtime.Object, eventType, reason, messageFmt string, args ...any) {
+	if e == nil {
+		return
+	}
+
+	e.recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// namespace records an event which is listed by "kubectl get events" within the namespace itself,
+// events of the cluster scoped Namespace object would be stored in the default namespace otherwise
+func (e *Events) namespace(ns v1.Namespace, eventType, reason, messageFmt string, args ...any) {
+	ref := &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       ns.ObjectMeta.Name,
+		Namespace:  ns.ObjectMeta.Name,
+		UID:        ns.ObjectMeta.UID,
+	}
+	e.eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// namespaceNow records the event before it returns, the recorder sends events in the background and loses the race against
+// the deletion of the namespace, a terminating namespace rejects new events
+func (e *Events) namespaceNow(ctx context.Context, ns v1.Namespace, eventType, reason, messageFmt string, args ...any) {
+	if e == nil {
+		return
+	}
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.GenerateEventName(ns.ObjectMeta.Name, now.UnixNano()),
+			Namespace: ns.ObjectMeta.Name,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       ns.ObjectMeta.Name,
+			Namespace:  ns.ObjectMeta.Name,
+			UID:        ns.ObjectMeta.UID,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: EventComponent},
+	}
+
+	_, err := e.client.Events(ns.ObjectMeta.Name).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		slog.Warn("failed to record event", "namespace", ns.ObjectMeta.Name, "reason", reason, "error", err)
+	}
+}
+
+// recordDecision tells the developers of a namespace about its dry run deletion, a deletion within the notice period
+// and the reasons it can't be garbage collected, deleteNamespace records actual deletions
+func recordDecision(e *Events, ns v1.Namespace, decision Decision, dryRun bool, deletionNotice int64) {
+	switch {
+	case decision.Delete:
+		if dryRun || decision.DryRun {
+			e.namespace(ns, v1.EventTypeNormal, DeletingEventReason, "deleting namespace%s, %s", dryRunNote(true), describeDecision(decision))
+		}
+	case decision.Schedule:
+		deletion := time.Duration(decision.ExpiresIn) * time.Second
+		e.namespace(ns, v1.EventTypeWarning, ScheduledForDeletionEventReason, "namespace is scheduled for deletion in %s, add an opt-out annotation to keep it, %s", deletion, describeDecision(decision))
+	case decision.Reason == reasonInvalidTTL:
+		e.namespace(ns, v1.EventTypeWarning, InvalidTTLEventReason, "not garbage collected, %s", decision.Warning)
+	case decision.Reason == reasonMissingAge:
+		e.namespace(ns, v1.EventTypeWarning, MissingAgeEventReason, "not garbage collected, %s", decision.Warning)
+	case decision.Expires && deletionNotice > 0 && decision.ExpiresIn <= deletionNotice:
+		deletion := time.Duration(decision.ExpiresIn) * time.Second
+		e.namespace(ns, v1.EventTypeNormal, DeletionScheduledEventReason, "namespace will be deleted in %s, %s", deletion, describeDecision(decision))
+	}
+}
+
+// recordExecutorDeletion records the deletion of a gitlab executor pod on the pod
+func recordExecutorDeletion(e *Events, pod *v1.Pod, evaluation executorEvaluation, dryRun bool) {
+	message := fmt.Sprintf("deleting gitlab executor pod%s, rule: %s", dryRunNote(dryRun), evaluation.rule)
+	if evaluation.maxAge > 0 {
+		message += fmt.Sprintf(", maxAge: %ds", evaluation.maxAge)
+	}
+	if evaluation.jobStatus != "" {
+		message += fmt.Sprintf(", jobStatus: %s", evaluation.jobStatus)
+	}
+	e.eventf(pod, v1.EventTypeNormal, DeletingEventReason, "%s", message)
+}
+
+func describeDecision(decision Decision) string {
+	message := "reason: " + decision.Reason
+	if decision.Rule != "" {
+		message += ", rule: " + decision.Rule
+	}
+	if decision.Gitlab != "" {
+		message += ", " + decision.Gitlab
+	}
+	if decision.MaxAgeSource != "" {
+		message += fmt.Sprintf(", age: %ds of %s, maxAge: %ds from %s", decision.Age, decision.AgeSource, decision.MaxAge, decision.MaxAgeSource)
+	}
+	return message
+}
+
+func dryRunNote(dryRun bool) string {
+	if dryRun {
+		return " (dry run)"
+	}
+	return ""
+}
+
+// flushingSink signals the marker event of Flush instead of sending it
+type flushingSink struct {
+	record.EventSink
+	flushed chan<- struct{}
+}
+
+func (s flushingSink) Create(event *v1.Event) (*v1.Event, error) {
+	if event.InvolvedObject.Kind == flushKind && event.Source.Component == EventComponent {
+		select {
+		case s.flushed <- struct{}{}:
+		default:
+		}
+		return event, nil
+	}
+	return s.EventSink.Create(event)
+}
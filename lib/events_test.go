@@ -0,0 +1,150 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_recordDecision(t *testing.T) {
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}}
+
+	tests := []struct {
+		name     string
+		decision Decision
+		dryRun   bool
+		want     string
+	}{
+		{
+			// deleteNamespace records it before the deletion
+			name:     "deleted",
+			decision: Decision{Delete: true, Reason: reasonExpired, Rule: "class=review", Age: 7200, AgeSource: "pod", MaxAge: 3600, MaxAgeSource: maxAgeFromFlag},
+		},
+		{
+			name:     "dry run policy",
+			decision: Decision{Delete: true, DryRun: true, Reason: reasonExpired, Rule: "class=review", Age: 7200, AgeSource: "pod", MaxAge: 3600, MaxAgeSource: maxAgeFromFlag},
+			want:     "Normal Deleting deleting namespace (dry run), reason: expired, rule: class=review, age: 7200s of pod, maxAge: 3600s from flag",
+		},
+		{
+			name:     "dry run",
+			decision: Decision{Delete: true, Reason: reasonGitlab, Rule: "gitlab", Gitlab: "branch feature no longer exists"},
+			dryRun:   true,
+			want:     "Normal Deleting deleting namespace (dry run), reason: gitlab, rule: gitlab, branch feature no longer exists",
+		},
+		{
+			name:     "invalid ttl",
+			decision: Decision{Reason: reasonInvalidTTL, Warning: "ttl annotation ttl is invalid: time: invalid duration \"1 day\""},
+			want:     "Warning InvalidTTL not garbage collected, ttl annotation ttl is invalid: time: invalid duration \"1 day\"",
+		},
+		{
+			name:     "missing age",
+			decision: Decision{Reason: reasonMissingAge, Warning: "no resource of the age sources pod exists"},
+			want:     "Warning MissingAge not garbage collected, no resource of the age sources pod exists",
+		},
+		{
+			name:     "deletion within notice",
+			decision: Decision{Reason: reasonTooYoung, Rule: "class=review", Expires: true, ExpiresIn: 1800, Age: 1800, AgeSource: "namespace", MaxAge: 3600, MaxAgeSource: maxAgeFromClass},
+			want:     "Normal DeletionScheduled namespace will be deleted in 30m0s, reason: too-young, rule: class=review, age: 1800s of namespace, maxAge: 3600s from class",
+		},
+		{
+			name:     "scheduled for deletion",
+			decision: Decision{Reason: reasonScheduled, Rule: "class=review", Schedule: true, Expires: true, ExpiresIn: 3600},
+			want:     "Warning ScheduledForDeletion namespace is scheduled for deletion in 1h0m0s, add an opt-out annotation to keep it, reason: scheduled, rule: class=review",
+		},
+		{
+			name:     "deletion after notice",
+			decision: Decision{Reason: reasonTooYoung, Expires: true, ExpiresIn: 7200},
+		},
+		{
+			name:     "protected",
+			decision: Decision{Reason: reasonProtected, Rule: "protectedBranches=main"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+
+			recordDecision(&Events{recorder: recorder}, ns, tt.decision, tt.dryRun, int64(time.Hour.Seconds()))
+
+			got := ""
+			select {
+			case got = <-recorder.Events:
+			default:
+			}
+
+			if got != tt.want {
+				t.Errorf("recordDecision() event = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_recordDecision_disabled(t *testing.T) {
+	// a nil recorder records nothing
+	recordDecision(nil, v1.Namespace{}, Decision{Delete: true}, false, 0)
+	(*Events)(nil).Flush(time.Second)
+}
+
+func TestNewEvents(t *testing.T) {
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", UID: "uid"}}
+	clientset := fake.NewClientset(ns.DeepCopy())
+	events := NewEvents(clientset)
+	defer events.Flush(time.Second)
+
+	api := NewKubernetesClient(clientset, ns)
+	err := deleteNamespace(context.TODO(), api, NamespaceRules{Events: events}, Decision{Delete: true, Reason: reasonExpired}, false)
+	if err != nil {
+		t.Fatalf("deleteNamespace() error = %v", err)
+	}
+
+	// the event is recorded before the namespace is deleted, without waiting for Flush
+	list, err := clientset.CoreV1().Events("shop-ci-feature").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+
+	if len(list.Items) != 1 {
+		t.Fatalf("events = %v, want 1 event", list.Items)
+	}
+
+	event := list.Items[0]
+	if event.Reason != DeletingEventReason || event.InvolvedObject.Kind != "Namespace" || event.InvolvedObject.Name != "shop-ci-feature" ||
+		event.Source.Component != EventComponent || event.Message != "deleting namespace, reason: expired" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestEvents_Flush(t *testing.T) {
+	clientset := fake.NewClientset()
+	events := NewEvents(clientset)
+
+	// the spam filter of the recorder drops events of the same object beyond a burst of 25
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", UID: "uid"}}
+	for range 30 {
+		recordDecision(events, ns, Decision{Reason: reasonInvalidTTL, Warning: "ttl annotation ttl is invalid"}, false, 0)
+	}
+
+	start := time.Now()
+	events.Flush(5 * time.Second)
+	if time.Since(start) > time.Second {
+		t.Errorf("Flush() took %s, want it to return once the sent events were written", time.Since(start))
+	}
+
+	list, err := clientset.CoreV1().Events("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	for _, event := range list.Items {
+		if event.InvolvedObject.Kind == flushKind {
+			t.Errorf("Flush() sent its marker event %+v", event)
+		}
+	}
+	if len(list.Items) == 0 {
+		t.Errorf("events = %v, want the events before the flush", list.Items)
+	}
+}
@@ -0,0 +1,275 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// labels or annotations identifying the gitlab project, branch and merge request of a namespace
+const (
+	GitlabProjectKey      = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/gitlab-project"
+	GitlabBranchKey       = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/gitlab-branch"
+	GitlabMergeRequestKey = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/gitlab-merge-request"
+)
+
+// GitlabEnvironmentAnnotation is set by the gitlab kubernetes integration to the slug of the environment
+const GitlabEnvironmentAnnotation = "app.gitlab.com/env"
+
+// errGitlabForbidden is returned for 403 responses, the token lacks the scope or the role for the request
+var errGitlabForbidden = errors.New("403 forbidden")
+
+// GitlabAPI answers whether the branch or merge request of a namespace is still in use
+type GitlabAPI interface {
+	BranchExists(ctx context.Context, project, branch string) (bool, error)
+	MergeRequestOpen(ctx context.Context, project, iid string) (bool, error)
+	StopEnvironment(ctx context.Context, project, environment string) (bool, error)
+	JobStatus(ctx context.Context, project, job string) (string, error)
+}
+
+// GitlabClient is a minimal client of the gitlab REST API v4
+type GitlabClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewGitlabClient creates a client for the gitlab instance at baseURL, e.g. "https://gitlab.com"
+func NewGitlabClient(baseURL, token string, timeout time.Duration) *GitlabClient {
+	return &GitlabClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// BranchExists looks up the branch, project is the numeric id or the full path of the project
+func (c *GitlabClient) BranchExists(ctx context.Context, project, branch string) (bool, error) {
+	path := fmt.Sprintf("/projects/%s/repository/branches/%s", url.PathEscape(project), url.PathEscape(branch))
+
+	found, err := c.request(ctx, http.MethodGet, path, nil)
+	if err == nil && !found {
+		err = c.projectVisible(ctx, project)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up branch %s of project %s: %v", branch, project, err)
+	}
+
+	return found, nil
+}
+
+// MergeRequestOpen reports if the merge request is neither merged nor closed nor missing, iid is the merge request number within the project
+func (c *GitlabClient) MergeRequestOpen(ctx context.Context, project, iid string) (bool, error) {
+	path := fmt.Sprintf("/projects/%s/merge_requests/%s", url.PathEscape(project), url.PathEscape(iid))
+
+	mergeRequest := struct {
+		State string `json:"state"`
+	}{}
+
+	found, err := c.request(ctx, http.MethodGet, path, &mergeRequest)
+	if err == nil && !found {
+		err = c.projectVisible(ctx, project)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up merge request %s of project %s: %v", iid, project, err)
+	}
+
+	return found && mergeRequest.State != "merged" && mergeRequest.State != "closed", nil
+}
+
+// projectVisible tells a missing branch or merge request from a project which is missing, renamed or not visible to the
+// token, gitlab answers 404 for all of them
+func (c *GitlabClient) projectVisible(ctx context.Context, project string) error {
+	found, err := c.request(ctx, http.MethodGet, fmt.Sprintf("/projects/%s", url.PathEscape(project)), nil)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("project %s is missing, renamed or not visible to the token", project)
+	}
+
+	return nil
+}
+
+// StopEnvironment stops the available environment matching the name or slug, it reports false if there is none
+func (c *GitlabClient) StopEnvironment(ctx context.Context, project, environment string) (bool, error) {
+	type gitlabEnvironment struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+
+	const perPage = 100
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/projects/%s/environments?states=available&per_page=%d&page=%d", url.PathEscape(project), perPage, page)
+
+		environments := []gitlabEnvironment{}
+		_, err := c.request(ctx, http.MethodGet, path, &environments)
+		if err != nil {
+			return false, fmt.Errorf("failed to list environments of project %s: %v", project, err)
+		}
+
+		for _, env := range environments {
+			if env.Slug != environment && env.Name != environment {
+				continue
+			}
+
+			path := fmt.Sprintf("/projects/%s/environments/%d/stop", url.PathEscape(project), env.ID)
+			found, err := c.request(ctx, http.MethodPost, path, nil)
+			if errors.Is(err, errGitlabForbidden) {
+				return false, fmt.Errorf("failed to stop environment %s of project %s, the token needs the api scope and at least the developer role: %v", environment, project, err)
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to stop environment %s of project %s: %v", environment, project, err)
+			}
+
+			return found, nil
+		}
+
+		if len(environments) < perPage {
+			return false, nil
+		}
+	}
+}
+
+// JobStatus returns the status of the job, e.g. "running" or "canceled", it is empty if the job doesn't exist
+func (c *GitlabClient) JobStatus(ctx context.Context, project, job string) (string, error) {
+	path := fmt.Sprintf("/projects/%s/jobs/%s", url.PathEscape(project), url.PathEscape(job))
+
+	gitlabJob := struct {
+		Status string `json:"status"`
+	}{}
+
+	_, err := c.request(ctx, http.MethodGet, path, &gitlabJob)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up job %s of project %s: %v", job, project, err)
+	}
+
+	return gitlabJob.Status, nil
+}
+
+// request sends a request to path and decodes the response into v, a missing resource is not an error
+func (c *GitlabClient) request(ctx context.Context, method, path string, v any) (bool, error) {
+	found, err := c.do(ctx, method, path, v)
+	return found, apiError("gitlab", err)
+}
+
+func (c *GitlabClient) do(ctx context.Context, method, path string, v any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v4"+path, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		return false, errGitlabForbidden
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if v == nil {
+		return true, nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// gitlabRef returns the value of a label or, as label values can't contain slashes, an annotation
+func gitlabRef(meta metav1.ObjectMeta, key string) string {
+	value, ok := meta.Labels[key]
+	if ok {
+		return value
+	}
+	return meta.Annotations[key]
+}
+
+// gitlabGone checks the merge request or branch of the namespace, it returns the reason the namespace is no longer needed
+func gitlabGone(ctx context.Context, gitlab GitlabAPI, ns v1.Namespace) (string, error) {
+	project := gitlabRef(ns.ObjectMeta, GitlabProjectKey)
+	if project == "" {
+		return "", nil
+	}
+
+	mergeRequest := gitlabRef(ns.ObjectMeta, GitlabMergeRequestKey)
+	if mergeRequest != "" {
+		open, err := gitlab.MergeRequestOpen(ctx, project, mergeRequest)
+		if err != nil {
+			return "", err
+		}
+		if !open {
+			return fmt.Sprintf("merge request !%s is merged or closed", mergeRequest), nil
+		}
+		return "", nil
+	}
+
+	branch := gitlabRef(ns.ObjectMeta, GitlabBranchKey)
+	if branch != "" {
+		exists, err := gitlab.BranchExists(ctx, project, branch)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return fmt.Sprintf("branch %s no longer exists", branch), nil
+		}
+	}
+
+	return "", nil
+}
+
+// stopGitlabEnvironment stops the environment of a deleted namespace and logs the outcome, failures are logged as errors
+// but don't fail the garbage collection
+func stopGitlabEnvironment(ctx context.Context, gitlab GitlabAPI, ns v1.Namespace, environmentAnnotation string) {
+	environment := ns.ObjectMeta.Annotations[environmentAnnotation]
+	if environment == "" {
+		return
+	}
+
+	project := gitlabRef(ns.ObjectMeta, GitlabProjectKey)
+	if project == "" {
+		slog.Error("can't stop gitlab environment", "namespace", ns.ObjectMeta.Name, "environment", environment, "error", GitlabProjectKey+" is missing")
+		return
+	}
+
+	stopped, err := gitlab.StopEnvironment(ctx, project, environment)
+	if err != nil {
+		slog.Error("can't stop gitlab environment", "namespace", ns.ObjectMeta.Name, "environment", environment, "error", err)
+		return
+	}
+
+	if !stopped {
+		slog.Info("gitlab environment is not available", "namespace", ns.ObjectMeta.Name, "environment", environment)
+		return
+	}
+
+	slog.Info("stopped gitlab environment", "namespace", ns.ObjectMeta.Name, "environment", environment)
+}
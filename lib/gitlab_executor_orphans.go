@@ -0,0 +1,134 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sideObject is a secret, config map, service or persistent volume claim the runner creates next to an executor pod
+type sideObject struct {
+	kind   string
+	meta   metav1.ObjectMeta
+	delete func(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// gitlabExecutorOrphans removes runner-created side objects whose executor pod is gone, pods are the live pods of the namespace
+func gitlabExecutorOrphans(ctx context.Context, clientset kubernetes.Interface, namespace string, pods []v1.Pod, maxAge int64, dryRun bool) error {
+	objects, err := listSideObjects(ctx, clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	livePods := map[types.UID]bool{}
+	liveJobs := map[string]bool{}
+	for _, pod := range pods {
+		livePods[pod.ObjectMeta.UID] = true
+
+		job := gitlabRef(pod.ObjectMeta, runnerJobIDKey)
+		if job != "" {
+			liveJobs[job] = true
+		}
+	}
+
+	for _, object := range objects {
+		if !isOrphan(object.meta, livePods, liveJobs) {
+			continue
+		}
+
+		age := age(object.meta.CreationTimestamp)
+		if age < maxAge {
+			continue
+		}
+
+		slog.Info("deleting "+object.kind, object.kind, object.meta.Name, "namespace", namespace, "age", age, "maxOrphanAge", maxAge, "dryRun", dryRun)
+
+		if dryRun {
+			observeDeletion(object.kind+"s", "", "orphan", true)
+			continue
+		}
+
+		err := object.delete(ctx, object.meta.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s %s: %v", object.kind, object.meta.Name, apiError(object.kind+"s", err))
+		}
+
+		observeDeletion(object.kind+"s", "", "orphan", false)
+	}
+
+	return nil
+}
+
+func listSideObjects(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]sideObject, error) {
+	objects := []sideObject{}
+
+	secrets := clientset.CoreV1().Secrets(namespace)
+	secretList, err := secrets.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("secrets", err)
+	}
+	for _, item := range secretList.Items {
+		objects = append(objects, sideObject{"secret", item.ObjectMeta, secrets.Delete})
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	configMapList, err := configMaps.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("configmaps", err)
+	}
+	for _, item := range configMapList.Items {
+		objects = append(objects, sideObject{"configmap", item.ObjectMeta, configMaps.Delete})
+	}
+
+	services := clientset.CoreV1().Services(namespace)
+	serviceList, err := services.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("services", err)
+	}
+	for _, item := range serviceList.Items {
+		objects = append(objects, sideObject{"service", item.ObjectMeta, services.Delete})
+	}
+
+	claims := clientset.CoreV1().PersistentVolumeClaims(namespace)
+	claimList, err := claims.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("persistentvolumeclaims", err)
+	}
+	for _, item := range claimList.Items {
+		objects = append(objects, sideObject{"persistentvolumeclaim", item.ObjectMeta, claims.Delete})
+	}
+
+	return objects, nil
+}
+
+// isOrphan reports objects owned by pods which no longer exist or labeled with a job no live pod executes,
+// objects neither owned by a pod nor labeled with a job are not created by the runner and never orphans
+func isOrphan(meta metav1.ObjectMeta, livePods map[types.UID]bool, liveJobs map[string]bool) bool {
+	ownedByPod := false
+	for _, owner := range meta.OwnerReferences {
+		if owner.Kind != "Pod" || owner.APIVersion != "v1" {
+			continue
+		}
+
+		ownedByPod = true
+		if livePods[owner.UID] {
+			return false
+		}
+	}
+
+	if ownedByPod {
+		return true
+	}
+
+	job := gitlabRef(meta, runnerJobIDKey)
+	if job == "" {
+		return false
+	}
+
+	return !liveJobs[job]
+}
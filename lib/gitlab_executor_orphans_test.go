@@ -0,0 +1,96 @@
+package gc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_gitlabExecutorOrphans(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	young := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	livePod := v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "gitlab-runner",
+		Name:        "runner-abc-project-1-concurrent-0",
+		UID:         types.UID("live"),
+		Labels:      map[string]string{"app": "gitlab-ci-job"},
+		Annotations: map[string]string{runnerJobIDKey: "100"},
+	}}
+
+	meta := func(name string, created metav1.Time, ownerUID types.UID, job string) metav1.ObjectMeta {
+		meta := metav1.ObjectMeta{Namespace: "gitlab-runner", Name: name, CreationTimestamp: created}
+		if ownerUID != "" {
+			meta.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "runner", UID: ownerUID}}
+		}
+		if job != "" {
+			meta.Labels = map[string]string{runnerJobIDKey: job}
+		}
+		return meta
+	}
+
+	objects := []runtime.Object{
+		&v1.Secret{ObjectMeta: meta("owned-by-live-pod", old, "live", "")},
+		&v1.Secret{ObjectMeta: meta("owned-by-gone-pod", old, "gone", "")},
+		&v1.Secret{ObjectMeta: meta("young-owned-by-gone-pod", young, "gone", "")},
+		&v1.Secret{ObjectMeta: meta("not-created-by-runner", old, "", "")},
+		&v1.ConfigMap{ObjectMeta: meta("labeled-with-live-job", old, "", "100")},
+		&v1.ConfigMap{ObjectMeta: meta("labeled-with-gone-job", old, "", "99")},
+		&v1.Service{ObjectMeta: meta("service-of-gone-pod", old, "gone", "")},
+		&v1.PersistentVolumeClaim{ObjectMeta: meta("claim-of-gone-job", old, "", "98")},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "other-namespace", CreationTimestamp: old,
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "runner", UID: "gone"}}}},
+	}
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantDeleted []string
+	}{
+		{
+			name: "delete orphans",
+			wantDeleted: []string{
+				"configmaps/labeled-with-gone-job",
+				"persistentvolumeclaims/claim-of-gone-job",
+				"secrets/owned-by-gone-pod",
+				"services/service-of-gone-pod",
+			},
+		},
+		{
+			name:        "dry run",
+			dryRun:      true,
+			wantDeleted: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset(objects...)
+
+			err := gitlabExecutorOrphans(context.TODO(), clientset, "gitlab-runner", []v1.Pod{livePod}, int64(10*60), tt.dryRun)
+			if err != nil {
+				t.Fatalf("gitlabExecutorOrphans() error = %v", err)
+			}
+
+			deleted := []string{}
+			for _, action := range clientset.Actions() {
+				if deleteAction, ok := action.(k8stesting.DeleteAction); ok {
+					deleted = append(deleted, deleteAction.GetResource().Resource+"/"+deleteAction.GetName())
+				}
+			}
+			sort.Strings(deleted)
+
+			if strings.Join(deleted, ",") != strings.Join(tt.wantDeleted, ",") {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
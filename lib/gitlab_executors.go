@@ -2,49 +2,357 @@ package gc
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
-// GitlabExecutors removes gitlab execution pods
-func GitlabExecutors(ctx context.Context, client corev1.PodInterface, maxAge int64, dryRun bool) error {
-	pods, err := client.List(ctx, metav1.ListOptions{})
+// labels or annotations the gitlab runner kubernetes executor sets on its pods
+const (
+	runnerJobIDKey     = "job.runner.gitlab.com/id"
+	runnerProjectIDKey = "project.runner.gitlab.com/id"
+)
+
+// rules deciding when an executor pod is deleted
+const (
+	MaxAgeRule      = "maxAge"
+	JobStatusRule   = "jobStatus"
+	CompletedRule   = "completed"
+	StuckRule       = "stuck"
+	UnreachableRule = "unreachable"
+)
+
+// waiting reasons of containers which won't start without intervention
+var stuckReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"CreateContainerConfigError": true,
+}
+
+// ExecutorRules configures when gitlab executor pods are removed, max ages of 0 disable their rule
+type ExecutorRules struct {
+	// MaxAge applies to all executors which aren't running a job known to gitlab
+	MaxAge int64
+	// MaxCompletedAge applies to Succeeded and Failed pods, counted from the termination of their last container
+	MaxCompletedAge int64
+	// MaxStuckAge applies to Pending pods whose containers can't be created, e.g. because of ImagePullBackOff
+	MaxStuckAge int64
+	// MaxUnreachableAge applies to pods on NotReady or unreachable nodes, counted from the node becoming NotReady
+	MaxUnreachableAge int64
+	// ForceDeleteUnreachable deletes pods of the unreachable rule without grace period, the kubelet can't confirm it, so
+	// the containers may keep running on a partitioned node
+	ForceDeleteUnreachable bool
+	// MaxOrphanAge applies to secrets, config maps, services and persistent volume claims of executor pods which are gone
+	MaxOrphanAge int64
+	// Gitlab deletes pods of finished jobs immediately and spares pods of running jobs, it is not consulted if nil
+	Gitlab GitlabAPI
+	// Events records the deletions on the pods, no events are recorded if nil
+	Events *Events
+}
+
+// executorEvaluation is the outcome of evaluating a pod against the ExecutorRules
+type executorEvaluation struct {
+	delete bool
+	// expires is set for executors that are kept because they are too young
+	expires bool
+	// expiresIn is the number of seconds until the first rule applies
+	expiresIn int64
+	// rule is the name of the rule the pod is deleted by and maxAge its max age
+	rule   string
+	maxAge int64
+	// jobStatus is the status of the gitlab job the pod executes
+	jobStatus string
+	// force skips the grace period, see ExecutorRules.ForceDeleteUnreachable
+	force bool
+}
+
+// skipReason is "job-active" for pods of running jobs and "too-young" for pods not exceeding a rule yet
+func (e executorEvaluation) skipReason() string {
+	if isActiveJob(e.jobStatus) {
+		return "job-active"
+	}
+	return reasonTooYoung
+}
+
+// nodeGetter looks up the node a pod is scheduled on
+type nodeGetter func(name string) (*v1.Node, error)
+
+// GitlabExecutors removes gitlab execution pods of all targets, pods are evaluated by the first target selecting them
+func GitlabExecutors(ctx context.Context, clientset kubernetes.Interface, targets []RunnerTarget, dryRun bool) error {
+	defer observeDuration("executors", time.Now())
+
+	pods, err := listTargetPods(ctx, clientset, targets)
 	if err != nil {
 		return err
 	}
 
-	if len(pods.Items) == 0 {
-		return nil
+	nodes, err := listNodes(ctx, clientset, targets)
+	if err != nil {
+		return err
 	}
 
-	for _, pod := range pods.Items {
-		if !isGitlabJobPod(pod.ObjectMeta.Labels) {
+	livePods := []v1.Pod{}
+	for _, pod := range pods {
+		target, ok := matchingTarget(targets, &pod)
+		if !ok {
+			livePods = append(livePods, pod)
 			continue
 		}
 
-		age := age(pod.ObjectMeta.CreationTimestamp)
-		if age < maxAge {
-			continue
+		evaluation, err := evaluateGitlabExecutor(ctx, pod, target.Rules, nodes)
+		if err != nil {
+			return err
 		}
 
-		fmt.Printf("deleting pod: %s, age: %d, maxAge: %d, ageInHours: %d\n", pod.ObjectMeta.Name, age, maxAge, age/60/60)
+		observeEvaluation(podResource, "", evaluation.delete, evaluation.skipReason())
 
-		if dryRun {
+		if !evaluation.delete {
+			livePods = append(livePods, pod)
 			continue
 		}
 
-		err = client.Delete(ctx, pod.ObjectMeta.Name, metav1.DeleteOptions{})
+		err = deleteGitlabExecutor(ctx, clientset.CoreV1().Pods(pod.ObjectMeta.Namespace), pod, evaluation, target.Rules.Events, dryRun)
 		if err != nil {
 			return err
 		}
 	}
 
+	for _, target := range orphanTargets(targets) {
+		err := gitlabExecutorOrphans(ctx, clientset, target.Namespace, podsIn(livePods, target.Namespace), target.Rules.MaxOrphanAge, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listNodes looks up nodes from a single list call, nodes are only listed if a target uses the unreachable rule
+func listNodes(ctx context.Context, clientset kubernetes.Interface, targets []RunnerTarget) (nodeGetter, error) {
+	if !usesUnreachableRule(targets) {
+		return nil, nil
+	}
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, apiError("nodes", err)
+	}
+
+	return func(name string) (*v1.Node, error) {
+		for i := range nodeList.Items {
+			if nodeList.Items[i].ObjectMeta.Name == name {
+				return &nodeList.Items[i], nil
+			}
+		}
+		return nil, apierrors.NewNotFound(v1.Resource("nodes"), name)
+	}, nil
+}
+
+// listTargetPods lists all pods of the namespaces of the targets, including the ones not selected as live owners of side objects
+func listTargetPods(ctx context.Context, clientset kubernetes.Interface, targets []RunnerTarget) ([]v1.Pod, error) {
+	namespaces := []string{}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		if target.Namespace == "" {
+			namespaces = []string{metav1.NamespaceAll}
+			break
+		}
+
+		if !seen[target.Namespace] {
+			seen[target.Namespace] = true
+			namespaces = append(namespaces, target.Namespace)
+		}
+	}
+
+	pods := []v1.Pod{}
+	for _, namespace := range namespaces {
+		podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, apiError(podResource, err)
+		}
+		pods = append(pods, podList.Items...)
+	}
+
+	return pods, nil
+}
+
+// evaluateGitlabExecutor deletes the pod by the first rule it exceeds, nodes is only used for the unreachable rule
+func evaluateGitlabExecutor(ctx context.Context, pod v1.Pod, rules ExecutorRules, nodes nodeGetter) (executorEvaluation, error) {
+	var status string
+	if rules.Gitlab != nil {
+		var err error
+		status, err = gitlabJobStatus(ctx, rules.Gitlab, pod)
+		if err != nil {
+			slog.Warn("falling back to age", "pod", pod.ObjectMeta.Name, "namespace", pod.ObjectMeta.Namespace, "error", err)
+		}
+
+		if isFinishedJob(status) {
+			return executorEvaluation{delete: true, rule: JobStatusRule, jobStatus: status}, nil
+		}
+	}
+
+	type candidate struct {
+		rule        string
+		maxAge, age int64
+	}
+	candidates := []candidate{}
+
+	if rules.MaxCompletedAge > 0 && isCompleted(pod) {
+		candidates = append(candidates, candidate{CompletedRule, rules.MaxCompletedAge, age(completedAt(pod))})
+	}
+
+	if rules.MaxUnreachableAge > 0 && nodes != nil && pod.Spec.NodeName != "" {
+		node, err := nodes(pod.Spec.NodeName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return executorEvaluation{}, err
+		}
+
+		// pods of deleted nodes are unreachable as well, the garbage collector of kubernetes takes care of them
+		if err == nil {
+			since, unreachable := nodeNotReadySince(node)
+			if unreachable {
+				candidates = append(candidates, candidate{UnreachableRule, rules.MaxUnreachableAge, age(since)})
+			}
+		}
+	}
+
+	if rules.MaxStuckAge > 0 && isStuck(pod) {
+		candidates = append(candidates, candidate{StuckRule, rules.MaxStuckAge, age(pod.ObjectMeta.CreationTimestamp)})
+	}
+
+	if rules.MaxAge > 0 && !isActiveJob(status) {
+		candidates = append(candidates, candidate{MaxAgeRule, rules.MaxAge, age(pod.ObjectMeta.CreationTimestamp)})
+	}
+
+	evaluation := executorEvaluation{jobStatus: status}
+	for _, c := range candidates {
+		expiresIn := c.maxAge - c.age
+		if expiresIn <= 0 {
+			force := c.rule == UnreachableRule && rules.ForceDeleteUnreachable
+			return executorEvaluation{delete: true, rule: c.rule, maxAge: c.maxAge, jobStatus: status, force: force}, nil
+		}
+
+		if !evaluation.expires || expiresIn < evaluation.expiresIn {
+			evaluation.expires = true
+			evaluation.expiresIn = expiresIn
+		}
+	}
+
+	return evaluation, nil
+}
+
+func isCompleted(pod v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// completedAt returns the termination time of the last container, pods without terminated containers count from their creation
+func completedAt(pod v1.Pod) metav1.Time {
+	finishedAt := pod.ObjectMeta.CreationTimestamp
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated != nil && terminated.FinishedAt.After(finishedAt.Time) {
+			finishedAt = terminated.FinishedAt
+		}
+	}
+	return finishedAt
+}
+
+// isStuck reports Pending pods with a container waiting for a reason in stuckReasons
+func isStuck(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodPending {
+		return false
+	}
+
+	statuses := append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && stuckReasons[status.State.Waiting.Reason] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nodeNotReadySince returns the time the Ready condition of the node turned False or Unknown
+func nodeNotReadySince(node *v1.Node) (metav1.Time, bool) {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != v1.NodeReady {
+			continue
+		}
+
+		if condition.Status == v1.ConditionTrue {
+			return metav1.Time{}, false
+		}
+
+		return condition.LastTransitionTime, true
+	}
+
+	return metav1.Time{}, false
+}
+
+// gitlabJobStatus looks up the job of an executor pod, the status is empty if the pod or gitlab doesn't know the job
+func gitlabJobStatus(ctx context.Context, gitlab GitlabAPI, pod v1.Pod) (string, error) {
+	project := gitlabRef(pod.ObjectMeta, runnerProjectIDKey)
+	job := gitlabRef(pod.ObjectMeta, runnerJobIDKey)
+	if project == "" || job == "" {
+		return "", nil
+	}
+
+	return gitlab.JobStatus(ctx, project, job)
+}
+
+func isFinishedJob(status string) bool {
+	return status == "success" || status == "failed" || status == "canceled"
+}
+
+func isActiveJob(status string) bool {
+	switch status {
+	case "created", "waiting_for_resource", "preparing", "pending", "running":
+		return true
+	}
+	return false
+}
+
+func deleteGitlabExecutor(ctx context.Context, client corev1.PodInterface, pod v1.Pod, evaluation executorEvaluation, events *Events, dryRun bool) error {
+	age := age(pod.ObjectMeta.CreationTimestamp)
+
+	attrs := []any{"pod", pod.ObjectMeta.Name, "namespace", pod.ObjectMeta.Namespace, "age", age, "maxAge", evaluation.maxAge, "rule", evaluation.rule}
+	if evaluation.jobStatus != "" {
+		attrs = append(attrs, "jobStatus", evaluation.jobStatus)
+	}
+	slog.Info("deleting pod", append(attrs, "dryRun", dryRun)...)
+	recordExecutorDeletion(events, &pod, evaluation, dryRun)
+
+	if dryRun {
+		observeDeletion(podResource, "", evaluation.rule, true)
+		return nil
+	}
+
+	err := client.Delete(ctx, pod.ObjectMeta.Name, executorDeleteOptions(evaluation.force))
+	if err != nil {
+		return apiError(podResource, err)
+	}
+
+	observeDeletion(podResource, "", evaluation.rule, false)
 	return nil
 }
 
+// executorDeleteOptions skips the grace period of force deleted pods on unreachable nodes, otherwise they stay
+// terminating until the kubelet confirms the deletion
+func executorDeleteOptions(force bool) metav1.DeleteOptions {
+	options := metav1.DeleteOptions{}
+	if force {
+		options.GracePeriodSeconds = new(int64)
+	}
+	return options
+}
+
 func isGitlabJobPod(labels map[string]string) bool {
 	v, ok := labels["app"]
 	if !ok {
@@ -1,6 +1,16 @@
 package gc
 
-import "testing"
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 func Test_isGitlabJobPod(t *testing.T) {
 	tests := []struct {
@@ -36,3 +46,233 @@ func Test_isGitlabJobPod(t *testing.T) {
 		})
 	}
 }
+
+func Test_evaluateGitlabExecutor(t *testing.T) {
+	server := newGitlabStandIn(t, &[]string{})
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	pod := func(job string, age time.Duration) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              "runner-abc-project-1-concurrent-0",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			Labels:            map[string]string{"app": "gitlab-ci-job"},
+			Annotations:       map[string]string{runnerProjectIDKey: "group/shop", runnerJobIDKey: job},
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		gitlabURL string
+		pod       v1.Pod
+		noMaxAge  bool
+		want      executorEvaluation
+	}{
+		{
+			name: "young pod without gitlab",
+			pod:  pod("2", time.Minute),
+			want: executorEvaluation{expires: true},
+		},
+		{
+			name: "old pod without gitlab",
+			pod:  pod("1", 2*time.Hour),
+			want: executorEvaluation{delete: true, rule: MaxAgeRule, maxAge: 60 * 60},
+		},
+		{
+			name:     "old pod with disabled max age",
+			pod:      pod("1", 2*time.Hour),
+			noMaxAge: true,
+			want:     executorEvaluation{},
+		},
+		{
+			name:      "canceled job",
+			gitlabURL: server.URL,
+			pod:       pod("2", time.Minute),
+			want:      executorEvaluation{delete: true, rule: JobStatusRule, jobStatus: "canceled"},
+		},
+		{
+			name:      "successful job",
+			gitlabURL: server.URL,
+			pod:       pod("3", time.Minute),
+			want:      executorEvaluation{delete: true, rule: JobStatusRule, jobStatus: "success"},
+		},
+		{
+			name:      "running job is spared",
+			gitlabURL: server.URL,
+			pod:       pod("1", 2*time.Hour),
+			want:      executorEvaluation{jobStatus: "running"},
+		},
+		{
+			name:      "unknown job falls back to age",
+			gitlabURL: server.URL,
+			pod:       pod("4", 2*time.Hour),
+			want:      executorEvaluation{delete: true, rule: MaxAgeRule, maxAge: 60 * 60},
+		},
+		{
+			name:      "gitlab unreachable falls back to age",
+			gitlabURL: unreachable.URL,
+			pod:       pod("2", time.Minute),
+			want:      executorEvaluation{expires: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := ExecutorRules{MaxAge: int64(60 * 60)}
+			if tt.noMaxAge {
+				rules.MaxAge = 0
+			}
+			if tt.gitlabURL != "" {
+				rules.Gitlab = NewGitlabClient(tt.gitlabURL, "secret", time.Second)
+			}
+
+			got, err := evaluateGitlabExecutor(context.TODO(), tt.pod, rules, nil)
+			if err != nil {
+				t.Fatalf("evaluateGitlabExecutor() error = %v", err)
+			}
+			if got.expires && got.expiresIn > 0 {
+				// the exact number of seconds depends on the runtime of the test
+				got.expiresIn = 0
+			}
+			if got != tt.want {
+				t.Errorf("evaluateGitlabExecutor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_evaluateGitlabExecutor_status(t *testing.T) {
+	now := time.Now()
+
+	node := func(name string, ready v1.ConditionStatus, since time.Duration) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+				Type:               v1.NodeReady,
+				Status:             ready,
+				LastTransitionTime: metav1.NewTime(now.Add(-since)),
+			}}},
+		}
+	}
+	nodes := map[string]*v1.Node{
+		"ready":       node("ready", v1.ConditionTrue, time.Hour),
+		"not-ready":   node("not-ready", v1.ConditionFalse, 20*time.Minute),
+		"unreachable": node("unreachable", v1.ConditionUnknown, time.Minute),
+	}
+	getNode := func(name string) (*v1.Node, error) {
+		n, ok := nodes[name]
+		if !ok {
+			return nil, apierrors.NewNotFound(v1.Resource("nodes"), name)
+		}
+		return n, nil
+	}
+
+	pod := func(age time.Duration, nodeName string, status v1.PodStatus) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "runner-abc-project-1-concurrent-0",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+				Labels:            map[string]string{"app": "gitlab-ci-job"},
+			},
+			Spec:   v1.PodSpec{NodeName: nodeName},
+			Status: status,
+		}
+	}
+	terminated := func(phase v1.PodPhase, finished time.Duration) v1.PodStatus {
+		return v1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{
+				Terminated: &v1.ContainerStateTerminated{FinishedAt: metav1.NewTime(now.Add(-finished))},
+			}}},
+		}
+	}
+	waiting := func(reason string) v1.PodStatus {
+		return v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{Reason: reason},
+			}}},
+		}
+	}
+	running := v1.PodStatus{Phase: v1.PodRunning}
+
+	rules := ExecutorRules{
+		MaxAge:            int64(60 * 60),
+		MaxCompletedAge:   int64(5 * 60),
+		MaxStuckAge:       int64(15 * 60),
+		MaxUnreachableAge: int64(10 * 60),
+	}
+
+	tests := []struct {
+		name     string
+		rules    ExecutorRules
+		pod      v1.Pod
+		wantRule string
+	}{
+		{"succeeded after grace", rules, pod(30*time.Minute, "ready", terminated(v1.PodSucceeded, 10*time.Minute)), CompletedRule},
+		{"failed within grace", rules, pod(30*time.Minute, "ready", terminated(v1.PodFailed, time.Minute)), ""},
+		{"completed rule disabled", ExecutorRules{MaxAge: rules.MaxAge}, pod(30*time.Minute, "ready", terminated(v1.PodSucceeded, 10*time.Minute)), ""},
+		{"image pull back off", rules, pod(20*time.Minute, "ready", waiting("ImagePullBackOff")), StuckRule},
+		{"create container config error", rules, pod(20*time.Minute, "ready", waiting("CreateContainerConfigError")), StuckRule},
+		{"stuck within timeout", rules, pod(10*time.Minute, "ready", waiting("ErrImagePull")), ""},
+		{"pending while creating containers", rules, pod(20*time.Minute, "ready", waiting("ContainerCreating")), ""},
+		{"not ready node", rules, pod(30*time.Minute, "not-ready", running), UnreachableRule},
+		{"unreachable node within timeout", rules, pod(30*time.Minute, "unreachable", running), ""},
+		{"deleted node", rules, pod(30*time.Minute, "deleted", running), ""},
+		{"running on ready node", rules, pod(30*time.Minute, "ready", running), ""},
+		{"max age still applies", rules, pod(2*time.Hour, "ready", running), MaxAgeRule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateGitlabExecutor(context.TODO(), tt.pod, tt.rules, getNode)
+			if err != nil {
+				t.Fatalf("evaluateGitlabExecutor() error = %v", err)
+			}
+			if got.delete != (tt.wantRule != "") || got.rule != tt.wantRule {
+				t.Errorf("evaluateGitlabExecutor() = %+v, want rule %v", got, tt.wantRule)
+			}
+			if !got.delete && !got.expires {
+				t.Errorf("evaluateGitlabExecutor() = %+v, want it to expire", got)
+			}
+			if got.force {
+				t.Errorf("evaluateGitlabExecutor() = %+v, force deletion is opt-in", got)
+			}
+		})
+	}
+
+	forced := rules
+	forced.ForceDeleteUnreachable = true
+	got, err := evaluateGitlabExecutor(context.TODO(), pod(30*time.Minute, "not-ready", running), forced, getNode)
+	if err != nil || got.rule != UnreachableRule || !got.force {
+		t.Errorf("evaluateGitlabExecutor() = %+v, %v, want a forced deletion by the unreachable rule", got, err)
+	}
+	if executorDeleteOptions(got.force).GracePeriodSeconds == nil {
+		t.Errorf("executorDeleteOptions() keeps the grace period of a forced deletion")
+	}
+
+	got, err = evaluateGitlabExecutor(context.TODO(), pod(20*time.Minute, "ready", waiting("ImagePullBackOff")), forced, getNode)
+	if err != nil || got.rule != StuckRule || got.force {
+		t.Errorf("evaluateGitlabExecutor() = %+v, %v, want only the unreachable rule to force the deletion", got, err)
+	}
+}
+
+func Test_evaluateGitlabExecutor_expiresByFirstRule(t *testing.T) {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "runner-abc-project-1-concurrent-0",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+			Labels:            map[string]string{"app": "gitlab-ci-job"},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"},
+		}}}},
+	}
+
+	got, err := evaluateGitlabExecutor(context.TODO(), pod, ExecutorRules{MaxAge: 60 * 60, MaxStuckAge: 15 * 60}, nil)
+	if err != nil {
+		t.Fatalf("evaluateGitlabExecutor() error = %v", err)
+	}
+	if !got.expires || got.expiresIn > 5*60 || got.expiresIn < 5*60-5 {
+		t.Errorf("evaluateGitlabExecutor() = %+v, want it to expire in 5 minutes", got)
+	}
+}
@@ -0,0 +1,296 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newGitlabStandIn serves the project "group/shop" with the branch "feature/upload", the merge requests !1 (opened) and !2 (merged)
+// the available environment "review/feature-upload" with id 7 and the jobs 1 (running), 2 (canceled) and 3 (success)
+// of the project "group/shop", stopped environment ids are recorded in stopped
+func newGitlabStandIn(t *testing.T, stopped *[]string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{project}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" || r.PathValue("project") != "group/shop" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"id": 42, "path_with_namespace": "group/shop"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/{project}/repository/branches/{branch}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PathValue("project") != "group/shop" || r.PathValue("branch") != "feature/upload" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"name": "feature/upload"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/{project}/merge_requests/{iid}", func(w http.ResponseWriter, r *http.Request) {
+		states := map[string]string{"1": "opened", "2": "merged"}
+		state, ok := states[r.PathValue("iid")]
+		if r.PathValue("project") != "group/shop" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"iid": %s, "state": "%s"}`, r.PathValue("iid"), state)
+	})
+
+	mux.HandleFunc("GET /api/v4/projects/{project}/environments", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("project") != "group/shop" || r.URL.Query().Get("states") != "available" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `[{"id": 6, "name": "production", "slug": "production"}, {"id": 7, "name": "review/feature-upload", "slug": "review-feature-upl-x1y2z3"}]`)
+	})
+	mux.HandleFunc("POST /api/v4/projects/{project}/environments/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "403 Forbidden"}`)
+			return
+		}
+		*stopped = append(*stopped, r.PathValue("id"))
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"state": "stopping"}`)
+	})
+
+	mux.HandleFunc("GET /api/v4/projects/{project}/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		statuses := map[string]string{"1": "running", "2": "canceled", "3": "success"}
+		status, ok := statuses[r.PathValue("id")]
+		if r.PathValue("project") != "group/shop" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"id": %s, "status": "%s"}`, r.PathValue("id"), status)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitlabClient_BranchExists(t *testing.T) {
+	server := newGitlabStandIn(t, &[]string{})
+
+	tests := []struct {
+		name    string
+		token   string
+		project string
+		branch  string
+		want    bool
+		wantErr bool
+	}{
+		{"exists", "secret", "group/shop", "feature/upload", true, false},
+		{"deleted", "secret", "group/shop", "feature/download", false, false},
+		{"unauthorized", "wrong", "group/shop", "feature/upload", false, true},
+		{"project not found", "secret", "group/shopp", "feature/upload", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewGitlabClient(server.URL+"/", tt.token, time.Second)
+
+			got, err := client.BranchExists(context.TODO(), tt.project, tt.branch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BranchExists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BranchExists() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabClient_MergeRequestOpen(t *testing.T) {
+	server := newGitlabStandIn(t, &[]string{})
+	client := NewGitlabClient(server.URL, "secret", time.Second)
+
+	tests := []struct {
+		name    string
+		project string
+		iid     string
+		want    bool
+		wantErr bool
+	}{
+		{"opened", "group/shop", "1", true, false},
+		{"merged", "group/shop", "2", false, false},
+		{"missing", "group/shop", "3", false, false},
+		{"project not found", "group/renamed", "1", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.MergeRequestOpen(context.TODO(), tt.project, tt.iid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeRequestOpen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MergeRequestOpen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabClient_StopEnvironment_forbidden(t *testing.T) {
+	stopped := []string{}
+	server := newGitlabStandIn(t, &stopped)
+	client := NewGitlabClient(server.URL, "read-only", time.Second)
+
+	_, err := client.StopEnvironment(context.TODO(), "group/shop", "review/feature-upload")
+	if err == nil || !strings.Contains(err.Error(), "the token needs the api scope") {
+		t.Errorf("StopEnvironment() error = %v, want it to name the api scope", err)
+	}
+	if len(stopped) != 0 {
+		t.Errorf("stopped environments = %v, want none", stopped)
+	}
+}
+
+func Test_evaluateNamespace_gitlab(t *testing.T) {
+	server := newGitlabStandIn(t, &[]string{})
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	namespace := func(annotations map[string]string) v1.Namespace {
+		return v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              "shop-ci-feature-upload",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute)),
+			Annotations:       annotations,
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		gitlabURL string
+		namespace v1.Namespace
+		want      bool
+	}{
+		{
+			name:      "branch exists",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabBranchKey: "feature/upload"}),
+			want:      false,
+		},
+		{
+			name:      "branch deleted",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabBranchKey: "feature/download"}),
+			want:      true,
+		},
+		{
+			name:      "merge request open",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabMergeRequestKey: "1"}),
+			want:      false,
+		},
+		{
+			name:      "merge request merged",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabMergeRequestKey: "2"}),
+			want:      true,
+		},
+		{
+			name:      "gitlab unreachable falls back to age",
+			gitlabURL: unreachable.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabBranchKey: "feature/download"}),
+			want:      false,
+		},
+		{
+			name:      "project not visible to the token falls back to age",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shopp", GitlabBranchKey: "feature/upload"}),
+			want:      false,
+		},
+		{
+			name:      "opted out",
+			gitlabURL: server.URL,
+			namespace: namespace(map[string]string{GitlabProjectKey: "group/shop", GitlabBranchKey: "feature/download", "disable-automatic-garbage-collection": "true"}),
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := NamespaceRules{
+				AgeSources:        builtinAgeSources("namespace"),
+				OptOutAnnotations: []string{"disable-automatic-garbage-collection"},
+				MaxReviewAge:      int64(time.Hour.Seconds()),
+				Gitlab:            NewGitlabClient(tt.gitlabURL, "secret", time.Second),
+			}
+
+			got, err := shouldDeleteNamespace(context.TODO(), &KubernetesAPIMock{namespace: tt.namespace}, rules, false)
+			if err != nil {
+				t.Fatalf("shouldDeleteNamespace() error = %v", err)
+			}
+			if got.Delete != tt.want {
+				t.Errorf("shouldDeleteNamespace() = %v, want %v", got.Delete, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deleteNamespace_stopEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		dryRun      bool
+		wantStopped []string
+	}{
+		{
+			name:        "stop by slug",
+			annotations: map[string]string{GitlabProjectKey: "group/shop", GitlabEnvironmentAnnotation: "review-feature-upl-x1y2z3"},
+			wantStopped: []string{"7"},
+		},
+		{
+			name:        "stop by name",
+			annotations: map[string]string{GitlabProjectKey: "group/shop", GitlabEnvironmentAnnotation: "review/feature-upload"},
+			wantStopped: []string{"7"},
+		},
+		{
+			name:        "environment not available",
+			annotations: map[string]string{GitlabProjectKey: "group/shop", GitlabEnvironmentAnnotation: "review-feature-download"},
+		},
+		{
+			name:        "project missing",
+			annotations: map[string]string{GitlabEnvironmentAnnotation: "review-feature-upl-x1y2z3"},
+		},
+		{
+			name:        "dry run",
+			annotations: map[string]string{GitlabProjectKey: "group/shop", GitlabEnvironmentAnnotation: "review-feature-upl-x1y2z3"},
+			dryRun:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopped := []string{}
+			server := newGitlabStandIn(t, &stopped)
+
+			api := &KubernetesAPIMock{namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "shop-ci-feature-upload",
+				Annotations: tt.annotations,
+			}}}
+			rules := NamespaceRules{
+				Gitlab:                    NewGitlabClient(server.URL, "secret", time.Second),
+				StopEnvironmentAnnotation: GitlabEnvironmentAnnotation,
+			}
+
+			err := deleteNamespace(context.TODO(), api, rules, Decision{Delete: true}, tt.dryRun)
+			if err != nil {
+				t.Fatalf("deleteNamespace() error = %v", err)
+			}
+			if api.namespaceDeleted == tt.dryRun {
+				t.Errorf("namespaceDeleted = %v, want %v", api.namespaceDeleted, !tt.dryRun)
+			}
+			if fmt.Sprint(stopped) != fmt.Sprint(tt.wantStopped) {
+				t.Errorf("stopped environments = %v, want %v", stopped, tt.wantStopped)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotations recording the state of hibernated workloads, wake restores it
+const (
+	HibernatedReplicasAnnotation = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/hibernated-replicas"
+	HibernatedSuspendAnnotation  = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/hibernated-suspend"
+)
+
+// WokenAtAnnotation is owned by the gc, it holds the unix time a namespace was woken, the namespace isn't idle before HibernateAfter passed since
+const WokenAtAnnotation = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/woken-at"
+
+// idleAge is the age of the namespace, the time since it was woken or the time since the heartbeat in the last-used-at annotation,
+// whichever is younger
+func (r NamespaceRules) idleAge(annotations map[string]string, age int64, now time.Time) int64 {
+	idle := age
+
+	wokenAt, err := strconv.ParseInt(annotations[WokenAtAnnotation], 10, 64)
+	if err == nil {
+		idle = min(idle, now.Unix()-wokenAt)
+	}
+
+	value, ok := annotations[r.LastUsedAtAnnotation]
+	if r.LastUsedAtAnnotation != "" && ok {
+		lastUsedAt, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			idle = min(idle, int64(now.Sub(lastUsedAt).Seconds()))
+		}
+	}
+
+	return max(0, idle)
+}
+
+// hibernate scales the workloads of an idle namespace to zero and records an event if any workload was hibernated,
+// like deletions dry run policies only log it
+func hibernate(ctx context.Context, api KubernetesAPI, rules NamespaceRules, decision Decision, dryRun bool) error {
+	if dryRun || decision.DryRun {
+		return nil
+	}
+
+	ns := api.Namespace()
+	updated, err := hibernateNamespace(ctx, api)
+	if updated > 0 {
+		slog.Info("hibernated namespace", "namespace", ns.ObjectMeta.Name, "workloads", updated)
+		rules.Events.namespace(ns, v1.EventTypeNormal, HibernatedEventReason, "scaled %d workloads to zero after %s of idleness, run \"k8s-gitlab-gc wake %s\" to restore them",
+			updated, time.Duration(rules.HibernateAfter)*time.Second, ns.ObjectMeta.Name)
+	}
+	return err
+}
+
+// hibernateNamespace scales all deployments and stateful sets to zero and suspends all cron jobs, the original replicas and suspend
+// values are recorded in annotations, workloads which are hibernated already are skipped, it returns the number of updated workloads
+func hibernateNamespace(ctx context.Context, api KubernetesAPI) (int, error) {
+	updated := 0
+
+	deployments, err := api.Deployments(ctx)
+	if err != nil {
+		return updated, err
+	}
+	for i := range deployments {
+		// listers share their objects, never modify them in place
+		deployment := deployments[i].DeepCopy()
+		if !hibernateReplicas(&deployment.ObjectMeta, &deployment.Spec.Replicas) {
+			continue
+		}
+		err := api.UpdateDeployment(ctx, deployment)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	statefulSets, err := api.StatefulSets(ctx)
+	if err != nil {
+		return updated, err
+	}
+	for i := range statefulSets {
+		statefulSet := statefulSets[i].DeepCopy()
+		if !hibernateReplicas(&statefulSet.ObjectMeta, &statefulSet.Spec.Replicas) {
+			continue
+		}
+		err := api.UpdateStatefulSet(ctx, statefulSet)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	cronJobs, err := api.CronJobs(ctx)
+	if err != nil {
+		return updated, err
+	}
+	for i := range cronJobs {
+		cronJob := cronJobs[i].DeepCopy()
+		suspended := cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend
+		if suspended {
+			continue
+		}
+		setAnnotation(&cronJob.ObjectMeta, HibernatedSuspendAnnotation, strconv.FormatBool(suspended))
+		cronJob.Spec.Suspend = ptr(true)
+		err := api.UpdateCronJob(ctx, cronJob)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// hibernateReplicas scales to zero and records the replicas, an already recorded value is kept if someone scaled up in between
+func hibernateReplicas(meta *metav1.ObjectMeta, replicas **int32) bool {
+	current := int32(1)
+	if *replicas != nil {
+		current = **replicas
+	}
+	if current == 0 {
+		return false
+	}
+
+	if _, recorded := meta.Annotations[HibernatedReplicasAnnotation]; !recorded {
+		setAnnotation(meta, HibernatedReplicasAnnotation, strconv.FormatInt(int64(current), 10))
+	}
+	*replicas = ptr(int32(0))
+	return true
+}
+
+// WakeNamespace restores the replicas and suspend values recorded by the hibernation, records the time in WokenAtAnnotation,
+// so the namespace isn't hibernated again before it was idle for HibernateAfter, and bumps the last-used-at annotation
+func WakeNamespace(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, name string, dryRun bool) (int, error) {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, apiError(namespaceResource, err)
+	}
+
+	api := NewKubernetesClient(clientset, *ns)
+	woken := 0
+
+	deployments, err := api.Deployments(ctx)
+	if err != nil {
+		return woken, err
+	}
+	for i := range deployments {
+		deployment := &deployments[i]
+		ok, err := wakeReplicas(&deployment.ObjectMeta, &deployment.Spec.Replicas)
+		if err != nil {
+			return woken, fmt.Errorf("deployment %s: %v", deployment.ObjectMeta.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		slog.Info("waking deployment", "namespace", name, "deployment", deployment.ObjectMeta.Name, "replicas", *deployment.Spec.Replicas, "dryRun", dryRun)
+		if !dryRun {
+			err := api.UpdateDeployment(ctx, deployment)
+			if err != nil {
+				return woken, err
+			}
+		}
+		woken++
+	}
+
+	statefulSets, err := api.StatefulSets(ctx)
+	if err != nil {
+		return woken, err
+	}
+	for i := range statefulSets {
+		statefulSet := &statefulSets[i]
+		ok, err := wakeReplicas(&statefulSet.ObjectMeta, &statefulSet.Spec.Replicas)
+		if err != nil {
+			return woken, fmt.Errorf("statefulset %s: %v", statefulSet.ObjectMeta.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		slog.Info("waking statefulset", "namespace", name, "statefulset", statefulSet.ObjectMeta.Name, "replicas", *statefulSet.Spec.Replicas, "dryRun", dryRun)
+		if !dryRun {
+			err := api.UpdateStatefulSet(ctx, statefulSet)
+			if err != nil {
+				return woken, err
+			}
+		}
+		woken++
+	}
+
+	cronJobs, err := api.CronJobs(ctx)
+	if err != nil {
+		return woken, err
+	}
+	for i := range cronJobs {
+		cronJob := &cronJobs[i]
+		value, ok := cronJob.ObjectMeta.Annotations[HibernatedSuspendAnnotation]
+		if !ok {
+			continue
+		}
+		suspend, err := strconv.ParseBool(value)
+		if err != nil {
+			return woken, fmt.Errorf("cronjob %s: annotation %s is not a bool: \"%s\"", cronJob.ObjectMeta.Name, HibernatedSuspendAnnotation, value)
+		}
+		delete(cronJob.ObjectMeta.Annotations, HibernatedSuspendAnnotation)
+		cronJob.Spec.Suspend = ptr(suspend)
+		slog.Info("waking cronjob", "namespace", name, "cronjob", cronJob.ObjectMeta.Name, "suspend", suspend, "dryRun", dryRun)
+		if !dryRun {
+			err := api.UpdateCronJob(ctx, cronJob)
+			if err != nil {
+				return woken, err
+			}
+		}
+		woken++
+	}
+
+	if !dryRun {
+		now := time.Now()
+		annotations := map[string]any{WokenAtAnnotation: strconv.FormatInt(now.Unix(), 10)}
+		if rules.LastUsedAtAnnotation != "" {
+			annotations[rules.LastUsedAtAnnotation] = now.UTC().Format(time.RFC3339)
+		}
+		err := patchNamespaceMetadata(ctx, clientset, name, "annotations", annotations)
+		if err != nil {
+			return woken, err
+		}
+	}
+
+	return woken, nil
+}
+
+// wakeReplicas restores the recorded replicas and removes the annotation, it returns false if nothing was recorded
+func wakeReplicas(meta *metav1.ObjectMeta, replicas **int32) (bool, error) {
+	value, ok := meta.Annotations[HibernatedReplicasAnnotation]
+	if !ok {
+		return false, nil
+	}
+
+	recorded, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return false, fmt.Errorf("annotation %s is not a number: \"%s\"", HibernatedReplicasAnnotation, value)
+	}
+
+	delete(meta.Annotations, HibernatedReplicasAnnotation)
+	*replicas = ptr(int32(recorded))
+	return true, nil
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
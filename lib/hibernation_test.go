@@ -0,0 +1,229 @@
+package gc
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceRules_idleAge(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rules := NamespaceRules{LastUsedAtAnnotation: "last-used-at"}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int64
+	}{
+		{"no heartbeat", nil, 7200},
+		{"recent heartbeat", map[string]string{"last-used-at": "2026-10-17T11:50:00Z"}, 600},
+		{"heartbeat before the youngest resource", map[string]string{"last-used-at": "2026-10-17T08:00:00Z"}, 7200},
+		{"heartbeat in the future", map[string]string{"last-used-at": "2026-10-17T13:00:00Z"}, 0},
+		{"invalid heartbeat", map[string]string{"last-used-at": "recently"}, 7200},
+		{"woken", map[string]string{WokenAtAnnotation: strconv.FormatInt(now.Add(-5*time.Minute).Unix(), 10)}, 300},
+		{"woken before the heartbeat", map[string]string{WokenAtAnnotation: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), "last-used-at": "2026-10-17T11:50:00Z"}, 600},
+		{"invalid wake", map[string]string{WokenAtAnnotation: "recently"}, 7200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.idleAge(tt.annotations, 7200, now); got != tt.want {
+				t.Errorf("idleAge() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHibernateAndWakeNamespace(t *testing.T) {
+	ctx := context.Background()
+	name := "shop-ci-feature"
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	three := int32(3)
+	zero := int32(0)
+	suspended := true
+
+	clientset := fake.NewClientset(
+		ns.DeepCopy(),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "web"}, Spec: appsv1.DeploymentSpec{Replicas: &three}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "worker"}, Spec: appsv1.DeploymentSpec{Replicas: &zero}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "db"}},
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "import"}},
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "export"}, Spec: batchv1.CronJobSpec{Suspend: &suspended}},
+	)
+	api := NewKubernetesClient(clientset, ns)
+
+	updated, err := hibernateNamespace(ctx, api)
+	if err != nil {
+		t.Fatalf("hibernateNamespace() error = %v", err)
+	}
+	if updated != 3 {
+		t.Errorf("hibernateNamespace() = %d, want 3", updated)
+	}
+
+	want := map[string]string{
+		"deployment/web":    "replicas 0, recorded 3",
+		"deployment/worker": "replicas 0, recorded ",
+		"statefulset/db":    "replicas 0, recorded 1",
+		"cronjob/import":    "suspend true, recorded false",
+		"cronjob/export":    "suspend true, recorded ",
+	}
+	if got := workloadStates(t, ctx, api); !reflect.DeepEqual(got, want) {
+		t.Errorf("hibernated workloads = %v, want %v", got, want)
+	}
+
+	updated, err = hibernateNamespace(ctx, api)
+	if err != nil || updated != 0 {
+		t.Errorf("hibernateNamespace() again = %d, %v, want 0", updated, err)
+	}
+
+	rules := NamespaceRules{LastUsedAtAnnotation: "last-used-at"}
+	woken, err := WakeNamespace(ctx, clientset, rules, name, false)
+	if err != nil {
+		t.Fatalf("WakeNamespace() error = %v", err)
+	}
+	if woken != 3 {
+		t.Errorf("WakeNamespace() = %d, want 3", woken)
+	}
+
+	want = map[string]string{
+		"deployment/web":    "replicas 3, recorded ",
+		"deployment/worker": "replicas 0, recorded ",
+		"statefulset/db":    "replicas 1, recorded ",
+		"cronjob/import":    "suspend false, recorded ",
+		"cronjob/export":    "suspend true, recorded ",
+	}
+	if got := workloadStates(t, ctx, api); !reflect.DeepEqual(got, want) {
+		t.Errorf("woken workloads = %v, want %v", got, want)
+	}
+
+	got, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.ObjectMeta.Annotations["last-used-at"]; !ok {
+		t.Errorf("WakeNamespace() didn't set the last-used-at annotation")
+	}
+}
+
+func Test_hibernate_dryRun(t *testing.T) {
+	ctx := context.Background()
+	name := "shop-ci-feature"
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	three := int32(3)
+
+	tests := []struct {
+		name     string
+		decision Decision
+		dryRun   bool
+		want     string
+	}{
+		{name: "hibernates", want: "replicas 0, recorded 3"},
+		{name: "dry run", dryRun: true, want: "replicas 3, recorded "},
+		{name: "dry run policy", decision: Decision{DryRun: true}, want: "replicas 3, recorded "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset(
+				ns.DeepCopy(),
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: "web"}, Spec: appsv1.DeploymentSpec{Replicas: &three}},
+			)
+			api := NewKubernetesClient(clientset, ns)
+
+			err := hibernate(ctx, api, NamespaceRules{}, tt.decision, tt.dryRun)
+			if err != nil {
+				t.Fatalf("hibernate() error = %v", err)
+			}
+
+			if got := workloadStates(t, ctx, api)["deployment/web"]; got != tt.want {
+				t.Errorf("deployment/web = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func workloadStates(t *testing.T, ctx context.Context, api KubernetesAPI) map[string]string {
+	t.Helper()
+	states := map[string]string{}
+
+	deployments, err := api.Deployments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deployments {
+		states["deployment/"+d.ObjectMeta.Name] = "replicas " + formatReplicas(d.Spec.Replicas) + ", recorded " + d.ObjectMeta.Annotations[HibernatedReplicasAnnotation]
+	}
+
+	statefulSets, err := api.StatefulSets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statefulSets {
+		states["statefulset/"+s.ObjectMeta.Name] = "replicas " + formatReplicas(s.Spec.Replicas) + ", recorded " + s.ObjectMeta.Annotations[HibernatedReplicasAnnotation]
+	}
+
+	cronJobs, err := api.CronJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cronJobs {
+		suspend := "false"
+		if c.Spec.Suspend != nil && *c.Spec.Suspend {
+			suspend = "true"
+		}
+		states["cronjob/"+c.ObjectMeta.Name] = "suspend " + suspend + ", recorded " + c.ObjectMeta.Annotations[HibernatedSuspendAnnotation]
+	}
+
+	return states
+}
+
+func formatReplicas(replicas *int32) string {
+	if replicas == nil {
+		return "nil"
+	}
+	return strconv.Itoa(int(*replicas))
+}
+
+// TestWakeNamespace_withoutHeartbeat wakes a namespace without last-used-at annotation, the next evaluation must not hibernate it again
+func TestWakeNamespace_withoutHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	name := "shop-ci-feature"
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-10 * time.Hour)),
+	}})
+	rules := NamespaceRules{
+		AgeSources:     builtinAgeSources("namespace"),
+		MaxTestingAge:  int64(60 * 60),
+		MaxReviewAge:   int64(60 * 60 * 48),
+		HibernateAfter: int64(60 * 60),
+	}
+
+	evaluate := func() Decision {
+		t.Helper()
+		decision, err := ExplainNamespace(ctx, clientset, rules, name)
+		if err != nil {
+			t.Fatalf("ExplainNamespace() error = %v", err)
+		}
+		return decision
+	}
+
+	if decision := evaluate(); !decision.Hibernate {
+		t.Fatalf("evaluation before the wake = %s, want the idle namespace to be hibernated", decision.Reason)
+	}
+
+	_, err := WakeNamespace(ctx, clientset, rules, name, false)
+	if err != nil {
+		t.Fatalf("WakeNamespace() error = %v", err)
+	}
+
+	if decision := evaluate(); decision.Hibernate || decision.Reason != reasonTooYoung {
+		t.Errorf("evaluation after the wake = %s (hibernate: %v), want %s", decision.Reason, decision.Hibernate, reasonTooYoung)
+	}
+}
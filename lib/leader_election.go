@@ -0,0 +1,86 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// ErrLeadershipLost is returned by RunAsLeader when the lease could not be renewed in time
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// LeaderElectionConfig configures the Lease used to elect the single replica which is allowed to delete resources
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// RunAsLeader blocks until this replica acquired the lease and runs fn until the context is canceled or the lease is lost.
+// Standbys keep retrying to acquire the lease and take over once the leader stops renewing it.
+func RunAsLeader(ctx context.Context, clientset kubernetes.Interface, config LeaderElectionConfig, fn func(ctx context.Context)) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.LeaseNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+
+	var started atomic.Bool
+	done := make(chan struct{})
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            config.LeaseName,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				started.Store(true)
+				defer close(done)
+				slog.Info("acquired lease", "lease", config.LeaseNamespace+"/"+config.LeaseName, "identity", config.Identity)
+				fn(ctx)
+			},
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				if identity == config.Identity {
+					return
+				}
+				slog.Info("waiting for lease", "lease", config.LeaseNamespace+"/"+config.LeaseName, "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election configuration: %v", err)
+	}
+
+	elector.Run(ctx)
+
+	// fn runs in its own goroutine, wait for it to return before giving up the process
+	if started.Load() {
+		<-done
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return ErrLeadershipLost
+}
@@ -0,0 +1,83 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testLeaderElectionConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		LeaseName:      "k8s-gitlab-gc",
+		LeaseNamespace: "gitlab-gc",
+		Identity:       identity,
+		LeaseDuration:  15 * time.Second,
+		RenewDeadline:  10 * time.Second,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientset := fake.NewClientset()
+
+	called := false
+	err := RunAsLeader(ctx, clientset, testLeaderElectionConfig("replica-1"), func(ctx context.Context) {
+		called = true
+
+		lease, err := clientset.CoordinationV1().Leases("gitlab-gc").Get(ctx, "k8s-gitlab-gc", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("failed to get lease: %v", err)
+		} else if *lease.Spec.HolderIdentity != "replica-1" {
+			t.Errorf("lease holder = %v, want %v", *lease.Spec.HolderIdentity, "replica-1")
+		}
+
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("RunAsLeader() error = %v", err)
+	}
+	if !called {
+		t.Errorf("RunAsLeader() did not run as leader")
+	}
+}
+
+func TestRunAsLeader_standby(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	holder := "replica-1"
+	leaseDuration := int32(15)
+	clientset := fake.NewClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-gitlab-gc", Namespace: "gitlab-gc"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &leaseDuration,
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	})
+
+	err := RunAsLeader(ctx, clientset, testLeaderElectionConfig("replica-2"), func(ctx context.Context) {
+		t.Errorf("standby must not run while the lease is held by another replica")
+	})
+	if err != nil {
+		t.Fatalf("RunAsLeader() error = %v", err)
+	}
+}
+
+func TestRunAsLeader_invalidConfig(t *testing.T) {
+	config := testLeaderElectionConfig("replica-1")
+	config.RenewDeadline = config.LeaseDuration
+
+	err := RunAsLeader(context.Background(), fake.NewClientset(), config, func(ctx context.Context) {})
+	if err == nil {
+		t.Errorf("RunAsLeader() expected error for renew deadline not shorter than lease duration")
+	}
+}
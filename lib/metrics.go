@@ -0,0 +1,102 @@
+package gc
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const metricsNamespace = "k8s_gitlab_gc"
+
+// resources the metrics are labeled with
+const (
+	namespaceResource = "namespaces"
+	podResource       = "pods"
+)
+
+// MetricsRegistry holds all metrics of the gc, main serves it on /metrics or pushes it to a pushgateway
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	evaluatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluated_total",
+		Help:      "Number of evaluated namespaces and gitlab executor pods.",
+	}, []string{"resource"})
+
+	deletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deleted_total",
+		Help:      "Number of deleted resources by the project parsed from the namespace name and the reason or rule they were deleted for, dry runs are counted separately.",
+	}, []string{"resource", "project", "reason", "dry_run"})
+
+	skippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "skipped_total",
+		Help:      "Number of evaluated resources which were kept by the project parsed from the namespace name and the reason they were kept for.",
+	}, []string{"resource", "project", "reason"})
+
+	apiErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed kubernetes and gitlab api requests by resource type.",
+	}, []string{"resource"})
+
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of oneshot runs and controller syncs by task.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"task"})
+
+	oldestNamespaceAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "oldest_ci_namespace_age_seconds",
+		Help:      "Age of the oldest ci namespace kept because it didn't reach its max age yet.",
+	})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		evaluatedTotal,
+		deletedTotal,
+		skippedTotal,
+		apiErrorsTotal,
+		runDuration,
+		oldestNamespaceAge,
+	)
+}
+
+// apiError counts failed requests, missing resources are not counted as they are expected during garbage collection
+func apiError(resource string, err error) error {
+	if err != nil && !apierrors.IsNotFound(err) {
+		apiErrorsTotal.WithLabelValues(resource).Inc()
+	}
+	return err
+}
+
+// observeDuration records the time since start, use it like defer observeDuration("namespaces", time.Now())
+func observeDuration(task string, start time.Time) {
+	runDuration.WithLabelValues(task).Observe(time.Since(start).Seconds())
+}
+
+// observeEvaluation counts an evaluated resource, project is the one parsed from the namespace name, it is empty for pods and
+// unparsed names, branch, pipeline and sha aren't labels as every pipeline would add a series
+func observeEvaluation(resource, project string, deleted bool, reason string) {
+	evaluatedTotal.WithLabelValues(resource).Inc()
+	if !deleted {
+		skippedTotal.WithLabelValues(resource, project, reason).Inc()
+	}
+}
+
+func observeDeletion(resource, project, reason string, dryRun bool) {
+	dryRunLabel := "false"
+	if dryRun {
+		dryRunLabel = "true"
+	}
+	deletedTotal.WithLabelValues(resource, project, reason, dryRunLabel).Inc()
+}
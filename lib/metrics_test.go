@@ -0,0 +1,71 @@
+package gc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_apiError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want float64
+	}{
+		{
+			name: "no error",
+			err:  nil,
+			want: 0,
+		},
+		{
+			name: "not found",
+			err:  apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "gone"),
+			want: 0,
+		},
+		{
+			name: "failed request",
+			err:  errors.New("connection refused"),
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := apiErrorsTotal.WithLabelValues("test-" + tt.name)
+
+			err := apiError("test-"+tt.name, tt.err)
+			if err != tt.err {
+				t.Errorf("apiError() = %v, want %v", err, tt.err)
+			}
+
+			got := testutil.ToFloat64(counter)
+			if got != tt.want {
+				t.Errorf("api_errors_total = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_observeEvaluation(t *testing.T) {
+	observeEvaluation("test-evaluation", "shop", false, reasonTooYoung)
+	observeEvaluation("test-evaluation", "shop", true, reasonExpired)
+	observeDeletion("test-evaluation", "shop", reasonExpired, true)
+
+	if got := testutil.ToFloat64(evaluatedTotal.WithLabelValues("test-evaluation")); got != 2 {
+		t.Errorf("evaluated_total = %v, want 2", got)
+	}
+
+	if got := testutil.ToFloat64(skippedTotal.WithLabelValues("test-evaluation", "shop", reasonTooYoung)); got != 1 {
+		t.Errorf("skipped_total = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(deletedTotal.WithLabelValues("test-evaluation", "shop", reasonExpired, "true")); got != 1 {
+		t.Errorf("deleted_total = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(deletedTotal.WithLabelValues("test-evaluation", "shop", reasonExpired, "false")); got != 0 {
+		t.Errorf("deleted_total of real deletions = %v, want 0", got)
+	}
+}
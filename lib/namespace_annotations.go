@@ -0,0 +1,112 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotations written onto evaluated ci namespaces
+const (
+	ExpiresAtAnnotation     = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/expires-at"
+	GCClassAnnotation       = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/gc-class"
+	LastEvaluatedAnnotation = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/last-evaluated"
+)
+
+// evaluationAnnotations are written by the gc on every evaluation, they don't change the decision about a namespace
+var evaluationAnnotations = []string{ExpiresAtAnnotation, GCClassAnnotation, LastEvaluatedAnnotation}
+
+// lastEvaluatedInterval limits how often last-evaluated is refreshed if nothing else changed
+const lastEvaluatedInterval = time.Minute
+
+// expiresAtTolerance ignores the rounding of ages to seconds, a namespace evaluated twice within the same second may expire a second apart
+const expiresAtTolerance = 2 * time.Second
+
+// annotateNamespace writes the expiry and class of a kept ci namespace onto it, the namespace is only patched if an annotation changed
+func annotateNamespace(ctx context.Context, clientset kubernetes.Interface, ns v1.Namespace, decision Decision, now time.Time) error {
+	if decision.Delete || decision.Reason == reasonNotCI || decision.Reason == reasonSystem || decision.Reason == reasonTerminating {
+		return nil
+	}
+
+	changes := annotationChanges(ns.ObjectMeta.Annotations, decision, now)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	err := patchNamespaceMetadata(ctx, clientset, ns.ObjectMeta.Name, "annotations", changes)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("annotated namespace", "namespace", ns.ObjectMeta.Name, "annotations", changes)
+	return nil
+}
+
+// patchNamespaceMetadata merges the changes into the "annotations" or "labels" of a namespace, nil values remove keys
+func patchNamespaceMetadata(ctx context.Context, clientset kubernetes.Interface, name, field string, changes map[string]any) error {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{field: changes}})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.CoreV1().Namespaces().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return apiError(namespaceResource, err)
+	}
+
+	return nil
+}
+
+// annotationChanges returns the annotations to set and the ones to remove as nil, it is empty if the annotations are up to date
+func annotationChanges(annotations map[string]string, decision Decision, now time.Time) map[string]any {
+	changes := map[string]any{}
+
+	expiresAt := ""
+	if decision.Expires {
+		expiresAt = now.Add(time.Duration(decision.ExpiresIn) * time.Second).UTC().Truncate(time.Second).Format(time.RFC3339)
+	}
+	if !sameExpiry(annotations[ExpiresAtAnnotation], expiresAt) {
+		changes[ExpiresAtAnnotation] = nilIfEmpty(expiresAt)
+	}
+
+	if annotations[GCClassAnnotation] != decision.Class {
+		changes[GCClassAnnotation] = nilIfEmpty(decision.Class)
+	}
+
+	lastEvaluated, err := time.Parse(time.RFC3339, annotations[LastEvaluatedAnnotation])
+	if len(changes) == 0 && err == nil && now.Sub(lastEvaluated) < lastEvaluatedInterval {
+		return nil
+	}
+
+	changes[LastEvaluatedAnnotation] = now.UTC().Truncate(time.Second).Format(time.RFC3339)
+	return changes
+}
+
+func sameExpiry(current, expiresAt string) bool {
+	if current == "" || expiresAt == "" {
+		return current == expiresAt
+	}
+
+	currentTime, err := time.Parse(time.RFC3339, current)
+	if err != nil {
+		return false
+	}
+
+	expiresAtTime, _ := time.Parse(time.RFC3339, expiresAt)
+	difference := currentTime.Sub(expiresAtTime)
+	return difference <= expiresAtTolerance && difference >= -expiresAtTolerance
+}
+
+// nilIfEmpty removes an annotation by a merge patch
+func nilIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,165 @@
+package gc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_annotationChanges(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tooYoung := Decision{Reason: reasonTooYoung, Class: "review", Expires: true, ExpiresIn: 3600}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		decision    Decision
+		want        map[string]any
+	}{
+		{
+			name:     "not annotated yet",
+			decision: tooYoung,
+			want: map[string]any{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T12:00:00Z",
+			},
+		},
+		{
+			name: "up to date",
+			annotations: map[string]string{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:01Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T11:59:30Z",
+			},
+			decision: tooYoung,
+		},
+		{
+			name: "last evaluation outdated",
+			annotations: map[string]string{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T11:50:00Z",
+			},
+			decision: tooYoung,
+			want:     map[string]any{LastEvaluatedAnnotation: "2026-10-17T12:00:00Z"},
+		},
+		{
+			name: "ttl annotation extended expiry",
+			annotations: map[string]string{
+				ExpiresAtAnnotation:     "2026-10-17T12:30:00Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T11:59:30Z",
+			},
+			decision: tooYoung,
+			want: map[string]any{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				LastEvaluatedAnnotation: "2026-10-17T12:00:00Z",
+			},
+		},
+		{
+			name: "opted out",
+			annotations: map[string]string{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T11:59:30Z",
+			},
+			decision: Decision{Reason: reasonOptedOut, Class: "review"},
+			want: map[string]any{
+				ExpiresAtAnnotation:     nil,
+				LastEvaluatedAnnotation: "2026-10-17T12:00:00Z",
+			},
+		},
+		{
+			name: "invalid expiry",
+			annotations: map[string]string{
+				ExpiresAtAnnotation:     "tomorrow",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T11:59:30Z",
+			},
+			decision: tooYoung,
+			want: map[string]any{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				LastEvaluatedAnnotation: "2026-10-17T12:00:00Z",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := annotationChanges(tt.annotations, tt.decision, now)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("annotationChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_annotateNamespace(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}}
+
+	tests := []struct {
+		name      string
+		decision  Decision
+		wantPatch bool
+	}{
+		{
+			name:      "kept",
+			decision:  Decision{Reason: reasonTooYoung, Class: "review", Expires: true, ExpiresIn: 3600},
+			wantPatch: true,
+		},
+		{
+			name:     "deleted",
+			decision: Decision{Delete: true, Reason: reasonExpired, Class: "review"},
+		},
+		{
+			name:     "not a ci namespace",
+			decision: Decision{Reason: reasonNotCI},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset(ns.DeepCopy())
+
+			err := annotateNamespace(context.Background(), clientset, ns, tt.decision, now)
+			if err != nil {
+				t.Fatalf("annotateNamespace() error = %v", err)
+			}
+
+			patched := false
+			for _, action := range clientset.Actions() {
+				if _, ok := action.(k8stesting.PatchAction); ok {
+					patched = true
+				}
+			}
+			if patched != tt.wantPatch {
+				t.Fatalf("patched = %v, want %v", patched, tt.wantPatch)
+			}
+			if !tt.wantPatch {
+				return
+			}
+
+			got, err := clientset.CoreV1().Namespaces().Get(context.Background(), ns.ObjectMeta.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				ExpiresAtAnnotation:     "2026-10-17T13:00:00Z",
+				GCClassAnnotation:       "review",
+				LastEvaluatedAnnotation: "2026-10-17T12:00:00Z",
+			}
+			if !reflect.DeepEqual(got.ObjectMeta.Annotations, want) {
+				t.Errorf("annotations = %v, want %v", got.ObjectMeta.Annotations, want)
+			}
+		})
+	}
+}
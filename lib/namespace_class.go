@@ -0,0 +1,98 @@
+package gc
+
+import (
+	"fmt"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	BuildNamespaceClass  = "build"
+	ReviewNamespaceClass = "review"
+)
+
+var hashRegex = regexp.MustCompile("[0-9a-fA-F]{15,}$")
+
+// ciRegex matches names tagged by "ci", e.g. "ci-project", "project-ci-branch" or "project-ci"
+var ciRegex = regexp.MustCompile("^ci-|-ci-|-ci$")
+
+// buildRegex matches ci tagged names ending in a commit hash
+var buildRegex = regexp.MustCompile("(^ci-|-ci-).*" + hashRegex.String())
+
+// NamespaceClass assigns a max age to the namespaces matched by its name pattern and label selector
+type NamespaceClass struct {
+	Name string
+	// NamePattern is ignored if nil
+	NamePattern *regexp.Regexp
+	// Selector is ignored if nil
+	Selector labels.Selector
+	MaxAge   int64
+}
+
+// DefaultNamespaceClasses classifies ci tagged namespaces ending in a commit hash as build and all other ci tagged namespaces as review
+func DefaultNamespaceClasses(maxBuildAge, maxReviewAge int64) []NamespaceClass {
+	return []NamespaceClass{
+		{Name: BuildNamespaceClass, NamePattern: buildRegex, MaxAge: maxBuildAge},
+		{Name: ReviewNamespaceClass, NamePattern: ciRegex, MaxAge: maxReviewAge},
+	}
+}
+
+// NewNamespaceClass compiles a class, at least one of namePattern and labelSelector is required
+func NewNamespaceClass(name, namePattern, labelSelector string, maxAge int64) (NamespaceClass, error) {
+	class := NamespaceClass{
+		Name:   name,
+		MaxAge: maxAge,
+	}
+
+	if namePattern == "" && labelSelector == "" {
+		return NamespaceClass{}, fmt.Errorf("a namePattern or a labelSelector is required")
+	}
+
+	if namePattern != "" {
+		pattern, err := regexp.Compile(namePattern)
+		if err != nil {
+			return NamespaceClass{}, fmt.Errorf("invalid namePattern: %v", err)
+		}
+		class.NamePattern = pattern
+	}
+
+	if labelSelector != "" {
+		selector, err := labels.Parse(labelSelector)
+		if err != nil {
+			return NamespaceClass{}, fmt.Errorf("invalid labelSelector: %v", err)
+		}
+		class.Selector = selector
+	}
+
+	return class, nil
+}
+
+func (c NamespaceClass) matches(ns v1.Namespace) bool {
+	if c.NamePattern != nil && !c.NamePattern.MatchString(ns.ObjectMeta.Name) {
+		return false
+	}
+
+	if c.Selector != nil && !c.Selector.Matches(labels.Set(ns.ObjectMeta.Labels)) {
+		return false
+	}
+
+	return true
+}
+
+// classify returns the first class matching the namespace, namespaces without a class are not garbage collected
+func (r NamespaceRules) classify(ns v1.Namespace) (NamespaceClass, bool) {
+	classes := r.Classes
+	if classes == nil {
+		classes = DefaultNamespaceClasses(r.MaxTestingAge, r.MaxReviewAge)
+	}
+
+	for _, class := range classes {
+		if class.matches(ns) {
+			return class, true
+		}
+	}
+
+	return NamespaceClass{}, false
+}
@@ -0,0 +1,84 @@
+package gc
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceRules_classify(t *testing.T) {
+	mustClass := func(name, namePattern, labelSelector string, maxAge int64) NamespaceClass {
+		class, err := NewNamespaceClass(name, namePattern, labelSelector, maxAge)
+		if err != nil {
+			t.Fatalf("NewNamespaceClass() error = %v", err)
+		}
+		return class
+	}
+
+	customClasses := []NamespaceClass{
+		mustClass("pipeline", "^gl-.*-[0-9]+$", "", 60),
+		mustClass("pull-request", "^pr-", "", 120),
+		mustClass("labeled", "", "gc-class=preview", 180),
+	}
+
+	tests := []struct {
+		name      string
+		classes   []NamespaceClass
+		namespace string
+		labels    map[string]string
+		wantClass string
+		wantFound bool
+	}{
+		{"default build", nil, "project-shop-ci-54823-3a5db1781ab7cde0c53a3b53d995b75ee5873243", nil, BuildNamespaceClass, true},
+		{"default build with ci prefix", nil, "ci-username-playground-project-selenium-86421-efaf6b99b3ae85f", nil, BuildNamespaceClass, true},
+		{"default review", nil, "project-shop-ci-feature-cloud-upload-service", nil, ReviewNamespaceClass, true},
+		{"default review with short hash", nil, "ci-username-playground-project-selenium-86421-efaf6b99b3ae85", nil, ReviewNamespaceClass, true},
+		{"default review with ci suffix", nil, "project-shop-ci", nil, ReviewNamespaceClass, true},
+		{"default hash without ci tag", nil, "project-shop-54823-3a5db1781ab7cde0c53a3b53d995b75ee5873243", nil, "", false},
+		{"default ci as part of a word", nil, "project-circle", nil, "", false},
+		{"default ci only", nil, "ci", nil, "", false},
+		{"custom pipeline", customClasses, "gl-shop-54823", nil, "pipeline", true},
+		{"custom pull request", customClasses, "pr-shop-12", nil, "pull-request", true},
+		{"custom label selector", customClasses, "shop", map[string]string{"gc-class": "preview"}, "labeled", true},
+		{"custom classes replace defaults", customClasses, "project-shop-ci", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := NamespaceRules{Classes: tt.classes}
+			ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tt.namespace, Labels: tt.labels}}
+
+			class, found := rules.classify(ns)
+			if found != tt.wantFound {
+				t.Errorf("classify() found = %v, want %v", found, tt.wantFound)
+			}
+			if class.Name != tt.wantClass {
+				t.Errorf("classify() = %v, want %v", class.Name, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceClass(t *testing.T) {
+	tests := []struct {
+		name          string
+		namePattern   string
+		labelSelector string
+		wantErr       bool
+	}{
+		{"name pattern", "^gl-", "", false},
+		{"label selector", "", "team in (a,b)", false},
+		{"both", "^gl-", "team=a", false},
+		{"neither", "", "", true},
+		{"invalid name pattern", "(", "", true},
+		{"invalid label selector", "", "team in (", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNamespaceClass("class", tt.namePattern, tt.labelSelector, 60)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNamespaceClass() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package gc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// capture group names understood by name parsers
+const (
+	ProjectGroup  = "project"
+	BranchGroup   = "branch"
+	PipelineGroup = "pipeline"
+	SHAGroup      = "sha"
+)
+
+var nameGroups = []string{ProjectGroup, BranchGroup, PipelineGroup, SHAGroup}
+
+// NamespaceName holds the values extracted from a namespace name, values not captured by the parser are empty
+type NamespaceName struct {
+	Project  string
+	Branch   string
+	Pipeline string
+	SHA      string
+}
+
+// NewNameParser compiles a pattern, it has to contain at least one of the named groups project, branch, pipeline or sha
+func NewNameParser(pattern string) (*regexp.Regexp, error) {
+	parser, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range parser.SubexpNames() {
+		if group == "" {
+			continue
+		}
+		if !isNameGroup(group) {
+			return nil, fmt.Errorf("unknown capture group \"%s\", valid groups are: \"%s\"", group, strings.Join(nameGroups, ","))
+		}
+	}
+
+	for _, group := range nameGroups {
+		if parser.SubexpIndex(group) >= 0 {
+			return parser, nil
+		}
+	}
+
+	return nil, fmt.Errorf("at least one of the capture groups \"%s\" is required", strings.Join(nameGroups, ","))
+}
+
+func isNameGroup(group string) bool {
+	for _, g := range nameGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// parseName extracts the named groups of the first matching parser
+func (r NamespaceRules) parseName(name string) (NamespaceName, bool) {
+	for _, parser := range r.NameParsers {
+		match := parser.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		group := func(group string) string {
+			i := parser.SubexpIndex(group)
+			if i < 0 {
+				return ""
+			}
+			return match[i]
+		}
+
+		return NamespaceName{
+			Project:  group(ProjectGroup),
+			Branch:   group(BranchGroup),
+			Pipeline: group(PipelineGroup),
+			SHA:      group(SHAGroup),
+		}, true
+	}
+
+	return NamespaceName{}, false
+}
+
+// String lists the extracted values, e.g. "project: shop, pipeline: 54823"
+func (n NamespaceName) String() string {
+	fields := []string{}
+	for _, field := range n.fields() {
+		fields = append(fields, fmt.Sprintf("%s: %s", field.name, field.value))
+	}
+	return strings.Join(fields, ", ")
+}
+
+type nameField struct{ name, value string }
+
+// fields returns the extracted values named by their capture group
+func (n NamespaceName) fields() []nameField {
+	fields := []nameField{}
+	for _, field := range []nameField{
+		{ProjectGroup, n.Project},
+		{BranchGroup, n.Branch},
+		{PipelineGroup, n.Pipeline},
+		{SHAGroup, n.SHA},
+	} {
+		if field.value != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// equalProtectedBranch compares the parsed branch with the protected branches for equality, it returns the matching protected branch
+func equalProtectedBranch(branch string, protectedBranches []string) string {
+	for _, protected := range protectedBranches {
+		if branch == protected {
+			return protected
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package gc
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNamespaceRules_parseName(t *testing.T) {
+	rules := NamespaceRules{NameParsers: []*regexp.Regexp{
+		regexp.MustCompile("^(?P<project>.+)-ci-(?P<pipeline>[0-9]+)-(?P<sha>[0-9a-f]{15,})$"),
+		regexp.MustCompile("^(?P<project>.+)-ci-(?P<branch>.+)$"),
+	}}
+
+	tests := []struct {
+		name      string
+		namespace string
+		want      NamespaceName
+		wantFound bool
+	}{
+		{
+			name:      "build namespace",
+			namespace: "project-shop-ci-54823-3a5db1781ab7cde0c53a3b53d995b75ee5873243",
+			want:      NamespaceName{Project: "project-shop", Pipeline: "54823", SHA: "3a5db1781ab7cde0c53a3b53d995b75ee5873243"},
+			wantFound: true,
+		},
+		{
+			name:      "review namespace",
+			namespace: "project-shop-ci-feature-cloud-upload-service",
+			want:      NamespaceName{Project: "project-shop", Branch: "feature-cloud-upload-service"},
+			wantFound: true,
+		},
+		{
+			name:      "no parser matches",
+			namespace: "kube-system",
+			wantFound: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := rules.parseName(tt.namespace)
+			if found != tt.wantFound {
+				t.Errorf("parseName() found = %v, want %v", found, tt.wantFound)
+			}
+			if got != tt.want {
+				t.Errorf("parseName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNameParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{"all groups", "^(?P<project>.+)-(?P<branch>.+)-(?P<pipeline>[0-9]+)-(?P<sha>[0-9a-f]+)$", false},
+		{"single group", "^ci-(?P<branch>.+)$", false},
+		{"no named group", "^ci-(.+)$", true},
+		{"unknown group", "^(?P<team>.+)-ci-(?P<branch>.+)$", true},
+		{"invalid pattern", "(?P<branch>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNameParser(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNameParser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PlanKind identifies plans written by the plan subcommand
+const PlanKind = "Plan"
+
+// kinds of planned objects
+const (
+	NamespaceKind = "Namespace"
+	PodKind       = "Pod"
+)
+
+// verdicts of planned objects
+const (
+	VerdictDelete = "delete"
+	VerdictKeep   = "keep"
+)
+
+// Plan lists the decisions about all ci namespaces and gitlab executor pods, ApplyPlan executes its deletions
+type Plan struct {
+	APIVersion string     `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string     `json:"kind" yaml:"kind"`
+	CreatedAt  time.Time  `json:"createdAt" yaml:"createdAt"`
+	Items      []PlanItem `json:"items" yaml:"items"`
+}
+
+// PlanItem is the decision about a single object, ages are in seconds
+type PlanItem struct {
+	Kind string `json:"kind" yaml:"kind"`
+	// Namespace is empty for namespaces
+	Namespace       string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name            string `json:"name" yaml:"name"`
+	ResourceVersion string `json:"resourceVersion" yaml:"resourceVersion"`
+	Verdict         string `json:"verdict" yaml:"verdict"`
+	Reason          string `json:"reason" yaml:"reason"`
+	Rule            string `json:"rule,omitempty" yaml:"rule,omitempty"`
+	Age             int64  `json:"age" yaml:"age"`
+	AgeSource       string `json:"ageSource,omitempty" yaml:"ageSource,omitempty"`
+	MaxAge          int64  `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+	MaxAgeSource    string `json:"maxAgeSource,omitempty" yaml:"maxAgeSource,omitempty"`
+	ExpiresIn       int64  `json:"expiresIn,omitempty" yaml:"expiresIn,omitempty"`
+	Class           string `json:"class,omitempty" yaml:"class,omitempty"`
+	Policy          string `json:"policy,omitempty" yaml:"policy,omitempty"`
+	// Project is parsed from the name of a namespace by the name parsers
+	Project   string `json:"project,omitempty" yaml:"project,omitempty"`
+	Gitlab    string `json:"gitlab,omitempty" yaml:"gitlab,omitempty"`
+	JobStatus string `json:"jobStatus,omitempty" yaml:"jobStatus,omitempty"`
+	// Force deletes a pod on an unreachable node without grace period
+	Force bool `json:"force,omitempty" yaml:"force,omitempty"`
+	// DryRun is set for namespaces of policies which only log deletions, ApplyPlan doesn't delete them
+	DryRun bool `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	// Schedule, Unschedule and Rescue are the changes of the warning period ApplyPlan makes to a kept namespace
+	Schedule   bool `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Unschedule bool `json:"unschedule,omitempty" yaml:"unschedule,omitempty"`
+	Rescue     bool `json:"rescue,omitempty" yaml:"rescue,omitempty"`
+}
+
+// ObjectName is "namespace" for namespaces and "namespace/name" for pods
+func (i PlanItem) ObjectName() string {
+	if i.Namespace == "" {
+		return i.Name
+	}
+	return i.Namespace + "/" + i.Name
+}
+
+// schedules tells if ApplyPlan changes the warning period of a kept namespace
+func (i PlanItem) schedules() bool {
+	return i.Schedule || i.Unschedule || i.Rescue
+}
+
+// NewPlan evaluates all namespaces and executor pods like a oneshot run without deleting anything,
+// namespaces which are no ci namespaces or already terminating are left out, namespaces whose age sources
+// failed are kept and the plan is returned along with the error listing them
+func NewPlan(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, targets []RunnerTarget) (Plan, error) {
+	plan := Plan{APIVersion: ConfigAPIVersion, Kind: PlanKind, CreatedAt: time.Now().UTC(), Items: []PlanItem{}}
+
+	pods, err := listTargetPods(ctx, clientset, targets)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	nodes, err := listNodes(ctx, clientset, targets)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	for _, pod := range pods {
+		target, ok := matchingTarget(targets, &pod)
+		if !ok {
+			continue
+		}
+
+		evaluation, err := evaluateGitlabExecutor(ctx, pod, target.Rules, nodes)
+		if err != nil {
+			return Plan{}, err
+		}
+
+		item := PlanItem{
+			Kind:            PodKind,
+			Namespace:       pod.ObjectMeta.Namespace,
+			Name:            pod.ObjectMeta.Name,
+			ResourceVersion: pod.ObjectMeta.ResourceVersion,
+			Verdict:         VerdictKeep,
+			Reason:          evaluation.skipReason(),
+			Age:             age(pod.ObjectMeta.CreationTimestamp),
+			ExpiresIn:       evaluation.expiresIn,
+			JobStatus:       evaluation.jobStatus,
+		}
+		if evaluation.delete {
+			item.Verdict = VerdictDelete
+			item.Reason = evaluation.rule
+			item.Rule = evaluation.rule
+			item.MaxAge = evaluation.maxAge
+			item.Force = evaluation.force
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Plan{}, apiError(namespaceResource, err)
+	}
+
+	unevaluated := []string{}
+	for _, ns := range namespaces.Items {
+		decision, err := shouldDeleteNamespace(ctx, NewKubernetesClient(clientset, ns), rules, false)
+		if errors.Is(err, errAgeSourceFailed) {
+			slog.Error("skipping namespace", "namespace", ns.ObjectMeta.Name, "error", err)
+			unevaluated = append(unevaluated, ns.ObjectMeta.Name)
+			plan.Items = append(plan.Items, PlanItem{
+				Kind:            NamespaceKind,
+				Name:            ns.ObjectMeta.Name,
+				ResourceVersion: ns.ObjectMeta.ResourceVersion,
+				Verdict:         VerdictKeep,
+				Reason:          reasonAgeSourceFailed,
+				Rule:            err.Error(),
+				Age:             age(ns.ObjectMeta.CreationTimestamp),
+			})
+			continue
+		}
+		if err != nil {
+			return Plan{}, err
+		}
+
+		if decision.Reason == reasonNotCI || decision.Reason == reasonSystem || decision.Reason == reasonTerminating {
+			continue
+		}
+
+		plan.Items = append(plan.Items, PlanItem{
+			Kind:            NamespaceKind,
+			Name:            ns.ObjectMeta.Name,
+			ResourceVersion: ns.ObjectMeta.ResourceVersion,
+			Verdict:         decision.Verdict(),
+			Reason:          decision.Reason,
+			Rule:            decision.Rule,
+			Age:             decision.Age,
+			AgeSource:       decision.AgeSource,
+			MaxAge:          decision.MaxAge,
+			MaxAgeSource:    decision.MaxAgeSource,
+			ExpiresIn:       decision.ExpiresIn,
+			Class:           decision.Class,
+			Policy:          decision.Policy,
+			Project:         decision.Name.Project,
+			Gitlab:          decision.Gitlab,
+			DryRun:          decision.DryRun,
+			Schedule:        decision.Schedule,
+			Unschedule:      decision.Unschedule,
+			Rescue:          decision.Rescue,
+		})
+	}
+
+	if len(unevaluated) > 0 {
+		return plan, fmt.Errorf("kept %d namespaces whose age sources failed: %s", len(unevaluated), strings.Join(unevaluated, ", "))
+	}
+
+	return plan, nil
+}
+
+// LoadPlan reads a plan written as JSON or YAML
+func LoadPlan(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to read plan: %v", err)
+	}
+
+	return ParsePlan(data)
+}
+
+// ParsePlan parses a plan written as JSON or YAML, unknown fields are rejected
+func ParsePlan(data []byte) (Plan, error) {
+	plan := Plan{}
+
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&plan)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&plan)
+	}
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to parse plan: %v", err)
+	}
+
+	if plan.APIVersion != ConfigAPIVersion || plan.Kind != PlanKind {
+		return Plan{}, fmt.Errorf("unsupported plan \"%s/%s\", expected \"%s/%s\"", plan.APIVersion, plan.Kind, ConfigAPIVersion, PlanKind)
+	}
+
+	for _, item := range plan.Items {
+		if item.Kind != NamespaceKind && item.Kind != PodKind {
+			return Plan{}, fmt.Errorf("unsupported kind \"%s\" of %s", item.Kind, item.ObjectName())
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyPlan deletes the objects planned for deletion and schedules the namespaces planned for the warning period, objects whose
+// resourceVersion changed since planning and namespaces whose decision changed are refused, namespaces whose archive or age
+// sources failed are kept, all are reported in the error
+func ApplyPlan(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, plan Plan, dryRun bool) error {
+	refused := []string{}
+	unarchived := []string{}
+	unevaluated := []string{}
+	for _, item := range plan.Items {
+		if item.Verdict != VerdictDelete && !item.schedules() {
+			continue
+		}
+
+		err := applyItem(ctx, clientset, rules, item, dryRun)
+
+		if apierrors.IsNotFound(err) {
+			slog.Info("skipping deleted object", "kind", item.Kind, "name", item.ObjectName())
+			continue
+		}
+
+		if apierrors.IsConflict(err) {
+			slog.Warn("refusing to apply object changed since planning", "kind", item.Kind, "name", item.ObjectName(), "error", err)
+			refused = append(refused, strings.ToLower(item.Kind)+" "+item.ObjectName())
+			continue
+		}
+
+		if errors.Is(err, errArchiveFailed) {
+			slog.Error("skipping namespace", "namespace", item.Name, "error", err)
+			unarchived = append(unarchived, item.Name)
+			continue
+		}
+
+		if errors.Is(err, errAgeSourceFailed) {
+			slog.Error("skipping namespace", "namespace", item.Name, "error", err)
+			unevaluated = append(unevaluated, item.Name)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if rules.Archiver != nil && !dryRun {
+		rules.Archiver.EndRun(ctx, time.Now())
+	}
+
+	errs := []error{}
+	if len(refused) > 0 {
+		errs = append(errs, fmt.Errorf("refused to apply %d objects changed since planning: %s", len(refused), strings.Join(refused, ", ")))
+	}
+	if len(unarchived) > 0 {
+		errs = append(errs, fmt.Errorf("kept %d namespaces whose archive failed: %s", len(unarchived), strings.Join(unarchived, ", ")))
+	}
+	if len(unevaluated) > 0 {
+		errs = append(errs, fmt.Errorf("kept %d namespaces whose age sources failed: %s", len(unevaluated), strings.Join(unevaluated, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyItem deletes a single item within apiTimeout, namespaces get the archive timeout on top
+func applyItem(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, item PlanItem, dryRun bool) error {
+	timeout := apiTimeout
+	if item.Kind == NamespaceKind {
+		timeout = rules.namespaceTimeout()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch item.Kind {
+	case NamespaceKind:
+		return applyNamespace(ctx, clientset, rules, item, dryRun)
+	case PodKind:
+		return applyPod(ctx, clientset, rules.Events, item, dryRun)
+	}
+	return nil
+}
+
+func applyNamespace(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, item PlanItem, dryRun bool) error {
+	namespaces := clientset.CoreV1().Namespaces()
+
+	ns, err := namespaces.Get(ctx, item.Name, metav1.GetOptions{})
+	if err != nil {
+		return apiError(namespaceResource, err)
+	}
+
+	err = unchanged(NamespaceKind, item, ns.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	// the workloads of the namespace may have changed without changing the namespace itself, the decision is compared to
+	// the plan, which is made without dry run
+	decision, err := shouldDeleteNamespace(ctx, NewKubernetesClient(clientset, *ns), rules, false)
+	if err != nil {
+		return err
+	}
+	if item.Verdict != VerdictDelete {
+		return scheduleNamespace(ctx, clientset, rules, item, *ns, decision, dryRun)
+	}
+	if !decision.Delete {
+		return conflict(NamespaceKind, item, fmt.Sprintf("no longer deletable, reason: %s", decision.Reason))
+	}
+
+	slog.Info("deleting namespace", "namespace", item.Name, "reason", item.Reason, "rule", item.Rule, "dryRun", dryRun || item.DryRun)
+
+	if dryRun || item.DryRun {
+		rules.Events.namespace(*ns, v1.EventTypeNormal, DeletingEventReason, "deleting namespace as planned%s, reason: %s, rule: %s", dryRunNote(true), item.Reason, item.Rule)
+		observeDeletion(namespaceResource, item.Project, item.Reason, true)
+		return nil
+	}
+
+	archive, err := archiveNamespace(ctx, rules.Archiver, item.Name)
+	if err != nil {
+		return err
+	}
+
+	rules.Events.namespaceNow(ctx, *ns, v1.EventTypeNormal, DeletingEventReason, "deleting namespace as planned, reason: %s, rule: %s", item.Reason, item.Rule)
+
+	// the precondition refuses the deletion if the namespace changed while it was archived
+	err = namespaces.Delete(ctx, item.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &item.ResourceVersion}})
+	if apierrors.IsConflict(err) {
+		discardArchive(rules.Archiver, item.Name, archive)
+	}
+	if err != nil {
+		return apiError(namespaceResource, err)
+	}
+
+	observeDeletion(namespaceResource, item.Project, item.Reason, false)
+
+	if rules.Gitlab != nil && rules.StopEnvironmentAnnotation != "" {
+		stopGitlabEnvironment(ctx, rules.Gitlab, *ns, rules.StopEnvironmentAnnotation)
+	}
+
+	return nil
+}
+
+// scheduleNamespace changes the warning period of a namespace as planned, the namespace is refused if it isn't evaluated the same way anymore
+func scheduleNamespace(ctx context.Context, clientset kubernetes.Interface, rules NamespaceRules, item PlanItem, ns v1.Namespace, decision Decision, dryRun bool) error {
+	if decision.Schedule != item.Schedule || decision.Unschedule != item.Unschedule || decision.Rescue != item.Rescue {
+		return conflict(NamespaceKind, item, fmt.Sprintf("warning period changed, reason: %s", decision.Reason))
+	}
+
+	slog.Info("scheduling namespace", "namespace", item.Name, "reason", item.Reason, "schedule", item.Schedule,
+		"unschedule", item.Unschedule, "rescue", item.Rescue, "dryRun", dryRun)
+
+	if dryRun {
+		return nil
+	}
+
+	recordDecision(rules.Events, ns, decision, false, rules.DeletionNotice)
+	return rules.scheduleDeletion(ctx, clientset, ns, decision, time.Now())
+}
+
+func applyPod(ctx context.Context, clientset kubernetes.Interface, events *Events, item PlanItem, dryRun bool) error {
+	pods := clientset.CoreV1().Pods(item.Namespace)
+
+	pod, err := pods.Get(ctx, item.Name, metav1.GetOptions{})
+	if err != nil {
+		return apiError(podResource, err)
+	}
+
+	err = unchanged(PodKind, item, pod.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("deleting pod", "pod", item.Name, "namespace", item.Namespace, "rule", item.Rule, "dryRun", dryRun)
+	events.eventf(pod, v1.EventTypeNormal, DeletingEventReason, "deleting gitlab executor pod as planned%s, rule: %s", dryRunNote(dryRun), item.Rule)
+
+	if dryRun {
+		observeDeletion(podResource, "", item.Rule, true)
+		return nil
+	}
+
+	options := executorDeleteOptions(item.Force)
+	options.Preconditions = &metav1.Preconditions{ResourceVersion: &item.ResourceVersion}
+
+	err = pods.Delete(ctx, item.Name, options)
+	if err != nil {
+		return apiError(podResource, err)
+	}
+
+	observeDeletion(podResource, "", item.Rule, false)
+	return nil
+}
+
+// unchanged returns a conflict if the object was modified since planning
+func unchanged(kind string, item PlanItem, meta metav1.ObjectMeta) error {
+	if meta.ResourceVersion == item.ResourceVersion {
+		return nil
+	}
+
+	message := fmt.Sprintf("resourceVersion changed from %s to %s", item.ResourceVersion, meta.ResourceVersion)
+	return conflict(kind, item, message)
+}
+
+// conflict refuses the deletion of a planned object
+func conflict(kind string, item PlanItem, message string) error {
+	resource := strings.ToLower(kind) + "s"
+	return apierrors.NewConflict(schema.GroupResource{Resource: resource}, item.Name, errors.New(message))
+}
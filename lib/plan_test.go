@@ -0,0 +1,390 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNewPlan(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-48 * time.Hour))
+	young := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	clientset := fake.NewClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", CreationTimestamp: old}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature-old", CreationTimestamp: old, ResourceVersion: "11"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature-young", CreationTimestamp: young, ResourceVersion: "12"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "gitlab-runner", Name: "runner-old", CreationTimestamp: old, ResourceVersion: "21",
+			Labels: map[string]string{"app": "gitlab-ci-job"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "gitlab-runner", Name: "runner-young", CreationTimestamp: young, ResourceVersion: "22",
+			Labels: map[string]string{"app": "gitlab-ci-job"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "gitlab-runner", Name: "runner-manager", CreationTimestamp: old}},
+	)
+	rules := NamespaceRules{
+		AgeSources:   builtinAgeSources("namespace"),
+		MaxReviewAge: int64(24 * time.Hour.Seconds()),
+	}
+	targets := []RunnerTarget{{Namespace: "gitlab-runner", Rules: ExecutorRules{MaxAge: int64(time.Hour.Seconds())}}}
+
+	plan, err := NewPlan(context.TODO(), clientset, rules, targets)
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+
+	got := []string{}
+	for _, item := range plan.Items {
+		got = append(got, strings.Join([]string{item.Kind, item.ObjectName(), item.ResourceVersion, item.Verdict, item.Reason}, " "))
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"Namespace shop-ci-feature-old 11 delete expired",
+		"Namespace shop-ci-feature-young 12 keep too-young",
+		"Pod gitlab-runner/runner-old 21 delete maxAge",
+		"Pod gitlab-runner/runner-young 22 keep too-young",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("NewPlan() items = %v, want %v", got, want)
+	}
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("NewPlan() deleted %v", action)
+		}
+	}
+}
+
+func TestParsePlan(t *testing.T) {
+	plan := Plan{
+		APIVersion: ConfigAPIVersion,
+		Kind:       PlanKind,
+		CreatedAt:  time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+		Items:      []PlanItem{{Kind: NamespaceKind, Name: "shop-ci-feature", ResourceVersion: "11", Verdict: VerdictDelete, Reason: reasonExpired}},
+	}
+
+	jsonData, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlData, err := yaml.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "json",
+			data: string(jsonData),
+		},
+		{
+			name: "yaml",
+			data: string(yamlData),
+		},
+		{
+			name:    "unknown field",
+			data:    strings.Replace(string(jsonData), `"verdict"`, `"verdikt"`, 1),
+			wantErr: "unknown field",
+		},
+		{
+			name:    "unsupported kind",
+			data:    strings.Replace(string(yamlData), "kind: Namespace", "kind: Deployment", 1),
+			wantErr: "unsupported kind \"Deployment\" of shop-ci-feature",
+		},
+		{
+			name:    "no plan",
+			data:    "apiVersion: k8s-gitlab-gc/v1alpha1\nkind: Config\n",
+			wantErr: "unsupported plan",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePlan([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParsePlan() error = %v, want it to contain %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePlan() error = %v", err)
+			}
+			if !got.CreatedAt.Equal(plan.CreatedAt) || len(got.Items) != 1 || got.Items[0] != plan.Items[0] {
+				t.Errorf("ParsePlan() = %+v, want %+v", got, plan)
+			}
+		})
+	}
+}
+
+// expiredRules delete namespaces older than a day, apply evaluates the namespaces of a plan again
+var expiredRules = NamespaceRules{
+	AgeSources:   builtinAgeSources("namespace", "deployment"),
+	MaxReviewAge: int64(24 * time.Hour.Seconds()),
+}
+
+// expiredNamespace was created before the max age of expiredRules
+func expiredNamespace(name, resourceVersion string) *v1.Namespace {
+	created := metav1.NewTime(time.Now().Add(-48 * time.Hour))
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: resourceVersion, CreationTimestamp: created}}
+}
+
+func TestApplyPlan(t *testing.T) {
+	clientset := fake.NewClientset(
+		expiredNamespace("shop-ci-unchanged", "11"),
+		expiredNamespace("shop-ci-changed", "13"),
+		expiredNamespace("shop-ci-kept", "14"),
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "gitlab-runner", Name: "runner-unchanged", ResourceVersion: "21"}},
+	)
+	plan := Plan{APIVersion: ConfigAPIVersion, Kind: PlanKind, Items: []PlanItem{
+		{Kind: NamespaceKind, Name: "shop-ci-unchanged", ResourceVersion: "11", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-changed", ResourceVersion: "12", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-gone", ResourceVersion: "15", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-kept", ResourceVersion: "14", Verdict: VerdictKeep, Reason: reasonTooYoung},
+		{Kind: PodKind, Namespace: "gitlab-runner", Name: "runner-unchanged", ResourceVersion: "21", Verdict: VerdictDelete, Reason: MaxAgeRule, Rule: MaxAgeRule},
+	}}
+
+	err := ApplyPlan(context.TODO(), clientset, expiredRules, plan, false)
+	if err == nil || !strings.Contains(err.Error(), "refused to apply 1 objects changed since planning: namespace shop-ci-changed") {
+		t.Errorf("ApplyPlan() error = %v, want shop-ci-changed to be refused", err)
+	}
+
+	deleted := []string{}
+	for _, action := range clientset.Actions() {
+		if deleteAction, ok := action.(k8stesting.DeleteAction); ok {
+			deleted = append(deleted, deleteAction.GetResource().Resource+"/"+deleteAction.GetName())
+		}
+	}
+	sort.Strings(deleted)
+
+	want := []string{"namespaces/shop-ci-unchanged", "pods/runner-unchanged"}
+	if strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
+
+func TestApplyPlan_archiveOfConflict(t *testing.T) {
+	clientset := fake.NewClientset(
+		expiredNamespace("shop-ci-deleted", "11"),
+		expiredNamespace("shop-ci-raced", "12"),
+	)
+	// the namespace changes between archiving and deleting it
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.DeleteAction).GetName() != "shop-ci-raced" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "namespaces"}, "shop-ci-raced", nil)
+	})
+
+	store, err := NewDirectoryStore(filepath.Join(t.TempDir(), "archives"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := NewArchiver(clientset.Discovery(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), store)
+
+	plan := Plan{APIVersion: ConfigAPIVersion, Kind: PlanKind, Items: []PlanItem{
+		{Kind: NamespaceKind, Name: "shop-ci-deleted", ResourceVersion: "11", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-raced", ResourceVersion: "12", Verdict: VerdictDelete, Reason: reasonExpired},
+	}}
+
+	rules := expiredRules
+	rules.Archiver = archiver
+	err = ApplyPlan(context.TODO(), clientset, rules, plan, false)
+	if err == nil || !strings.Contains(err.Error(), "namespace shop-ci-raced") {
+		t.Errorf("ApplyPlan() error = %v, want shop-ci-raced to be refused", err)
+	}
+
+	objects, err := store.List(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || !strings.HasPrefix(objects[0].Key, "shop-ci-deleted-") {
+		t.Errorf("archives = %v, want only the one of shop-ci-deleted", objects)
+	}
+}
+
+func TestApplyPlan_failedArchive(t *testing.T) {
+	clientset := fake.NewClientset(
+		expiredNamespace("shop-ci-hanging", "11"),
+		expiredNamespace("shop-ci-deleted", "12"),
+	)
+
+	directory, err := NewDirectoryStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := NewArchiver(clientset.Discovery(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), blockingStore{directory, "shop-ci-hanging"})
+	archiver.Timeout = 10 * time.Millisecond
+
+	plan := Plan{APIVersion: ConfigAPIVersion, Kind: PlanKind, Items: []PlanItem{
+		{Kind: NamespaceKind, Name: "shop-ci-hanging", ResourceVersion: "11", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-deleted", ResourceVersion: "12", Verdict: VerdictDelete, Reason: reasonExpired},
+	}}
+
+	rules := expiredRules
+	rules.Archiver = archiver
+	err = ApplyPlan(context.TODO(), clientset, rules, plan, false)
+	if err == nil || !strings.Contains(err.Error(), "kept 1 namespaces whose archive failed: shop-ci-hanging") {
+		t.Errorf("ApplyPlan() error = %v, want shop-ci-hanging to be kept", err)
+	}
+
+	deleted := []string{}
+	for _, action := range clientset.Actions() {
+		if deleteAction, ok := action.(k8stesting.DeleteAction); ok {
+			deleted = append(deleted, deleteAction.GetName())
+		}
+	}
+	if strings.Join(deleted, ",") != "shop-ci-deleted" {
+		t.Errorf("deleted = %v, want the namespace after the failed archive", deleted)
+	}
+}
+
+func TestApplyPlan_reevaluated(t *testing.T) {
+	deployed := metav1.NewTime(time.Now().Add(-time.Minute))
+	clientset := fake.NewClientset(
+		expiredNamespace("shop-ci-idle", "11"),
+		expiredNamespace("shop-ci-redeployed", "12"),
+		// the namespace itself is unchanged, but a deployment was rolled out since planning
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop-ci-redeployed", Name: "shop", CreationTimestamp: deployed}},
+	)
+	plan := Plan{APIVersion: ConfigAPIVersion, Kind: PlanKind, Items: []PlanItem{
+		{Kind: NamespaceKind, Name: "shop-ci-idle", ResourceVersion: "11", Verdict: VerdictDelete, Reason: reasonExpired},
+		{Kind: NamespaceKind, Name: "shop-ci-redeployed", ResourceVersion: "12", Verdict: VerdictDelete, Reason: reasonExpired},
+	}}
+
+	err := ApplyPlan(context.TODO(), clientset, expiredRules, plan, false)
+	if err == nil || !strings.Contains(err.Error(), "refused to apply 1 objects changed since planning: namespace shop-ci-redeployed") {
+		t.Errorf("ApplyPlan() error = %v, want shop-ci-redeployed to be refused", err)
+	}
+
+	deleted := []string{}
+	for _, action := range clientset.Actions() {
+		if deleteAction, ok := action.(k8stesting.DeleteActionImpl); ok {
+			deleted = append(deleted, deleteAction.GetName())
+			if got := *deleteAction.DeleteOptions.Preconditions.ResourceVersion; got != "11" {
+				t.Errorf("delete precondition resourceVersion = %s, want the planned 11", got)
+			}
+		}
+	}
+	if strings.Join(deleted, ",") != "shop-ci-idle" {
+		t.Errorf("deleted = %v, want only the namespace which is still deletable", deleted)
+	}
+}
+
+func TestNewPlan_failedAgeSource(t *testing.T) {
+	clientset := fake.NewClientset(
+		expiredNamespace("shop-ci-down", "11"),
+		expiredNamespace("shop-ci-idle", "12"),
+	)
+	failing := func(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+		if api.Namespace().ObjectMeta.Name == "shop-ci-down" {
+			return 0, false, errors.New("prometheus unavailable")
+		}
+		return 0, false, nil
+	}
+	rules := expiredRules
+	rules.AgeSources = append(builtinAgeSources("namespace"), unnamedAgeSources(failing)...)
+
+	plan, err := NewPlan(context.TODO(), clientset, rules, nil)
+	if err == nil || !strings.Contains(err.Error(), "kept 1 namespaces whose age sources failed: shop-ci-down") {
+		t.Errorf("NewPlan() error = %v, want shop-ci-down to be kept", err)
+	}
+
+	got := []string{}
+	for _, item := range plan.Items {
+		got = append(got, strings.Join([]string{item.ObjectName(), item.Verdict, item.Reason}, " "))
+	}
+	want := []string{"shop-ci-down keep age-source-failed", "shop-ci-idle delete expired"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("NewPlan() items = %v, want %v", got, want)
+	}
+	if !strings.Contains(plan.Items[0].Rule, "prometheus unavailable") {
+		t.Errorf("NewPlan() rule = %s, want the error of the age source", plan.Items[0].Rule)
+	}
+}
+
+func TestApplyPlan_warningPeriod(t *testing.T) {
+	scheduledAt := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	due := expiredNamespace("shop-ci-due", "12")
+	due.ObjectMeta.Labels = map[string]string{ScheduledForDeletionLabel: scheduledAt}
+	due.ObjectMeta.Annotations = map[string]string{ScheduledAtAnnotation: scheduledAt}
+	rescued := expiredNamespace("shop-ci-rescued", "13")
+	rescued.ObjectMeta.Annotations = map[string]string{ScheduledAtAnnotation: scheduledAt}
+
+	clientset := fake.NewClientset(expiredNamespace("shop-ci-expired", "11"), due, rescued)
+	rules := expiredRules
+	rules.WarningPeriod = int64(time.Hour.Seconds())
+
+	plan, err := NewPlan(context.TODO(), clientset, rules, nil)
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+
+	got := []string{}
+	for _, item := range plan.Items {
+		got = append(got, fmt.Sprintf("%s %s %s schedule=%v rescue=%v", item.Name, item.Verdict, item.Reason, item.Schedule, item.Rescue))
+	}
+	want := []string{
+		"shop-ci-due delete expired schedule=false rescue=false",
+		"shop-ci-expired keep scheduled schedule=true rescue=false",
+		"shop-ci-rescued keep rescued schedule=false rescue=true",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("NewPlan() items = %v, want %v", got, want)
+	}
+
+	err = ApplyPlan(context.TODO(), clientset, rules, plan, true)
+	if err != nil {
+		t.Fatalf("ApplyPlan() dry run error = %v", err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" || action.GetVerb() == "delete" {
+			t.Errorf("ApplyPlan() dry run changed the cluster: %v", action)
+		}
+	}
+
+	err = ApplyPlan(context.TODO(), clientset, rules, plan, false)
+	if err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+
+	if got := deletedNames(clientset.Actions()); strings.Join(got, ",") != "shop-ci-due" {
+		t.Errorf("deleted = %v, want the namespace whose warning period passed", got)
+	}
+
+	expired, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "shop-ci-expired", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := expired.ObjectMeta.Labels[ScheduledForDeletionLabel]; !ok {
+		t.Errorf("labels = %v, want shop-ci-expired to be scheduled", expired.ObjectMeta.Labels)
+	}
+
+	rescued, err = clientset.CoreV1().Namespaces().Get(context.TODO(), "shop-ci-rescued", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rescued.ObjectMeta.Annotations[RescuedAtAnnotation]; !ok {
+		t.Errorf("annotations = %v, want shop-ci-rescued to be rescued", rescued.ObjectMeta.Annotations)
+	}
+}
@@ -0,0 +1,221 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// NamespaceGCPolicyResource is the cluster scoped custom resource teams use to define their own retention rules
+var NamespaceGCPolicyResource = schema.GroupVersionResource{
+	Group:    "k8s-gitlab-gc.utopia-planitia.non-existing-tld",
+	Version:  "v1alpha1",
+	Resource: "namespacegcpolicies",
+}
+
+type NamespaceGCPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceGCPolicySpec `json:"spec"`
+}
+
+type NamespaceGCPolicySpec struct {
+	// Priority decides which policy applies if several select a namespace, the highest wins
+	Priority int32 `json:"priority,omitempty"`
+	// NamespaceSelector selects the namespaces the policy applies to, namespaces without ci tags only if the config allowlists them
+	NamespaceSelector NamespaceSelector `json:"namespaceSelector"`
+	// MaxAge replaces the global max ages for build and review namespaces
+	MaxAge metav1.Duration `json:"maxAge"`
+	// ProtectedPatterns are regular expressions of namespace names never to delete, they add to the global protected branches
+	ProtectedPatterns []string `json:"protectedPatterns,omitempty"`
+	// AgeSources replace the global onlyUseAgesOf
+	AgeSources []string `json:"ageSources,omitempty"`
+	// DryRun only logs deletions and hibernations of selected namespaces
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type NamespaceSelector struct {
+	NamePattern   string                `json:"namePattern,omitempty"`
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// namespacePolicy is a NamespaceGCPolicy with compiled patterns and resolved age sources
+type namespacePolicy struct {
+	name        string
+	priority    int32
+	namePattern *regexp.Regexp
+	selector    labels.Selector
+	maxAge      int64
+	protected   []*regexp.Regexp
+	ageSources  []AgeSource
+	dryRun      bool
+}
+
+// WithPolicies returns a copy of the rules evaluating namespaces by the given policies, invalid policies are reported and skipped
+func (r NamespaceRules) WithPolicies(policies []NamespaceGCPolicy) NamespaceRules {
+	r.policies = []namespacePolicy{}
+
+	for _, policy := range policies {
+		compiled, err := compilePolicy(policy, r.AgeSourceClients)
+		if err != nil {
+			slog.Warn("skipping invalid namespace gc policy", "policy", policy.ObjectMeta.Name, "error", err)
+			continue
+		}
+
+		r.policies = append(r.policies, compiled)
+	}
+
+	sort.SliceStable(r.policies, func(i, j int) bool {
+		if r.policies[i].priority != r.policies[j].priority {
+			return r.policies[i].priority > r.policies[j].priority
+		}
+		return r.policies[i].name < r.policies[j].name
+	})
+
+	return r
+}
+
+func compilePolicy(policy NamespaceGCPolicy, clients AgeSourceClients) (namespacePolicy, error) {
+	spec := policy.Spec
+
+	if spec.NamespaceSelector.NamePattern == "" && spec.NamespaceSelector.LabelSelector == nil {
+		return namespacePolicy{}, fmt.Errorf("namespaceSelector requires a namePattern or a labelSelector")
+	}
+
+	if spec.NamespaceSelector.LabelSelector != nil && len(spec.NamespaceSelector.LabelSelector.MatchLabels) == 0 && len(spec.NamespaceSelector.LabelSelector.MatchExpressions) == 0 {
+		return namespacePolicy{}, fmt.Errorf("labelSelector must not be empty, it selects every namespace")
+	}
+
+	if spec.MaxAge.Duration <= 0 {
+		return namespacePolicy{}, fmt.Errorf("maxAge must be positive")
+	}
+
+	compiled := namespacePolicy{
+		name:     policy.ObjectMeta.Name,
+		priority: spec.Priority,
+		selector: labels.Everything(),
+		maxAge:   int64(spec.MaxAge.Seconds()),
+		dryRun:   spec.DryRun,
+	}
+
+	if spec.NamespaceSelector.NamePattern != "" {
+		namePattern, err := regexp.Compile(spec.NamespaceSelector.NamePattern)
+		if err != nil {
+			return namespacePolicy{}, fmt.Errorf("invalid namePattern: %v", err)
+		}
+		if selectsAnyName(namePattern) {
+			return namespacePolicy{}, fmt.Errorf("namePattern \"%s\" selects every namespace", spec.NamespaceSelector.NamePattern)
+		}
+		compiled.namePattern = namePattern
+	}
+
+	if spec.NamespaceSelector.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector.LabelSelector)
+		if err != nil {
+			return namespacePolicy{}, fmt.Errorf("invalid labelSelector: %v", err)
+		}
+		compiled.selector = selector
+	}
+
+	for _, pattern := range spec.ProtectedPatterns {
+		protected, err := regexp.Compile(pattern)
+		if err != nil {
+			return namespacePolicy{}, fmt.Errorf("invalid protectedPatterns: %v", err)
+		}
+		compiled.protected = append(compiled.protected, protected)
+	}
+
+	if len(spec.AgeSources) > 0 {
+		ageSources, err := SelectAgeSources(spec.AgeSources, clients)
+		if err != nil {
+			return namespacePolicy{}, fmt.Errorf("invalid ageSources: %v", err)
+		}
+		compiled.ageSources = ageSources
+	}
+
+	return compiled, nil
+}
+
+// selectsAnyName reports patterns like ".*" or "[a-z-]+", they match an empty or a single letter name
+func selectsAnyName(pattern *regexp.Regexp) bool {
+	return pattern.MatchString("") || pattern.MatchString("a")
+}
+
+func (p namespacePolicy) matches(ns v1.Namespace) bool {
+	if p.namePattern != nil && !p.namePattern.MatchString(ns.ObjectMeta.Name) {
+		return false
+	}
+
+	return p.selector.Matches(labels.Set(ns.ObjectMeta.Labels))
+}
+
+// protectingPattern returns the protected pattern matching the name, it is empty if none matches
+func (p namespacePolicy) protectingPattern(name string) string {
+	for _, pattern := range p.protected {
+		if pattern.MatchString(name) {
+			return pattern.String()
+		}
+	}
+	return ""
+}
+
+// allowsPolicy reports if policies may select the namespace although it is no ci namespace
+func (r NamespaceRules) allowsPolicy(name string) bool {
+	for _, pattern := range r.PolicyAllowlist {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingPolicy returns the highest priority policy selecting the namespace
+func (r NamespaceRules) matchingPolicy(ns v1.Namespace) (namespacePolicy, bool) {
+	for _, policy := range r.policies {
+		if policy.matches(ns) {
+			return policy, true
+		}
+	}
+	return namespacePolicy{}, false
+}
+
+// ListNamespaceGCPolicies returns all policies, no policies are returned if the custom resource definition is not installed
+func ListNamespaceGCPolicies(ctx context.Context, client dynamic.Interface) ([]NamespaceGCPolicy, error) {
+	list, err := client.Resource(NamespaceGCPolicyResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return []NamespaceGCPolicy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []NamespaceGCPolicy{}
+	for _, item := range list.Items {
+		policy, err := namespaceGCPolicyFromUnstructured(&item)
+		if err != nil {
+			slog.Warn("skipping invalid namespace gc policy", "policy", item.GetName(), "error", err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+func namespaceGCPolicyFromUnstructured(obj *unstructured.Unstructured) (NamespaceGCPolicy, error) {
+	policy := NamespaceGCPolicy{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &policy)
+	return policy, err
+}
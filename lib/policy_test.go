@@ -0,0 +1,110 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestNamespaceRules_WithPolicies(t *testing.T) {
+	policy := func(name string, priority int32, namePattern string, maxAge time.Duration) NamespaceGCPolicy {
+		return NamespaceGCPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: NamespaceGCPolicySpec{
+				Priority:          priority,
+				NamespaceSelector: NamespaceSelector{NamePattern: namePattern},
+				MaxAge:            metav1.Duration{Duration: maxAge},
+			},
+		}
+	}
+
+	emptySelector := policy("empty-selector", 5, "", time.Hour)
+	emptySelector.Spec.NamespaceSelector.LabelSelector = &metav1.LabelSelector{}
+
+	rules := NamespaceRules{}.WithPolicies([]NamespaceGCPolicy{
+		emptySelector,
+		policy("b-low", 1, "^team-", time.Hour),
+		policy("invalid-pattern", 5, "(", time.Hour),
+		policy("invalid-max-age", 5, "^team-", 0),
+		policy("missing-selector", 5, "", time.Hour),
+		policy("match-all-pattern", 5, ".*", time.Hour),
+		policy("match-any-name-pattern", 5, "^[a-z-]+$", time.Hour),
+		policy("c-high", 3, "^team-", time.Hour),
+		policy("a-high", 3, "^team-", time.Hour),
+	})
+
+	got := []string{}
+	for _, p := range rules.policies {
+		got = append(got, p.name)
+	}
+
+	want := []string{"a-high", "c-high", "b-low"}
+	if len(got) != len(want) {
+		t.Fatalf("policies = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("policies = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestListNamespaceGCPolicies(t *testing.T) {
+	policy := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "k8s-gitlab-gc.utopia-planitia.non-existing-tld/v1alpha1",
+		"kind":       "NamespaceGCPolicy",
+		"metadata":   map[string]any{"name": "team-a"},
+		"spec": map[string]any{
+			"priority": int64(10),
+			"namespaceSelector": map[string]any{
+				"namePattern": "^team-a-",
+				"labelSelector": map[string]any{
+					"matchLabels": map[string]any{"team": "a"},
+				},
+			},
+			"maxAge":     "6h",
+			"ageSources": []any{"namespace", "pod"},
+			"dryRun":     true,
+		},
+	}}
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{NamespaceGCPolicyResource: "NamespaceGCPolicyList"},
+		policy,
+	)
+
+	policies, err := ListNamespaceGCPolicies(context.TODO(), client)
+	if err != nil {
+		t.Fatalf("ListNamespaceGCPolicies() error = %v", err)
+	}
+
+	if len(policies) != 1 {
+		t.Fatalf("len(policies) = %d, want 1", len(policies))
+	}
+
+	spec := policies[0].Spec
+	if spec.Priority != 10 {
+		t.Errorf("priority = %v, want 10", spec.Priority)
+	}
+	if spec.MaxAge.Duration != 6*time.Hour {
+		t.Errorf("maxAge = %v, want 6h", spec.MaxAge.Duration)
+	}
+	if spec.NamespaceSelector.LabelSelector.MatchLabels["team"] != "a" {
+		t.Errorf("labelSelector = %v, want team=a", spec.NamespaceSelector.LabelSelector)
+	}
+	if !spec.DryRun {
+		t.Errorf("dryRun = %v, want true", spec.DryRun)
+	}
+
+	_, err = compilePolicy(policies[0], AgeSourceClients{})
+	if err != nil {
+		t.Errorf("compilePolicy() error = %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package gc
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultExecutorSelector matches the pods of the gitlab runner kubernetes executor without custom pod_labels
+const DefaultExecutorSelector = "app=gitlab-ci-job"
+
+// RunnerTarget selects the executor pods of a gitlab runner and the rules to remove them by
+type RunnerTarget struct {
+	// Namespace is searched for executor pods, all namespaces are searched if empty
+	Namespace string
+	// Selector is matched against the labels of pods, DefaultExecutorSelector is used if nil
+	Selector labels.Selector
+	Rules    ExecutorRules
+}
+
+// NewRunnerTarget parses the label selector, DefaultExecutorSelector is used if it is empty
+func NewRunnerTarget(namespace, labelSelector string, rules ExecutorRules) (RunnerTarget, error) {
+	if labelSelector == "" {
+		labelSelector = DefaultExecutorSelector
+	}
+
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return RunnerTarget{}, fmt.Errorf("invalid labelSelector: %v", err)
+	}
+
+	return RunnerTarget{Namespace: namespace, Selector: selector, Rules: rules}, nil
+}
+
+func (t RunnerTarget) matches(pod metav1.Object) bool {
+	if t.Namespace != "" && pod.GetNamespace() != t.Namespace {
+		return false
+	}
+
+	if t.Selector == nil {
+		return isGitlabJobPod(pod.GetLabels())
+	}
+
+	return t.Selector.Matches(labels.Set(pod.GetLabels()))
+}
+
+// matchingTarget returns the first target selecting the pod
+func matchingTarget(targets []RunnerTarget, pod metav1.Object) (RunnerTarget, bool) {
+	for _, target := range targets {
+		if target.matches(pod) {
+			return target, true
+		}
+	}
+
+	return RunnerTarget{}, false
+}
+
+// usesUnreachableRule reports if any target needs to look up nodes
+func usesUnreachableRule(targets []RunnerTarget) bool {
+	for _, target := range targets {
+		if target.Rules.MaxUnreachableAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// orphanTargets returns the first target of every namespace collecting orphans, targets of all namespaces never collect orphans
+func orphanTargets(targets []RunnerTarget) []RunnerTarget {
+	seen := map[string]bool{}
+	selected := []RunnerTarget{}
+	for _, target := range targets {
+		if target.Namespace == "" || target.Rules.MaxOrphanAge == 0 || seen[target.Namespace] {
+			continue
+		}
+
+		seen[target.Namespace] = true
+		selected = append(selected, target)
+	}
+	return selected
+}
+
+// podsIn filters pods by namespace
+func podsIn(pods []v1.Pod, namespace string) []v1.Pod {
+	filtered := []v1.Pod{}
+	for _, pod := range pods {
+		if pod.ObjectMeta.Namespace == namespace {
+			filtered = append(filtered, pod)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,105 @@
+package gc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunnerTarget_matches(t *testing.T) {
+	mustTarget := func(namespace, labelSelector string) RunnerTarget {
+		target, err := NewRunnerTarget(namespace, labelSelector, ExecutorRules{})
+		if err != nil {
+			t.Fatalf("NewRunnerTarget() error = %v", err)
+		}
+		return target
+	}
+
+	pod := func(namespace string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "runner", Labels: labels}}
+	}
+
+	tests := []struct {
+		name   string
+		target RunnerTarget
+		pod    *v1.Pod
+		want   bool
+	}{
+		{"default selector", mustTarget("gitlab-runner", ""), pod("gitlab-runner", map[string]string{"app": "gitlab-ci-job"}), true},
+		{"zero value target", RunnerTarget{Namespace: "gitlab-runner"}, pod("gitlab-runner", map[string]string{"app": "gitlab-ci-job"}), true},
+		{"other namespace", mustTarget("gitlab-runner", ""), pod("runner-b", map[string]string{"app": "gitlab-ci-job"}), false},
+		{"all namespaces", mustTarget("", ""), pod("runner-b", map[string]string{"app": "gitlab-ci-job"}), true},
+		{"custom pod labels", mustTarget("runner-b", "team in (a,b),ci-job"), pod("runner-b", map[string]string{"team": "a", "ci-job": "1"}), true},
+		{"custom pod labels not matching", mustTarget("runner-b", "team in (a,b),ci-job"), pod("runner-b", map[string]string{"app": "gitlab-ci-job"}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.target.matches(tt.pod); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabExecutors_targets(t *testing.T) {
+	pod := func(namespace, name string, labels map[string]string, age time.Duration) runtime.Object {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		}}
+	}
+	defaultLabels := map[string]string{"app": "gitlab-ci-job"}
+	customLabels := map[string]string{"runner": "large"}
+
+	clientset := fake.NewClientset(
+		pod("runner-a", "a-young", defaultLabels, 30*time.Minute),
+		pod("runner-a", "a-old", defaultLabels, 90*time.Minute),
+		pod("runner-b", "b-young", customLabels, 90*time.Minute),
+		pod("runner-b", "b-old", customLabels, 5*time.Hour),
+		pod("runner-b", "b-unselected", defaultLabels, 5*time.Hour),
+		pod("other", "other-old", defaultLabels, 5*time.Hour),
+	)
+
+	targets := []RunnerTarget{}
+	for _, target := range []struct {
+		namespace, selector string
+		maxAge              time.Duration
+	}{
+		{"runner-a", "", time.Hour},
+		{"runner-b", "runner=large", 4 * time.Hour},
+	} {
+		runnerTarget, err := NewRunnerTarget(target.namespace, target.selector, ExecutorRules{MaxAge: int64(target.maxAge.Seconds())})
+		if err != nil {
+			t.Fatalf("NewRunnerTarget() error = %v", err)
+		}
+		targets = append(targets, runnerTarget)
+	}
+
+	err := GitlabExecutors(context.TODO(), clientset, targets, false)
+	if err != nil {
+		t.Fatalf("GitlabExecutors() error = %v", err)
+	}
+
+	deleted := []string{}
+	for _, action := range clientset.Actions() {
+		if deleteAction, ok := action.(k8stesting.DeleteAction); ok {
+			deleted = append(deleted, deleteAction.GetNamespace()+"/"+deleteAction.GetName())
+		}
+	}
+	sort.Strings(deleted)
+
+	want := []string{"runner-a/a-old", "runner-b/b-old"}
+	if strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
@@ -0,0 +1,218 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrafficAgeSource is the name of the age source measuring the time since the last request to a namespace
+const TrafficAgeSource = "traffic"
+
+// DefaultTrafficQuery is the request rate of the ingresses of a namespace as reported by ingress-nginx,
+// "$namespace" is replaced by the name of the namespace
+const DefaultTrafficQuery = `sum(rate(nginx_ingress_controller_requests{exported_namespace="$namespace"}[5m]))`
+
+// maxTrafficSamples is the number of samples per series a prometheus range query returns at most
+const maxTrafficSamples = 11000
+
+// rangeSelector matches the range of a range vector selector or subquery, e.g. "[5m]" or "[1h:1m]"
+var rangeSelector = regexp.MustCompile(`\[((?:\d+(?:ms|[smhdwy]))+)(?::[^\]]*)?\]`)
+
+// rangeUnit matches the parts of a prometheus duration, e.g. "1h" and "30m" of "1h30m"
+var rangeUnit = regexp.MustCompile(`(\d+)(ms|[smhdwy])`)
+
+var rangeUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// shortestRange returns the shortest range selected by the query, a step longer than it skips the requests between the
+// samples, false if the query has no range
+func shortestRange(query string) (time.Duration, bool) {
+	shortest := time.Duration(0)
+	found := false
+	for _, selector := range rangeSelector.FindAllStringSubmatch(query, -1) {
+		duration := time.Duration(0)
+		for _, part := range rangeUnit.FindAllStringSubmatch(selector[1], -1) {
+			n, err := strconv.ParseInt(part[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			duration += time.Duration(n) * rangeUnits[part[2]]
+		}
+		if !found || duration < shortest {
+			shortest = duration
+			found = true
+		}
+	}
+	return shortest, found
+}
+
+// TrafficAges reads the request rates of namespaces from a prometheus compatible HTTP API
+type TrafficAges struct {
+	baseURL    string
+	query      string
+	window     time.Duration
+	step       time.Duration
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewTrafficAges creates a client for the API at baseURL, e.g. "http://prometheus.monitoring:9090", the query is
+// evaluated over the window at every step
+func NewTrafficAges(baseURL, query string, window, step, timeout time.Duration) *TrafficAges {
+	return &TrafficAges{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		query:      query,
+		window:     window,
+		step:       step,
+		httpClient: &http.Client{Timeout: timeout},
+		now:        time.Now,
+	}
+}
+
+// Youngest reports the seconds since the last sample with requests, a namespace whose series have no requests within the
+// window is idle for at least the window, a namespace without any series isn't found as it may not be served by an ingress,
+// a namespace is never idle for longer than it exists
+func (t *TrafficAges) Youngest(ctx context.Context, api KubernetesAPI) (ResourceAge, bool, error) {
+	now := t.now()
+	ns := api.Namespace()
+	name := ns.ObjectMeta.Name
+	created := max(0, now.Sub(ns.ObjectMeta.CreationTimestamp.Time)/time.Second)
+
+	lastRequest, found, err := t.lastRequest(ctx, name, now)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to query the traffic of namespace %s: %v", name, err)
+	}
+
+	if !found {
+		return 0, false, nil
+	}
+
+	if lastRequest.IsZero() {
+		return ResourceAge(min(created, t.window/time.Second)), true, nil
+	}
+
+	return ResourceAge(min(created, max(0, now.Sub(lastRequest)/time.Second))), true, nil
+}
+
+// lastRequest returns the time of the latest sample above zero of any series, it is zero if there is none, found is false
+// if the query returned no series at all
+func (t *TrafficAges) lastRequest(ctx context.Context, namespace string, now time.Time) (time.Time, bool, error) {
+	// namespace names are DNS labels, they can't break out of a quoted label value
+	query := url.Values{
+		"query": {strings.ReplaceAll(t.query, "$namespace", namespace)},
+		"start": {formatUnixSeconds(now.Add(-t.window))},
+		"end":   {formatUnixSeconds(now)},
+		"step":  {strconv.FormatFloat(t.step.Seconds(), 'f', -1, 64)},
+	}
+
+	result := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			// the result is decoded by its type
+			Result json.RawMessage `json:"result"`
+		} `json:"data"`
+	}{}
+
+	err := t.request(ctx, "/api/v1/query_range?"+query.Encode(), &result)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if result.Status != "success" {
+		return time.Time{}, false, fmt.Errorf("query failed: %s", result.Error)
+	}
+
+	if result.Data.ResultType != "matrix" {
+		return time.Time{}, false, fmt.Errorf("unexpected result type \"%s\", the query has to return a range vector", result.Data.ResultType)
+	}
+
+	matrix := []struct {
+		Values [][2]any `json:"values"`
+	}{}
+	err = json.Unmarshal(result.Data.Result, &matrix)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid range vector: %v", err)
+	}
+
+	latest := time.Time{}
+	for _, series := range matrix {
+		for _, sample := range series.Values {
+			timestamp, ok := sample[0].(float64)
+			if !ok {
+				return time.Time{}, false, fmt.Errorf("invalid sample timestamp %v", sample[0])
+			}
+
+			value, ok := sample[1].(string)
+			if !ok {
+				return time.Time{}, false, fmt.Errorf("invalid sample value %v", sample[1])
+			}
+
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(rate) || rate <= 0 {
+				continue
+			}
+
+			at := time.UnixMilli(int64(timestamp * 1000))
+			if at.After(latest) {
+				latest = at
+			}
+		}
+	}
+
+	return latest, len(matrix) > 0, nil
+}
+
+// request decodes the response into v, any status but 200 fails even if its body decodes, prometheus answers failed
+// queries with a JSON error and a 4xx or 5xx status
+func (t *TrafficAges) request(ctx context.Context, path string, v any) error {
+	err := t.do(ctx, path, v)
+	return apiError("prometheus", err)
+}
+
+func (t *TrafficAges) do(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		failure := struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}{}
+		err := json.NewDecoder(resp.Body).Decode(&failure)
+		if err == nil && failure.Status == "error" {
+			return fmt.Errorf("query failed with status %s: %s", resp.Status, failure.Error)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func formatUnixSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
@@ -0,0 +1,197 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTrafficAges_Youngest(t *testing.T) {
+	now := time.Date(2025, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		status    int
+		response  string
+		created   time.Duration
+		want      ResourceAge
+		wantFound bool
+		wantQuery string
+		wantErr   string
+	}{
+		{
+			name:      "last request",
+			status:    http.StatusOK,
+			response:  `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1760695200,"0.5"],[1760698800,"0"]]},{"metric":{},"values":[[1760688000,"2"],[1760699100,"NaN"]]}]}}`,
+			want:      7200,
+			wantFound: true,
+			wantQuery: `sum(rate(nginx_ingress_controller_requests{exported_namespace="shop-ci-feature"}[5m]))`,
+		},
+		{
+			name:      "no traffic",
+			status:    http.StatusOK,
+			response:  `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1760695200,"0"]]}]}}`,
+			want:      72 * 3600,
+			wantFound: true,
+		},
+		{
+			name:      "no traffic since the creation",
+			status:    http.StatusOK,
+			response:  `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1760695200,"0"]]}]}}`,
+			created:   2 * time.Hour,
+			want:      7200,
+			wantFound: true,
+		},
+		{
+			name:      "no series",
+			status:    http.StatusOK,
+			response:  `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantFound: false,
+		},
+		{
+			name:     "query error",
+			status:   http.StatusBadRequest,
+			response: `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			wantErr:  "query failed with status 400 Bad Request: parse error",
+		},
+		{
+			name:     "query error with status 200",
+			status:   http.StatusOK,
+			response: `{"status":"error","errorType":"execution","error":"query timed out"}`,
+			wantErr:  "query failed: query timed out",
+		},
+		{
+			name:     "success body with server error",
+			status:   http.StatusServiceUnavailable,
+			response: `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr:  "unexpected status 503",
+		},
+		{
+			name:     "scalar",
+			status:   http.StatusOK,
+			response: `{"status":"success","data":{"resultType":"scalar","result":[1760698800,"1"]}}`,
+			wantErr:  "unexpected result type",
+		},
+		{
+			name:     "unavailable",
+			status:   http.StatusBadGateway,
+			response: `bad gateway`,
+			wantErr:  "unexpected status 502",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/query_range" {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				if tt.wantQuery != "" && r.URL.Query().Get("query") != tt.wantQuery {
+					t.Errorf("query = %s, want %s", r.URL.Query().Get("query"), tt.wantQuery)
+				}
+				if r.URL.Query().Get("end") != "1760702400" || r.URL.Query().Get("step") != "300" {
+					t.Errorf("unexpected range %s", r.URL.RawQuery)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			traffic := NewTrafficAges(server.URL+"/", DefaultTrafficQuery, 72*time.Hour, 5*time.Minute, time.Second)
+			traffic.now = func() time.Time { return now }
+			ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}}
+			if tt.created > 0 {
+				ns.ObjectMeta.CreationTimestamp = metav1.NewTime(now.Add(-tt.created))
+			}
+			api := &KubernetesAPIMock{namespace: ns}
+
+			got, found, err := traffic.Youngest(context.Background(), api)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Youngest() error = %v, want it to contain %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Youngest() error = %v", err)
+			}
+			if found != tt.wantFound || got != tt.want {
+				t.Errorf("Youngest() = %d, %v, want %d, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+// TestContinuousIntegrationNamespaces_failedTraffic fails the traffic query of one namespace, the other namespace is collected anyway
+func TestContinuousIntegrationNamespaces_failedTraffic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("query"), "shop-ci-down") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1760695200,"0"]]}]}}`))
+	}))
+	defer server.Close()
+
+	created := metav1.NewTime(time.Now().Add(-72 * time.Hour))
+	clientset := fake.NewClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-down", CreationTimestamp: created}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-idle", CreationTimestamp: created}},
+	)
+
+	traffic := NewTrafficAges(server.URL+"/", DefaultTrafficQuery, 72*time.Hour, 5*time.Minute, time.Second)
+	sources, err := SelectAgeSources([]string{"namespace", TrafficAgeSource}, AgeSourceClients{Traffic: traffic})
+	if err != nil {
+		t.Fatalf("SelectAgeSources() error = %v", err)
+	}
+	rules := NamespaceRules{AgeSources: sources, MaxTestingAge: int64(60 * 60), MaxReviewAge: int64(60 * 60 * 24)}
+
+	err = ContinuousIntegrationNamespaces(context.Background(), clientset, rules, false)
+	if err == nil || !strings.Contains(err.Error(), "kept 1 namespaces whose age sources failed: shop-ci-down") {
+		t.Errorf("ContinuousIntegrationNamespaces() error = %v, want shop-ci-down to be kept", err)
+	}
+
+	if got := deletedNames(clientset.Actions()); strings.Join(got, ",") != "shop-ci-idle" {
+		t.Errorf("deleted = %v, want the idle namespace after the failed query", got)
+	}
+}
+
+// TestTrafficAges_overridesRollouts deletes a namespace without requests although it was rolled out recently
+func TestTrafficAges_overridesRollouts(t *testing.T) {
+	now := time.Now()
+	lastRequest := now.Add(-48 * time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[%d,"0.5"],[%d,"0"]]}]}}`, lastRequest, now.Unix())
+	}))
+	defer server.Close()
+
+	traffic := NewTrafficAges(server.URL, DefaultTrafficQuery, 72*time.Hour, 5*time.Minute, time.Second)
+	sources, err := SelectAgeSources([]string{"namespace", "deployment", TrafficAgeSource}, AgeSourceClients{Traffic: traffic})
+	if err != nil {
+		t.Fatalf("SelectAgeSources() error = %v", err)
+	}
+	rules := NamespaceRules{AgeSources: sources, MaxTestingAge: int64(60 * 60), MaxReviewAge: int64(60 * 60 * 24)}
+
+	api := &KubernetesAPIMock{
+		namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", CreationTimestamp: metav1.NewTime(now.Add(-72 * time.Hour))}},
+		deployments: []appsv1.Deployment{
+			{ObjectMeta: metav1.ObjectMeta{Name: "web", CreationTimestamp: metav1.NewTime(now.Add(-time.Minute))}},
+		},
+	}
+
+	decision, err := shouldDeleteNamespace(context.Background(), api, rules, false)
+	if err != nil {
+		t.Fatalf("shouldDeleteNamespace() error = %v", err)
+	}
+	if !decision.Delete || decision.AgeSource != TrafficAgeSource || decision.Age < 48*3600 {
+		t.Errorf("shouldDeleteNamespace() = %+v, want the deletion by the last request 48h ago", decision)
+	}
+}
@@ -0,0 +1,139 @@
+package gc
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ScheduledForDeletionLabel marks a namespace as scheduled for deletion for developers and selectors, removing it rescues the namespace
+const ScheduledForDeletionLabel = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/scheduled-for-deletion"
+
+// ScheduledAtAnnotation is owned by the gc, it holds the unix time a namespace became deletable and tells a removed label from
+// a namespace never scheduled
+const ScheduledAtAnnotation = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/scheduled-at"
+
+// RescuedAtAnnotation is owned by the gc, it holds the unix time the label of a scheduled namespace was found removed,
+// the rescue counts like a resource created at that time
+const RescuedAtAnnotation = "k8s-gitlab-gc.utopia-planitia.non-existing-tld/rescued-at"
+
+// applyWarningPeriod turns the first deletion of a namespace into scheduling it, the namespace is deleted once it stayed deletable
+// for the warning period, namespaces no longer deletable are unscheduled and namespaces whose label was removed are rescued,
+// dry runs only report what would be done
+func (r NamespaceRules) applyWarningPeriod(ns v1.Namespace, decision *Decision, now time.Time, dryRun bool) {
+	if r.WarningPeriod <= 0 || decision.Reason == reasonNotCI || decision.Reason == reasonSystem || decision.Reason == reasonTerminating {
+		return
+	}
+
+	_, labeled := ns.ObjectMeta.Labels[ScheduledForDeletionLabel]
+	value, annotated := ns.ObjectMeta.Annotations[ScheduledAtAnnotation]
+
+	// policies in dry run mode only log deletions, labeling the namespace would be a change
+	dryRun = dryRun || decision.DryRun
+
+	if !decision.Delete {
+		if !labeled && !annotated {
+			return
+		}
+		if dryRun {
+			decision.step("warning period", "would remove the label %s, the namespace is no longer deletable", ScheduledForDeletionLabel)
+			return
+		}
+		decision.Unschedule = true
+		decision.step("warning period", "removing the label %s, the namespace is no longer deletable", ScheduledForDeletionLabel)
+		return
+	}
+
+	if annotated && !labeled {
+		decision.Delete = false
+		decision.Reason = reasonRescued
+		if dryRun {
+			decision.step("warning period", "the label %s was removed, would rescue the namespace", ScheduledForDeletionLabel)
+			return
+		}
+		decision.Rescue = true
+		decision.step("warning period", "the label %s was removed, rescuing the namespace", ScheduledForDeletionLabel)
+		return
+	}
+
+	scheduledAt, err := strconv.ParseInt(value, 10, 64)
+	if !annotated || err != nil {
+		decision.Delete = false
+		decision.Reason = reasonScheduled
+		decision.Expires = true
+		decision.ExpiresIn = r.WarningPeriod
+		if dryRun {
+			decision.step("warning period", "would schedule the deletion by the label %s, deletes after %ds", ScheduledForDeletionLabel, r.WarningPeriod)
+			return
+		}
+		decision.Schedule = true
+		decision.step("warning period", "scheduling the deletion in %ds by the label %s", r.WarningPeriod, ScheduledForDeletionLabel)
+		return
+	}
+
+	remaining := r.WarningPeriod - (now.Unix() - scheduledAt)
+	if remaining > 0 {
+		decision.Delete = false
+		decision.Reason = reasonScheduled
+		decision.Expires = true
+		decision.ExpiresIn = remaining
+		decision.step("warning period", "scheduled at %s, %ds left", time.Unix(scheduledAt, 0).UTC().Format(time.RFC3339), remaining)
+		return
+	}
+
+	decision.step("warning period", "scheduled at %s, passed", time.Unix(scheduledAt, 0).UTC().Format(time.RFC3339))
+}
+
+// scheduleDeletion sets or removes the label and annotation of a namespace as decided by applyWarningPeriod, a rescue removes
+// the annotation and resets the age of the namespace by RescuedAtAnnotation
+func (r NamespaceRules) scheduleDeletion(ctx context.Context, clientset kubernetes.Interface, ns v1.Namespace, decision Decision, now time.Time) error {
+	labels := map[string]any{}
+	annotations := map[string]any{}
+	switch {
+	case decision.Schedule:
+		labels[ScheduledForDeletionLabel] = strconv.FormatInt(now.Unix(), 10)
+		annotations[ScheduledAtAnnotation] = strconv.FormatInt(now.Unix(), 10)
+	case decision.Unschedule:
+		labels[ScheduledForDeletionLabel] = nil
+		annotations[ScheduledAtAnnotation] = nil
+	case decision.Rescue:
+		annotations[ScheduledAtAnnotation] = nil
+		annotations[RescuedAtAnnotation] = strconv.FormatInt(now.Unix(), 10)
+	default:
+		return nil
+	}
+
+	if len(labels) > 0 {
+		err := patchNamespaceMetadata(ctx, clientset, ns.ObjectMeta.Name, "labels", labels)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := patchNamespaceMetadata(ctx, clientset, ns.ObjectMeta.Name, "annotations", annotations)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("scheduled namespace", "namespace", ns.ObjectMeta.Name, "labels", labels, "annotations", annotations)
+	return nil
+}
+
+// rescuedAge returns the seconds since the rescue recorded in RescuedAtAnnotation, it is false if the namespace was never rescued
+func rescuedAge(annotations map[string]string, now time.Time) (int64, bool) {
+	value, ok := annotations[RescuedAtAnnotation]
+	if !ok {
+		return 0, false
+	}
+
+	rescuedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(0, now.Unix()-rescuedAt), true
+}
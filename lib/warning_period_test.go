@@ -0,0 +1,329 @@
+package gc
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceRules_applyWarningPeriod(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rules := NamespaceRules{WarningPeriod: 3600}
+	labeled := func(value string) v1.Namespace {
+		return v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "shop-ci-feature",
+			Labels:      map[string]string{ScheduledForDeletionLabel: value},
+			Annotations: map[string]string{ScheduledAtAnnotation: value},
+		}}
+	}
+	expired := Decision{Delete: true, Reason: reasonExpired, Rule: "class=review"}
+
+	tests := []struct {
+		name     string
+		rules    NamespaceRules
+		ns       v1.Namespace
+		decision Decision
+		dryRun   bool
+		want     Decision
+	}{
+		{
+			name:     "disabled",
+			rules:    NamespaceRules{},
+			ns:       v1.Namespace{},
+			decision: expired,
+			want:     expired,
+		},
+		{
+			name:     "first deletable",
+			rules:    rules,
+			ns:       v1.Namespace{},
+			decision: expired,
+			want:     Decision{Reason: reasonScheduled, Rule: "class=review", Schedule: true, Expires: true, ExpiresIn: 3600},
+		},
+		{
+			name:     "within warning period",
+			rules:    rules,
+			ns:       labeled(unix(now.Add(-20 * time.Minute))),
+			decision: expired,
+			want:     Decision{Reason: reasonScheduled, Rule: "class=review", Expires: true, ExpiresIn: 2400},
+		},
+		{
+			name:     "warning period passed",
+			rules:    rules,
+			ns:       labeled(unix(now.Add(-2 * time.Hour))),
+			decision: expired,
+			want:     expired,
+		},
+		{
+			name:     "label removed",
+			rules:    rules,
+			ns:       v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", Annotations: map[string]string{ScheduledAtAnnotation: unix(now.Add(-20 * time.Minute))}}},
+			decision: expired,
+			want:     Decision{Reason: reasonRescued, Rule: "class=review", Rescue: true},
+		},
+		{
+			name:     "labeled by an older version",
+			rules:    rules,
+			ns:       v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", Labels: map[string]string{ScheduledForDeletionLabel: unix(now.Add(-2 * time.Hour))}}},
+			decision: expired,
+			want:     Decision{Reason: reasonScheduled, Rule: "class=review", Schedule: true, Expires: true, ExpiresIn: 3600},
+		},
+		{
+			name:     "invalid annotation",
+			rules:    rules,
+			ns:       labeled("yesterday"),
+			decision: expired,
+			want:     Decision{Reason: reasonScheduled, Rule: "class=review", Schedule: true, Expires: true, ExpiresIn: 3600},
+		},
+		{
+			name:     "opted out while scheduled",
+			rules:    rules,
+			ns:       labeled(unix(now.Add(-20 * time.Minute))),
+			decision: Decision{Reason: reasonOptedOut, Rule: "optOutAnnotations=disable-automatic-garbage-collection"},
+			want:     Decision{Reason: reasonOptedOut, Rule: "optOutAnnotations=disable-automatic-garbage-collection", Unschedule: true},
+		},
+		{
+			name:     "policy in dry run mode",
+			rules:    rules,
+			ns:       v1.Namespace{},
+			decision: Decision{Delete: true, Reason: reasonExpired, Rule: "policy=preview", DryRun: true},
+			want:     Decision{Reason: reasonScheduled, Rule: "policy=preview", DryRun: true, Expires: true, ExpiresIn: 3600},
+		},
+		{
+			name:     "first deletable in dry run",
+			rules:    rules,
+			ns:       v1.Namespace{},
+			decision: expired,
+			dryRun:   true,
+			want:     Decision{Reason: reasonScheduled, Rule: "class=review", Expires: true, ExpiresIn: 3600},
+		},
+		{
+			name:     "label removed in dry run",
+			rules:    rules,
+			ns:       v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", Annotations: map[string]string{ScheduledAtAnnotation: unix(now.Add(-20 * time.Minute))}}},
+			decision: expired,
+			dryRun:   true,
+			want:     Decision{Reason: reasonRescued, Rule: "class=review"},
+		},
+		{
+			name:     "opted out while scheduled in dry run",
+			rules:    rules,
+			ns:       labeled(unix(now.Add(-20 * time.Minute))),
+			decision: Decision{Reason: reasonOptedOut, Rule: "optOutAnnotations=disable-automatic-garbage-collection"},
+			dryRun:   true,
+			want:     Decision{Reason: reasonOptedOut, Rule: "optOutAnnotations=disable-automatic-garbage-collection"},
+		},
+		{
+			name:     "not a ci namespace",
+			rules:    rules,
+			ns:       labeled(unix(now.Add(-20 * time.Minute))),
+			decision: Decision{Reason: reasonNotCI},
+			want:     Decision{Reason: reasonNotCI},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.decision
+			tt.rules.applyWarningPeriod(tt.ns, &got, now, tt.dryRun)
+			got.Steps = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyWarningPeriod() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceRules_scheduleDeletion(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rules := NamespaceRules{LastUsedAtAnnotation: "last-used-at"}
+	scheduled := unix(now.Add(-20 * time.Minute))
+
+	tests := []struct {
+		name            string
+		labels          map[string]string
+		annotations     map[string]string
+		decision        Decision
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "schedule",
+			decision:        Decision{Reason: reasonScheduled, Schedule: true},
+			wantLabels:      map[string]string{ScheduledForDeletionLabel: unix(now)},
+			wantAnnotations: map[string]string{ScheduledAtAnnotation: unix(now)},
+		},
+		{
+			name:            "unschedule",
+			labels:          map[string]string{ScheduledForDeletionLabel: scheduled, "team": "shop"},
+			annotations:     map[string]string{ScheduledAtAnnotation: scheduled},
+			decision:        Decision{Reason: reasonOptedOut, Unschedule: true},
+			wantLabels:      map[string]string{"team": "shop"},
+			wantAnnotations: nil,
+		},
+		{
+			name:            "rescue",
+			labels:          map[string]string{"team": "shop"},
+			annotations:     map[string]string{ScheduledAtAnnotation: scheduled},
+			decision:        Decision{Reason: reasonRescued, Rescue: true},
+			wantLabels:      map[string]string{"team": "shop"},
+			wantAnnotations: map[string]string{RescuedAtAnnotation: unix(now)},
+		},
+		{
+			name:            "unchanged",
+			labels:          map[string]string{ScheduledForDeletionLabel: scheduled},
+			annotations:     map[string]string{ScheduledAtAnnotation: scheduled},
+			decision:        Decision{Reason: reasonScheduled},
+			wantLabels:      map[string]string{ScheduledForDeletionLabel: scheduled},
+			wantAnnotations: map[string]string{ScheduledAtAnnotation: scheduled},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature", Labels: tt.labels, Annotations: tt.annotations}}
+			clientset := fake.NewClientset(ns.DeepCopy())
+
+			err := rules.scheduleDeletion(context.Background(), clientset, ns, tt.decision, now)
+			if err != nil {
+				t.Fatalf("scheduleDeletion() error = %v", err)
+			}
+
+			got, err := clientset.CoreV1().Namespaces().Get(context.Background(), ns.ObjectMeta.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.ObjectMeta.Labels) != len(tt.wantLabels) || (len(tt.wantLabels) > 0 && !reflect.DeepEqual(got.ObjectMeta.Labels, tt.wantLabels)) {
+				t.Errorf("labels = %v, want %v", got.ObjectMeta.Labels, tt.wantLabels)
+			}
+			if len(got.ObjectMeta.Annotations) != len(tt.wantAnnotations) || (len(tt.wantAnnotations) > 0 && !reflect.DeepEqual(got.ObjectMeta.Annotations, tt.wantAnnotations)) {
+				t.Errorf("annotations = %v, want %v", got.ObjectMeta.Annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+// TestNamespaceRules_warningPeriodRescue removes the label between two evaluations, the second one must keep the namespace
+func TestNamespaceRules_warningPeriodRescue(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rules := NamespaceRules{WarningPeriod: 3600}
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop-ci-feature"}})
+	namespaces := clientset.CoreV1().Namespaces()
+
+	evaluate := func(at time.Time) Decision {
+		t.Helper()
+		ns, err := namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision := Decision{Delete: true, Reason: reasonExpired, Rule: "class=review"}
+		rules.applyWarningPeriod(*ns, &decision, at, false)
+		err = rules.scheduleDeletion(context.Background(), clientset, *ns, decision, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decision
+	}
+
+	if decision := evaluate(now); decision.Reason != reasonScheduled {
+		t.Fatalf("first evaluation = %s, want %s", decision.Reason, reasonScheduled)
+	}
+
+	ns, err := namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(ns.ObjectMeta.Labels, ScheduledForDeletionLabel)
+	_, err = namespaces.Update(context.Background(), ns, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := evaluate(now.Add(2 * time.Hour))
+	if decision.Delete || decision.Reason != reasonRescued {
+		t.Errorf("second evaluation = %s (delete: %v), want %s", decision.Reason, decision.Delete, reasonRescued)
+	}
+
+	ns, err = namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := ns.ObjectMeta.Annotations[ScheduledAtAnnotation]; found {
+		t.Errorf("annotation %s is left after the rescue", ScheduledAtAnnotation)
+	}
+	if ns.ObjectMeta.Annotations[RescuedAtAnnotation] != unix(now.Add(2*time.Hour)) {
+		t.Errorf("%s = %q, want the time of the rescue", RescuedAtAnnotation, ns.ObjectMeta.Annotations[RescuedAtAnnotation])
+	}
+}
+
+// TestNamespaceRules_rescueResetsAge evaluates a rescued namespace again, without extensions the rescue alone keeps it
+func TestNamespaceRules_rescueResetsAge(t *testing.T) {
+	rules := NamespaceRules{
+		AgeSources:    builtinAgeSources("namespace"),
+		MaxTestingAge: int64(60 * 60),
+		MaxReviewAge:  int64(60 * 60 * 24),
+		WarningPeriod: 3600,
+	}
+	clientset := fake.NewClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "shop-ci-feature",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-48 * time.Hour)),
+	}})
+	namespaces := clientset.CoreV1().Namespaces()
+
+	evaluate := func() Decision {
+		t.Helper()
+		ns, err := namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision, err := shouldDeleteNamespace(context.Background(), NewKubernetesClient(clientset, *ns), rules, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rules.scheduleDeletion(context.Background(), clientset, *ns, decision, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decision
+	}
+
+	if decision := evaluate(); decision.Reason != reasonScheduled {
+		t.Fatalf("first evaluation = %s, want %s", decision.Reason, reasonScheduled)
+	}
+
+	ns, err := namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(ns.ObjectMeta.Labels, ScheduledForDeletionLabel)
+	_, err = namespaces.Update(context.Background(), ns, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decision := evaluate(); decision.Reason != reasonRescued {
+		t.Fatalf("second evaluation = %s, want %s", decision.Reason, reasonRescued)
+	}
+
+	decision := evaluate()
+	if decision.Delete || decision.Schedule || decision.Reason != reasonTooYoung || decision.AgeSource != ageFromRescue {
+		t.Errorf("evaluation after the rescue = %s (delete: %v, schedule: %v, age source: %s), want %s by the rescue",
+			decision.Reason, decision.Delete, decision.Schedule, decision.AgeSource, reasonTooYoung)
+	}
+
+	ns, err = namespaces.Get(context.Background(), "shop-ci-feature", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := ns.ObjectMeta.Labels[ScheduledForDeletionLabel]; found {
+		t.Errorf("label %s is set again after the rescue", ScheduledForDeletionLabel)
+	}
+}
+
+func unix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
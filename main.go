@@ -2,114 +2,395 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-var availableAgesFuncsMap = map[string]gc.YoungestResourceAgeFunc{
-	"namespace":   gc.NamespaceAge,
-	"pod":         gc.YoungestPodAge,
-	"deployment":  gc.YoungestDeploymentAge,
-	"statefulset": gc.YoungestStatefulsetAge,
-	"daemonset":   gc.YoungestDaemonsetAge,
-	"cronjob":     gc.YoungestCronjobAge,
-}
+// subcommands, the garbage collection runs if no subcommand is given
+const (
+	explainCommand = "explain"
+	planCommand    = "plan"
+	applyCommand   = "apply"
+	wakeCommand    = "wake"
+)
 
 func main() {
-	var dryRun = flag.Bool("dry-run", false, "execute in dry-run mode - no changes will be applied")
-	var kubeconfig = flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file")
-	var gitlabRunnerNamespace = flag.String("gitlabRunnerNamespace", "gitlab-runner", "namespace to remove gitlab executors from")
-	var protectedBranches = flag.String("protectedBranches", "develop,master,main,preview,review,stage,staging", "comma separated list of substrings to mark a namespace as protected from deletion")
-	var maxGitlabExecutorAge = flag.Int64("maxGitlabExecutorAge", 70*60, "max age for gitlab executor pods in seconds")
-	var maxReviewNamespaceAge = flag.Int64("maxReviewNamespaceAge", 60*60*24*2, "max age for review namespaces in seconds")
-	var maxBuildNamespaceAge = flag.Int64("maxBuildNamespaceAge", 60*60*2, "max age for e2e testing namespaces in seconds")
-	var optOutAnnotations = flag.String("optOutAnnotations", "disable-automatic-garbage-collection,k8s-gitlab-gc.utopia-planitia.non-existing-tld/disable-automatic-garbage-collection", "comma separated list of annotations to protect namespaces from deletion, annotations need to be set to the string 'true'")
-	var ttlAnnotation = flag.String("ttlAnnotation", "k8s-gitlab-gc.utopia-planitia.non-existing-tld/ns-ttl-duration", "name of the annotation (key) to define the time to life for for the namespace")
-	var onlyUseAgesOf = flag.String("onlyUseAgesOf", "namespace,deployment,statefulset,daemonset,cronjob", fmt.Sprintf("comma separated list of kubernetes resources to use for age evaluation: \"%s\"", strings.Join(keysFrom(availableAgesFuncsMap), ",")))
-
-	flag.Parse()
-
-	log.Printf("dryRun: %v\n", *dryRun)
-	log.Printf("kubeconfig: %v\n", *kubeconfig)
-	log.Printf("gitlabRunnerNamespace: %v\n", *gitlabRunnerNamespace)
-	log.Printf("protectedBranches: %v\n", *protectedBranches)
-	log.Printf("maxGitlabExecutorAge: %v\n", *maxGitlabExecutorAge)
-	log.Printf("maxReviewNamespaceAge: %v\n", *maxReviewNamespaceAge)
-	log.Printf("maxBuildNamespaceAge: %v\n", *maxBuildNamespaceAge)
-	log.Printf("optOutAnnotations: %v\n", *optOutAnnotations)
-	log.Printf("ttlAnnotation: %v\n", *ttlAnnotation)
-	log.Printf("onlyUseAgesOf: %v\n", *onlyUseAgesOf)
-
-	selectedAgesFuncs, err := selectResourceAgeFuncs(*onlyUseAgesOf, availableAgesFuncsMap)
+	command, args := subcommand(os.Args[1:])
+	flags := registerCommandFlags(command)
+	config, args := parseConfig(args)
+
+	logOutput := os.Stdout
+	if command == planCommand {
+		// the plan is written to stdout
+		logOutput = os.Stderr
+	}
+	slog.SetDefault(newLogger(logOutput, config.LogFormat))
+
+	switch command {
+	case explainCommand:
+		runExplain(config, args)
+		return
+	case planCommand:
+		runPlan(config, *flags.output)
+		return
+	case applyCommand:
+		runApply(config, *flags.plan)
+		return
+	case wakeCommand:
+		runWake(config, args)
+		return
+	}
+
+	logConfig(config)
+
+	k8s, policyClient := newClients(config)
+	rules, runnerTargets := newRules(config, k8s)
+	events := withEvents(config, k8s, &rules, runnerTargets)
+	withArchive(config, k8s, &rules)
+
+	var err error
+	var leaderElection *gc.LeaderElectionConfig
+	if config.LeaderElection.Enabled {
+		le := config.LeaderElection.LeaderElectionConfig()
+		leaderElection = &le
+
+		if leaderElection.LeaseNamespace == "" {
+			leaderElection.LeaseNamespace = inClusterNamespace()
+		}
+
+		if leaderElection.Identity == "" {
+			leaderElection.Identity, err = os.Hostname()
+			if err != nil {
+				log.Fatalf("failed to determine leader election identity: %v", err)
+			}
+		}
+	}
+
+	switch config.Mode {
+	case gc.ModeOneshot:
+		runOneshot(k8s, policyClient, config, rules, runnerTargets, events)
+	case gc.ModeController:
+		runController(k8s, policyClient, config, rules, runnerTargets, leaderElection)
+	}
+}
+
+// newRules builds the namespace rules and runner targets, both share the gitlab client
+func newRules(config gc.Config, k8s kubernetes.Interface) (gc.NamespaceRules, []gc.RunnerTarget) {
+	clients := gc.AgeSourceClients{Resources: gc.NewResourceAges(k8s.Discovery(), newDynamicClient(config))}
+	if config.Traffic.PrometheusURL != "" {
+		clients.Traffic = gc.NewTrafficAges(config.Traffic.PrometheusURL, config.Traffic.Query, config.Traffic.Window.Duration, config.Traffic.Step.Duration, config.Traffic.Timeout.Duration)
+	}
+	rules, err := config.Namespaces.NamespaceRules(clients)
 	if err != nil {
-		log.Fatalf("couldn't validate 'onlyUseAgesOf' flag: %v", err)
+		log.Fatalf("couldn't validate namespace rules: %v", err)
+	}
+	// the gc never deletes the namespace it runs in or holds its lease in
+	rules.SystemNamespaces = []string{inClusterNamespace()}
+	if config.LeaderElection.LeaseNamespace != "" {
+		rules.SystemNamespaces = append(rules.SystemNamespaces, config.LeaderElection.LeaseNamespace)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
+	runnerTargets, err := config.GitlabExecutors.RunnerTargets()
+	if err != nil {
+		log.Fatalf("couldn't validate gitlab runner targets: %v", err)
+	}
+
+	if config.Gitlab.URL != "" {
+		token := config.Gitlab.Token
+		if token == "" {
+			token = os.Getenv("GITLAB_TOKEN")
+		}
+		gitlab := gc.NewGitlabClient(config.Gitlab.URL, token, config.Gitlab.Timeout.Duration)
+		rules.Gitlab = gitlab
+		for i := range runnerTargets {
+			runnerTargets[i].Rules.Gitlab = gitlab
+		}
+
+		if config.Gitlab.StopEnvironments {
+			rules.StopEnvironmentAnnotation = config.Gitlab.EnvironmentAnnotation
+		}
+	}
+
+	return rules, runnerTargets
+}
 
-	k8s, err := provideKubernetesClient(*kubeconfig)
+// newClients connects to kubernetes, the dynamic client is only created to read namespace gc policies
+func newClients(config gc.Config) (kubernetes.Interface, dynamic.Interface) {
+	k8sConfig, err := provideKubernetesConfig(config.Kubeconfig)
 	if err != nil {
 		log.Fatalf("failed initilize kubernetes client: %v", err)
 	}
 
-	err = gc.GitlabExecutors(ctx, k8s.CoreV1().Pods(*gitlabRunnerNamespace), *maxGitlabExecutorAge, *dryRun)
+	k8s, err := kubernetes.NewForConfig(k8sConfig)
 	if err != nil {
-		log.Fatalf("failed to clean up gitlab executors: %v", err)
-	}
-
-	err = gc.ContinuousIntegrationNamespaces(
-		ctx,
-		k8s,
-		selectedAgesFuncs,
-		strings.Split(*protectedBranches, ","),
-		strings.Split(*optOutAnnotations, ","),
-		*ttlAnnotation,
-		*maxBuildNamespaceAge,
-		*maxReviewNamespaceAge,
-		*dryRun,
-	)
+		log.Fatalf("failed initilize kubernetes client: %v", err)
+	}
+
+	var policyClient dynamic.Interface
+	if config.Namespaces.UsePolicies {
+		policyClient = newDynamicClient(config)
+	}
+
+	return k8s, policyClient
+}
+
+// newDynamicClient connects to kubernetes for resources without typed clients
+func newDynamicClient(config gc.Config) dynamic.Interface {
+	k8sConfig, err := provideKubernetesConfig(config.Kubeconfig)
 	if err != nil {
-		log.Fatalf("failed to clean up ci namespaces: %v", err)
+		log.Fatalf("failed initilize kubernetes client: %v", err)
 	}
+
+	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	if err != nil {
+		log.Fatalf("failed initilize kubernetes dynamic client: %v", err)
+	}
+
+	return dynamicClient
 }
 
-func provideKubernetesClient(kubeconfig string) (*kubernetes.Clientset, error) {
-	k8sConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+// withPolicies adds the namespace gc policies to the rules, the rules are returned unchanged if policies are not used
+func withPolicies(ctx context.Context, policyClient dynamic.Interface, rules gc.NamespaceRules) gc.NamespaceRules {
+	if policyClient == nil {
+		return rules
+	}
+
+	policies, err := gc.ListNamespaceGCPolicies(ctx, policyClient)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse kubernetes configuration: %v", err)
+		log.Fatalf("failed to list namespace gc policies: %v", err)
 	}
-	return kubernetes.NewForConfig(k8sConfig)
+
+	return rules.WithPolicies(policies)
+}
+
+// withEvents records events for the rules and the runner targets if enabled, the returned recorder is nil otherwise
+func withEvents(config gc.Config, k8s kubernetes.Interface, rules *gc.NamespaceRules, runnerTargets []gc.RunnerTarget) *gc.Events {
+	if !config.Events.Enabled {
+		return nil
+	}
+
+	events := gc.NewEvents(k8s)
+	rules.Events = events
+	rules.DeletionNotice = int64(config.Events.DeletionNotice.Seconds())
+	for i := range runnerTargets {
+		runnerTargets[i].Rules.Events = events
+	}
+
+	return events
 }
 
-func selectResourceAgeFuncs(onlyUseAgesOf string, ageFuncsMap map[string]gc.YoungestResourceAgeFunc) ([]gc.YoungestResourceAgeFunc, error) {
-	onlyUseAgesOfList := strings.Split(onlyUseAgesOf, ",")
+// withArchive archives namespaces before they are deleted if enabled
+func withArchive(config gc.Config, k8s kubernetes.Interface, rules *gc.NamespaceRules) {
+	if !config.Archive.Enabled {
+		return
+	}
 
-	selectedFuncs := []gc.YoungestResourceAgeFunc{}
-	for _, maybeKey := range onlyUseAgesOfList {
-		ageFn, ok := ageFuncsMap[maybeKey]
-		if !ok {
-			validKeys := keysFrom(ageFuncsMap)
-			return []gc.YoungestResourceAgeFunc{}, fmt.Errorf("the passed key \"%s\" is not a valid key, valid options are: \"%s\"", maybeKey, strings.Join(validKeys, ","))
+	var store gc.ArchiveStore
+	var err error
+	if config.Archive.Directory != "" {
+		store, err = gc.NewDirectoryStore(config.Archive.Directory)
+	} else {
+		s3 := config.Archive.S3
+		accessKeyID := s3.AccessKeyID
+		if accessKeyID == "" {
+			accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		secretAccessKey := s3.SecretAccessKey
+		if secretAccessKey == "" {
+			secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 		}
+		store, err = gc.NewS3Store(s3.Endpoint, s3.Bucket, s3.Prefix, s3.Region, accessKeyID, secretAccessKey, s3.Timeout.Duration)
+	}
+	if err != nil {
+		log.Fatalf("failed to initialize archive store: %v", err)
+	}
+
+	archiver := gc.NewArchiver(k8s.Discovery(), newDynamicClient(config), store)
+	archiver.ExcludeSecrets = config.Archive.ExcludeSecrets
+	archiver.Retention = config.Archive.Retention.Duration
+	archiver.Timeout = config.Archive.Timeout.Duration
+	rules.Archiver = archiver
+}
+
+// subcommand splits off the subcommand if the first argument is one
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", args
+	}
+
+	switch args[0] {
+	case explainCommand, planCommand, applyCommand, wakeCommand:
+		return args[0], args[1:]
+	}
+
+	return "", args
+}
+
+// logConfig logs the effective configuration before the garbage collection starts
+func logConfig(config gc.Config) {
+	log.Printf("dryRun: %v\n", config.DryRun)
+	log.Printf("kubeconfig: %v\n", config.Kubeconfig)
+	log.Printf("gitlabURL: %v\n", config.Gitlab.URL)
+	log.Printf("gitlabTimeout: %v\n", config.Gitlab.Timeout)
+	log.Printf("gitlabStopEnvironments: %v\n", config.Gitlab.StopEnvironments)
+	log.Printf("trafficPrometheusURL: %v\n", config.Traffic.PrometheusURL)
+	log.Printf("trafficWindow: %v\n", config.Traffic.Window)
+	log.Printf("gitlabRunnerNamespace: %v\n", config.GitlabExecutors.RunnerNamespace)
+	log.Printf("protectedBranches: %v\n", strings.Join(config.Namespaces.ProtectedBranches, ","))
+	log.Printf("maxGitlabExecutorAge: %v\n", config.GitlabExecutors.MaxAge)
+	log.Printf("maxGitlabExecutorCompletedAge: %v\n", config.GitlabExecutors.MaxCompletedAge)
+	log.Printf("maxGitlabExecutorStuckAge: %v\n", config.GitlabExecutors.MaxStuckAge)
+	log.Printf("maxGitlabExecutorUnreachableAge: %v\n", config.GitlabExecutors.MaxUnreachableAge)
+	log.Printf("forceDeleteUnreachableExecutors: %v\n", config.GitlabExecutors.ForceDeleteUnreachable)
+	log.Printf("maxGitlabExecutorOrphanAge: %v\n", config.GitlabExecutors.MaxOrphanAge)
+	for _, target := range config.GitlabExecutors.Targets {
+		log.Printf("gitlab runner target: namespace: %s, labelSelector: %s\n", target.Namespace, target.LabelSelector)
+	}
+	log.Printf("maxReviewNamespaceAge: %v\n", config.Namespaces.MaxReviewAge)
+	log.Printf("maxBuildNamespaceAge: %v\n", config.Namespaces.MaxBuildAge)
+	for _, class := range config.Namespaces.Classes {
+		log.Printf("namespace class: %s, namePattern: %s, labelSelector: %s, maxAge: %v\n", class.Name, class.NamePattern, class.LabelSelector, class.MaxAge)
+	}
+	for _, parser := range config.Namespaces.NameParsers {
+		log.Printf("namespace name parser: %s\n", parser)
+	}
+	log.Printf("optOutAnnotations: %v\n", strings.Join(config.Namespaces.OptOutAnnotations, ","))
+	log.Printf("ttlAnnotation: %v\n", config.Namespaces.TTLAnnotation)
+	log.Printf("keepUntilAnnotation: %v\n", config.Namespaces.KeepUntilAnnotation)
+	log.Printf("lastUsedAtAnnotation: %v\n", config.Namespaces.LastUsedAtAnnotation)
+	log.Printf("maxNamespaceExtension: %v\n", config.Namespaces.MaxExtension.Duration)
+	log.Printf("onlyUseAgesOf: %v\n", strings.Join(config.Namespaces.OnlyUseAgesOf, ","))
+	log.Printf("useNamespaceGCPolicies: %v\n", config.Namespaces.UsePolicies)
+	log.Printf("policyAllowlist: %v\n", strings.Join(config.Namespaces.PolicyAllowlist, ","))
+	log.Printf("annotateNamespaces: %v\n", config.Namespaces.Annotate)
+	log.Printf("hibernateNamespacesAfter: %v\n", config.Namespaces.HibernateAfter.Duration)
+	log.Printf("namespaceWarningPeriod: %v\n", config.Namespaces.WarningPeriod.Duration)
+	log.Printf("mode: %v\n", config.Mode)
+	log.Printf("logFormat: %v\n", config.LogFormat)
+	log.Printf("events: %v\n", config.Events.Enabled)
+	log.Printf("eventsDeletionNotice: %v\n", config.Events.DeletionNotice)
+	log.Printf("archive: %v\n", config.Archive.Enabled)
+	log.Printf("archiveDirectory: %v\n", config.Archive.Directory)
+	log.Printf("archiveS3Endpoint: %v\n", config.Archive.S3.Endpoint)
+	log.Printf("archiveS3Bucket: %v\n", config.Archive.S3.Bucket)
+	log.Printf("archiveRetention: %v\n", config.Archive.Retention.Duration)
+	log.Printf("archiveTimeout: %v\n", config.Archive.Timeout.Duration)
+	log.Printf("metricsAddress: %v\n", config.Metrics.Address)
+	log.Printf("pushgatewayURL: %v\n", config.Metrics.PushgatewayURL)
+	log.Printf("resyncInterval: %v\n", config.ResyncInterval)
+	log.Printf("leaderElect: %v\n", config.LeaderElection.Enabled)
+	log.Printf("leaderElectionLeaseName: %v\n", config.LeaderElection.LeaseName)
+	log.Printf("leaderElectionLeaseNamespace: %v\n", config.LeaderElection.LeaseNamespace)
+	log.Printf("leaderElectionIdentity: %v\n", config.LeaderElection.Identity)
+	log.Printf("leaderElectionLeaseDuration: %v\n", config.LeaderElection.LeaseDuration)
+	log.Printf("leaderElectionRenewDeadline: %v\n", config.LeaderElection.RenewDeadline)
+	log.Printf("leaderElectionRetryPeriod: %v\n", config.LeaderElection.RetryPeriod)
+}
+
+// runContext stops a run on signals, the api calls and archives of the run are bound by their own deadlines
+func runContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func runOneshot(k8s kubernetes.Interface, policyClient dynamic.Interface, config gc.Config, rules gc.NamespaceRules, runnerTargets []gc.RunnerTarget, events *gc.Events) {
+	ctx, cancel := runContext()
+	defer cancel()
+
+	apiCtx, cancelAPI := context.WithTimeout(ctx, time.Minute)
+	defer cancelAPI()
+
+	rules = withPolicies(apiCtx, policyClient, rules)
+
+	executorsErr := gc.GitlabExecutors(apiCtx, k8s, runnerTargets, config.DryRun)
+	namespacesErr := gc.ContinuousIntegrationNamespaces(ctx, k8s, rules, config.DryRun)
+
+	events.Flush(10 * time.Second)
+
+	if config.Metrics.PushgatewayURL != "" {
+		pushMetrics(config.Metrics.PushgatewayURL, config.Metrics.PushgatewayJob)
+	}
+
+	if executorsErr != nil {
+		log.Fatalf("failed to clean up gitlab executors: %v", executorsErr)
+	}
+
+	if namespacesErr != nil {
+		log.Fatalf("failed to clean up ci namespaces: %v", namespacesErr)
+	}
+}
+
+func runController(k8s kubernetes.Interface, policyClient dynamic.Interface, config gc.Config, rules gc.NamespaceRules, runnerTargets []gc.RunnerTarget, leaderElection *gc.LeaderElectionConfig) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		selectedFuncs = append(selectedFuncs, ageFn)
+	if config.Metrics.Address != "" {
+		serveMetrics(ctx, config.Metrics.Address)
 	}
 
-	return selectedFuncs, nil
+	controller, err := gc.NewController(k8s, gc.ControllerOptions{
+		ResyncInterval: config.ResyncInterval.Duration,
+		RunnerTargets:  runnerTargets,
+		Rules:          rules,
+		DryRun:         config.DryRun,
+		PolicyClient:   policyClient,
+	})
+	if err != nil {
+		log.Fatalf("failed to initialize controller: %v", err)
+	}
+
+	// standbys keep their caches warm as well to take over without delay
+	err = controller.Start(ctx)
+	if err != nil {
+		log.Fatalf("failed to start controller: %v", err)
+	}
+
+	if leaderElection == nil {
+		controller.Run(ctx, 1)
+		return
+	}
+
+	err = gc.RunAsLeader(ctx, k8s, *leaderElection, func(ctx context.Context) {
+		controller.Run(ctx, 1)
+	})
+	if err != nil {
+		log.Fatalf("failed to run controller: %v", err)
+	}
+}
+
+// inClusterNamespace returns the namespace of the service account the gc runs as
+func inClusterNamespace() string {
+	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(namespace))
+}
+
+func provideKubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	k8sConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubernetes configuration: %v", err)
+	}
+	return k8sConfig, nil
 }
 
-func keysFrom(ageFuncsMap map[string]gc.YoungestResourceAgeFunc) []string {
-	validKeys := []string{}
-	for key := range ageFuncsMap {
-		validKeys = append(validKeys, key)
+// newLogger writes structured logs, log.Printf is routed through it once it is the default logger
+func newLogger(out io.Writer, format string) *slog.Logger {
+	if format == gc.LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(out, nil))
 	}
-	return validKeys
+	return slog.New(slog.NewTextHandler(out, nil))
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+)
+
+// serveMetrics exposes the metrics on /metrics until the context is canceled
+func serveMetrics(ctx context.Context, address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gc.MetricsRegistry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to serve metrics: %v", err)
+		}
+	}()
+}
+
+// pushMetrics replaces the metrics of the job in the pushgateway, failures are logged only to not fail the garbage collection
+func pushMetrics(url, job string) {
+	err := push.New(url, job).Gatherer(gc.MetricsRegistry).Push()
+	if err != nil {
+		log.Printf("failed to push metrics to %s: %v", url, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+	"go.yaml.in/yaml/v3"
+)
+
+// output formats of the plan subcommand
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// commandFlags are only registered for the subcommand using them
+type commandFlags struct {
+	output *string
+	plan   *string
+}
+
+func registerCommandFlags(command string) commandFlags {
+	flags := commandFlags{output: new(string), plan: new(string)}
+
+	switch command {
+	case planCommand:
+		flags.output = flag.String("o", outputTable, fmt.Sprintf("output format of the plan, \"%s\", \"%s\" or \"%s\"", outputJSON, outputYAML, outputTable))
+	case applyCommand:
+		flags.plan = flag.String("plan", "", "path to a plan written by the plan subcommand as JSON or YAML")
+	}
+
+	return flags
+}
+
+// runPlan writes the decisions about all ci namespaces and executor pods to stdout, nothing is deleted
+func runPlan(config gc.Config, output string) {
+	if output != outputJSON && output != outputYAML && output != outputTable {
+		log.Fatalf("unknown output format \"%s\", valid formats are: \"%s\", \"%s\", \"%s\"", output, outputJSON, outputYAML, outputTable)
+	}
+
+	k8s, policyClient := newClients(config)
+	rules, runnerTargets := newRules(config, k8s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rules = withPolicies(ctx, policyClient, rules)
+
+	// namespaces whose age sources failed are kept in the plan, it is written before failing
+	plan, planErr := gc.NewPlan(ctx, k8s, rules, runnerTargets)
+	if planErr != nil && plan.Items == nil {
+		log.Fatalf("failed to plan: %v", planErr)
+	}
+
+	err := writePlan(os.Stdout, plan, output)
+	if err != nil {
+		log.Fatalf("failed to write plan: %v", err)
+	}
+
+	if planErr != nil {
+		log.Fatalf("failed to plan: %v", planErr)
+	}
+}
+
+// runApply deletes and schedules exactly the objects the plan deletes and schedules, objects changed since planning are refused
+func runApply(config gc.Config, path string) {
+	if path == "" {
+		log.Fatalf("usage: %s %s -plan <plan.json> [flags]", os.Args[0], applyCommand)
+	}
+
+	plan, err := gc.LoadPlan(path)
+	if err != nil {
+		log.Fatalf("failed to load plan: %v", err)
+	}
+
+	k8s, _ := newClients(config)
+	rules, runnerTargets := newRules(config, k8s)
+	events := withEvents(config, k8s, &rules, runnerTargets)
+	withArchive(config, k8s, &rules)
+
+	ctx, cancel := runContext()
+	defer cancel()
+
+	err = gc.ApplyPlan(ctx, k8s, rules, plan, config.DryRun)
+	events.Flush(10 * time.Second)
+	if err != nil {
+		log.Fatalf("failed to apply plan: %v", err)
+	}
+}
+
+func writePlan(out io.Writer, plan gc.Plan, output string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	case outputYAML:
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		err := encoder.Encode(plan)
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tVERDICT\tREASON\tRULE\tAGE\tMAX AGE\tEXPIRES IN")
+	for _, item := range plan.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", item.Kind, item.ObjectName(), item.Verdict, item.Reason,
+			orDash(item.Rule), seconds(item.Age).Duration, orDash(durationOrEmpty(item.MaxAge)), orDash(durationOrEmpty(item.ExpiresIn)))
+	}
+	return w.Flush()
+}
+
+func durationOrEmpty(s int64) string {
+	if s == 0 {
+		return ""
+	}
+	return seconds(s).Duration.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	gc "github.com/utopia-planitia/k8s-gitlab-gc/lib"
+)
+
+// runWake restores the workloads of a hibernated namespace
+func runWake(config gc.Config, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: %s %s [flags] <namespace>", os.Args[0], wakeCommand)
+	}
+	name := args[0]
+
+	k8s, _ := newClients(config)
+	rules, _ := newRules(config, k8s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	woken, err := gc.WakeNamespace(ctx, k8s, rules, name, config.DryRun)
+	if err != nil {
+		log.Fatalf("failed to wake namespace %s: %v", name, err)
+	}
+
+	slog.Info("woke namespace", "namespace", name, "workloads", woken, "dryRun", config.DryRun)
+}